@@ -0,0 +1,119 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package ca
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/xuperchain/xuper-front/config"
+	serv_ca "github.com/xuperchain/xuper-front/service/ca"
+)
+
+var ErrCertUnhealthy = errors.New("cert is not healthy")
+
+func NewCertCommand() *cobra.Command {
+	certCommand := &cobra.Command{
+		Use:   "cert",
+		Short: "inspect the node's cert under tlsPath",
+	}
+	certCommand.AddCommand(newCertShowCommand())
+	certCommand.AddCommand(newCertCheckCommand())
+	return certCommand
+}
+
+func newCertShowCommand() *cobra.Command {
+	var path string
+	var net string
+	var jsonOutput bool
+
+	showCommand := &cobra.Command{
+		Use:   "show",
+		Short: "show the cert, its chain, revocation and private key status",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config.SetTlsPath(path)
+			_, err := runCertInspect(net, jsonOutput)
+			return err
+		},
+	}
+	showCommand.PersistentFlags().StringVar(&path, "Path", config.GetTlsPath(), "the path of the cert")
+	showCommand.PersistentFlags().StringVar(&net, "Net", config.GetNet(), "the name of the net")
+	showCommand.PersistentFlags().BoolVar(&jsonOutput, "Json", false, "print the report as json")
+
+	return showCommand
+}
+
+func newCertCheckCommand() *cobra.Command {
+	var path string
+	var net string
+	var jsonOutput bool
+
+	checkCommand := &cobra.Command{
+		Use:   "check",
+		Short: "check the cert is valid, chains to cacert, not revoked and matches the private key",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config.SetTlsPath(path)
+			report, err := runCertInspect(net, jsonOutput)
+			if err != nil {
+				return err
+			}
+			// 不健康时以非0退出, 便于脚本检查
+			if !report.Healthy() {
+				return ErrCertUnhealthy
+			}
+			return nil
+		},
+	}
+	checkCommand.PersistentFlags().StringVar(&path, "Path", config.GetTlsPath(), "the path of the cert")
+	checkCommand.PersistentFlags().StringVar(&net, "Net", config.GetNet(), "the name of the net")
+	checkCommand.PersistentFlags().BoolVar(&jsonOutput, "Json", false, "print the report as json")
+
+	return checkCommand
+}
+
+func runCertInspect(net string, jsonOutput bool) (*serv_ca.CertReport, error) {
+	report, err := serv_ca.InspectCert(net)
+	if err != nil {
+		fmt.Println("inspect cert failed,", err)
+		return nil, err
+	}
+	if jsonOutput {
+		out, err := json.MarshalIndent(struct {
+			*serv_ca.CertReport
+			Healthy bool `json:"healthy"`
+		}{report, report.Healthy()}, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		fmt.Println(string(out))
+		return report, nil
+	}
+	fmt.Printf("path:        %s\n", report.Path)
+	fmt.Printf("subject:     %s\n", report.Subject)
+	fmt.Printf("issuer:      %s\n", report.Issuer)
+	fmt.Printf("serial:      %s\n", report.SerialNum)
+	fmt.Printf("address:     %s\n", report.Address)
+	fmt.Printf("dns names:   %s\n", strings.Join(report.DNSNames, ","))
+	fmt.Printf("ip address:  %s\n", strings.Join(report.IPAddresses, ","))
+	fmt.Printf("not before:  %s\n", report.NotBefore.Format(time.RFC3339))
+	fmt.Printf("not after:   %s\n", report.NotAfter.Format(time.RFC3339))
+	fmt.Printf("valid:       %v\n", report.Valid)
+	fmt.Printf("chained:     %v%s\n", report.Chained, reason(report.ChainError))
+	fmt.Printf("revoked:     %v%s\n", report.Revoked, reason(report.RevokeError))
+	fmt.Printf("key match:   %v%s\n", report.KeyMatch, reason(report.KeyError))
+	fmt.Printf("healthy:     %v\n", report.Healthy())
+	return report, nil
+}
+
+func reason(err string) string {
+	if err == "" {
+		return ""
+	}
+	return " (" + err + ")"
+}
@@ -0,0 +1,44 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package ca
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/xuperchain/xuper-front/config"
+	serv_ca "github.com/xuperchain/xuper-front/service/ca"
+)
+
+func NewEnrollNetCommand() *cobra.Command {
+	var address string
+	var net string
+	var keys string
+
+	enrollNetCommand := &cobra.Command{
+		Use:   "enrollNet",
+		Short: "request ca to enroll a net with the net admin's keys",
+		Long:  ``,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config.SetKeys(keys)
+			return runEnrollNet(address, net)
+		},
+	}
+	enrollNetCommand.PersistentFlags().StringVar(&address, "Addr", "", "Address for net admin, default is the address of keys")
+	enrollNetCommand.PersistentFlags().StringVar(&net, "Net", config.GetNet(), "the name of the net")
+	enrollNetCommand.PersistentFlags().StringVar(&keys, "Keys", config.GetKeys(), "the path of the net admin's keys")
+
+	return enrollNetCommand
+}
+
+func runEnrollNet(address, net string) error {
+	err := serv_ca.EnrollNet(address, net)
+	if err != nil {
+		fmt.Println("enroll net failed,", err)
+	} else {
+		fmt.Println("enroll net success")
+	}
+	return err
+}
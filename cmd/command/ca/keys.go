@@ -0,0 +1,90 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package ca
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/xuperchain/xuper-front/config"
+	serv_ca "github.com/xuperchain/xuper-front/service/ca"
+)
+
+func NewKeysCommand() *cobra.Command {
+	keysCommand := &cobra.Command{
+		Use:   "keys",
+		Short: "manage the node's account keys and tls cert",
+	}
+	keysCommand.AddCommand(newKeysRotateCommand())
+	return keysCommand
+}
+
+func newKeysRotateCommand() *cobra.Command {
+	var net string
+	var keys string
+	var path string
+	var adminAddress string
+	var revokeOld bool
+	var jsonOutput bool
+
+	rotateCommand := &cobra.Command{
+		Use:   "rotate",
+		Short: "generate a new account, enroll it signed by the old keys, fetch its cert and cut over",
+		Long: `rotate generates a new account key pair and enrolls the new address through addNode signed by the old keys.
+It then fetches the cert of the new address and writes the new keys and cert. A running front reloads the new cert
+without restart. With --RevokeOld the old address is revoked after the cut over.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config.SetKeys(keys)
+			config.SetTlsPath(path)
+			return runKeysRotate(net, adminAddress, revokeOld, jsonOutput)
+		},
+	}
+	rotateCommand.PersistentFlags().StringVar(&net, "Net", config.GetNet(), "the name of the net")
+	rotateCommand.PersistentFlags().StringVar(&keys, "Keys", config.GetKeys(), "the path of the keys")
+	rotateCommand.PersistentFlags().StringVar(&path, "Path", config.GetTlsPath(), "the path of the cert")
+	rotateCommand.PersistentFlags().StringVar(&adminAddress, "Admin", "", "Address for net admin, default the old address")
+	rotateCommand.PersistentFlags().BoolVar(&revokeOld, "RevokeOld", false, "request ca to revoke the old address after the cut over")
+	rotateCommand.PersistentFlags().BoolVar(&jsonOutput, "Json", false, "print the result as json")
+
+	return rotateCommand
+}
+
+func runKeysRotate(net, adminAddress string, revokeOld, jsonOutput bool) error {
+	result, err := serv_ca.RotateKeys(net, adminAddress, revokeOld)
+	if result == nil {
+		fmt.Println("rotate keys failed,", err)
+		return err
+	}
+	if jsonOutput {
+		out, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+	} else {
+		fmt.Printf("net:          %s\n", result.Net)
+		fmt.Printf("old address:  %s\n", result.OldAddress)
+		fmt.Printf("new address:  %s\n", result.NewAddress)
+		fmt.Printf("old serial:   %s\n", result.OldSerial)
+		fmt.Printf("new serial:   %s\n", result.NewSerial)
+		fmt.Printf("not after:    %s\n", result.NotAfter.Format(time.RFC3339))
+		fmt.Printf("revoked:      %v\n", result.Revoked)
+	}
+	if err != nil {
+		// 已切换到新密钥, 仅撤销旧地址失败, 可使用revoke命令重试
+		fmt.Println("revoke old address failed,", err)
+		return err
+	}
+	if result.Revoked {
+		// 撤销成功后立即同步本地撤销列表
+		if err := serv_ca.GetRevokeList(net); err != nil {
+			fmt.Println("refresh revoke list failed,", err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package ca
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/xuperchain/xuper-front/config"
+	serv_ca "github.com/xuperchain/xuper-front/service/ca"
+)
+
+func NewRevokeCommand() *cobra.Command {
+	var address string
+	var net string
+	var keys string
+
+	revokeCommand := &cobra.Command{
+		Use:   "revoke",
+		Short: "request ca to revoke the node's cert using the net admin's keys",
+		Long:  ``,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config.SetKeys(keys)
+			return runRevoke(address, net)
+		},
+	}
+	revokeCommand.PersistentFlags().StringVar(&address, "Addr", "", "Address to revoke")
+	revokeCommand.PersistentFlags().StringVar(&net, "Net", config.GetNet(), "the name of the net")
+	revokeCommand.PersistentFlags().StringVar(&keys, "Keys", config.GetKeys(), "the path of the net admin's keys")
+
+	return revokeCommand
+}
+
+func runRevoke(address, net string) error {
+	if address == "" {
+		return errors.New("Addr is required")
+	}
+	err := serv_ca.RevokeNode(address, net)
+	if err != nil {
+		fmt.Println("revoke node failed,", err)
+		return err
+	}
+	fmt.Println("revoke node success")
+
+	// 撤销成功后立即同步本地撤销列表
+	if err := serv_ca.GetRevokeList(net); err != nil {
+		fmt.Println("refresh revoke list failed,", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package ca
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/xuperchain/xuper-front/config"
+	serv_ca "github.com/xuperchain/xuper-front/service/ca"
+)
+
+var ErrRevokeListInconsistent = errors.New("local revoke list is inconsistent with ca")
+
+func NewRevokeListCommand() *cobra.Command {
+	revokeListCommand := &cobra.Command{
+		Use:   "revoke-list",
+		Short: "manage the local revoke list",
+	}
+	revokeListCommand.AddCommand(newReconcileCommand())
+	return revokeListCommand
+}
+
+func newReconcileCommand() *cobra.Command {
+	var net string
+	var keys string
+	var fix bool
+	var jsonOutput bool
+
+	reconcileCommand := &cobra.Command{
+		Use:   "reconcile",
+		Short: "fetch the full revoke list from the caserver and diff it against the local table",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config.SetKeys(keys)
+			return runReconcile(net, fix, jsonOutput)
+		},
+	}
+	reconcileCommand.PersistentFlags().StringVar(&net, "Net", config.GetNet(), "the name of the net")
+	reconcileCommand.PersistentFlags().StringVar(&keys, "Keys", config.GetKeys(), "the path of the keys")
+	reconcileCommand.PersistentFlags().BoolVar(&fix, "Fix", false, "make the local revoke list consistent with the caserver")
+	reconcileCommand.PersistentFlags().BoolVar(&jsonOutput, "Json", false, "print the report as json")
+
+	return reconcileCommand
+}
+
+func runReconcile(net string, fix bool, jsonOutput bool) error {
+	report, err := serv_ca.ReconcileRevokeList(net, fix)
+	if err != nil {
+		fmt.Println("reconcile revoke list failed,", err)
+		return err
+	}
+	if jsonOutput {
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+	} else {
+		fmt.Printf("net:           %s\n", report.Net)
+		fmt.Printf("remote:        %d\n", report.Remote)
+		fmt.Printf("local:         %d\n", report.Local)
+		fmt.Printf("missing:       %s\n", strings.Join(report.Missing, ","))
+		fmt.Printf("extra:         %s\n", strings.Join(report.Extra, ","))
+		fmt.Printf("rejected:      %s\n", strings.Join(report.Rejected, ","))
+		fmt.Printf("remote digest: %s\n", report.RemoteDigest)
+		fmt.Printf("local digest:  %s\n", report.LocalDigest)
+		fmt.Printf("consistent:    %v\n", report.Consistent())
+		fmt.Printf("fixed:         %v\n", report.Fixed)
+	}
+	// 未修正的不一致以非0退出, 便于脚本检查
+	if !report.Consistent() && !report.Fixed {
+		return ErrRevokeListInconsistent
+	}
+	return nil
+}
@@ -12,14 +12,19 @@ import (
 	"runtime/pprof"
 	"syscall"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
 
 	cmd_ca "github.com/xuperchain/xuper-front/cmd/command/ca"
 	"github.com/xuperchain/xuper-front/config"
 	"github.com/xuperchain/xuper-front/dao"
 	"github.com/xuperchain/xuper-front/logs"
+	server_admin "github.com/xuperchain/xuper-front/server/admin"
+	server_localca "github.com/xuperchain/xuper-front/server/localca"
 	server_xchain "github.com/xuperchain/xuper-front/server/xchain"
 	serv_ca "github.com/xuperchain/xuper-front/service/ca"
+	serv_localca "github.com/xuperchain/xuper-front/service/localca"
+	util_cert "github.com/xuperchain/xuper-front/util/cert"
 )
 
 const defaultConfigFile = "./conf/front.yaml"
@@ -75,6 +80,11 @@ func runFrontServer() error {
 	rootCmd.AddCommand(cmd_ca.NewAddNodeCommand())
 	rootCmd.AddCommand(cmd_ca.NewGetCertCommand())
 	rootCmd.AddCommand(cmd_ca.NewGetRevokeListCmd())
+	rootCmd.AddCommand(cmd_ca.NewRevokeCommand())
+	rootCmd.AddCommand(cmd_ca.NewEnrollNetCommand())
+	rootCmd.AddCommand(cmd_ca.NewRevokeListCommand())
+	rootCmd.AddCommand(cmd_ca.NewCertCommand())
+	rootCmd.AddCommand(cmd_ca.NewKeysCommand())
 
 	return rootCmd.Execute()
 }
@@ -95,27 +105,75 @@ func main() {
 func startFront(quit chan int) {
 	// 获取配置
 	caSwitch := config.GetCaConfig().CaSwitch
-	//localCaSwitch := config.GetCaConfig().LocalCaSwitch
-	netName := config.GetNet()
+	localCaSwitch := config.GetCaConfig().LocalCaSwitch
+	log, err := logs.NewLogger("front")
+	if err != nil {
+		panic(fmt.Errorf("init front logger failed: %v", err))
+	}
 
 	// 1.联盟网络获取证书
 	if caSwitch {
-		// 1.拉取证书
-		if err := serv_ca.GetAndWriteCert(netName); err != nil {
-			// 拉取证书失败
+		if err := serv_ca.CheckCaConfig(); err != nil {
+			panic(fmt.Errorf("check ca config failed: %v", err))
+		}
+		// 本地ca模式, 本节点的证书由本地签发
+		if localCaSwitch {
+			if err := serv_localca.Init(); err != nil {
+				panic(fmt.Errorf("init local ca failed: %v", err))
+			}
+		}
+
+		// 每个网络独立获取证书、同步撤销列表和更新证书
+		for _, n := range config.GetNets() {
+			netName := n.Name
+			// 1.拉取证书
+			if err := serv_ca.GetAndWriteCert(netName); err != nil {
+				log.Error("startFront: get cert failed", "net", netName, "err", err)
+			}
+
+			// 2.定时拉取过期证书, 本地ca模式下撤销列表即本地数据库
+			if localCaSwitch {
+				if err := serv_ca.LoadRevokeSet(netName); err != nil {
+					log.Error("startFront: load revoke list failed", "net", netName, "err", err)
+				}
+			} else if err := serv_ca.GetRevokeListRegularly(netName); err != nil {
+				log.Error("startFront: start revoke sync failed", "net", netName, "err", err)
+			}
+
+			// 3.证书到期前自动更新
+			serv_ca.StartCertRenewer(netName)
 		}
 
-		// 2.定时拉取过期证书
-		if err := serv_ca.GetRevokeListRegularly(netName); err != nil {
-			// 拉取撤销证书失败
+		// 加载CRL, 按配置导出CRL
+		serv_ca.StartCrlRefresher()
+
+		// 4.证书文件变化或收到SIGHUP时重新加载tls证书
+		if err := startCertWatcher(); err != nil {
+			log.Error("startFront: start cert watcher failed", "err", err)
 		}
 	}
 
+	// 本地ca对外提供Caserver服务
+	if caSwitch && localCaSwitch && config.GetCaConfig().LocalCaPort != "" {
+		go server_localca.StartLocalCaServer(quit)
+	}
+
 	// 2.启动xchain节点代理,内部判断caSwitch
 	go server_xchain.StartXchainProxyServer(quit)
 
-	// 3.http
+	// 启动运维接口
+	if config.GetAdmin().Http != "" {
+		go server_admin.StartAdminServer(quit)
+	}
+
+	// 启动出口代理, 本地节点经由front访问其他节点
+	if config.GetOutbound().Port != "" {
+		go server_xchain.StartOutboundProxyServer(quit)
+	}
+
+	// 3.http, 提供pprof和/metrics
 	if config.GetXchainServer().Http != "" {
+		http.Handle("/metrics", promhttp.Handler())
 		go func() {
 			if err := http.ListenAndServe(config.GetXchainServer().Http, nil); err != nil {
 				panic(fmt.Errorf("pprof server failed to listen: %v", err))
@@ -123,3 +181,18 @@ func startFront(quit chan int) {
 		}()
 	}
 }
+
+// startCertWatcher 监听证书文件变化, 重新加载结果输出到日志
+func startCertWatcher() error {
+	log, err := logs.NewLogger("certWatcher")
+	if err != nil {
+		return err
+	}
+	return util_cert.StartWatcher(func(net string, err error) {
+		if err != nil {
+			log.Error("CertWatcher: reload tls cert failed", "net", net, "err", err)
+			return
+		}
+		log.Info("CertWatcher: tls cert reloaded", "net", net)
+	})
+}
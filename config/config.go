@@ -8,12 +8,39 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
 )
 
-var config *Config
+var (
+	// current 当前生效的配置, 热加载和Set时整体替换为新的副本, 已发布的配置不再修改
+	current atomic.Value
 
+	// reloadHooks 配置热加载后的回调
+	reloadHooks []func(*Config)
+	reloadMtx   sync.Mutex
+)
+
+// snapshot 当前生效的配置, 未加载配置时为nil
+func snapshot() *Config {
+	c, _ := current.Load().(*Config)
+	return c
+}
+
+// update 复制当前配置修改后整体替换
+func update(fn func(c *Config)) *Config {
+	reloadMtx.Lock()
+	defer reloadMtx.Unlock()
+	next := *snapshot()
+	fn(&next)
+	current.Store(&next)
+	return &next
+}
+
+// Config front的配置, 标记了secret:"true"的字段为敏感信息, 运维接口展示时脱敏
 type Config struct {
 	XchainServer XchainServer `yaml:"xchainServer,omitempty"`
 	DbConfig     DbConfig     `yaml:"dbConfig,omitempty"`
@@ -21,6 +48,18 @@ type Config struct {
 	NetName      string       `yaml:"netName,omitempty"`
 	Keys         string       `yaml:"keys,omitempty"`
 	Log          Log          `yaml:"log,omitempty"`
+	Routing      Routing      `yaml:"routing,omitempty"`
+	RateLimit    RateLimit    `yaml:"rateLimit,omitempty"`
+	Dedup        Dedup        `yaml:"dedup,omitempty"`
+	Validation   Validation   `yaml:"validation,omitempty"`
+	Outbound     Outbound     `yaml:"outbound,omitempty"`
+	Admin        Admin        `yaml:"admin,omitempty"`
+	Crl          Crl          `yaml:"crl,omitempty"`
+	Keystore     Keystore     `yaml:"keystore,omitempty"`
+	// 密码学类型, ecdsa(默认)或gm(国密), 决定账户私钥签名、地址生成和证书解析使用的算法
+	CryptoType string `yaml:"cryptoType,omitempty"`
+	// 同时服务的多个联盟网络, 为空时只服务netName
+	Nets []Net `yaml:"nets,omitempty"`
 }
 
 //SetDefaults set default values
@@ -42,6 +81,12 @@ type XchainServer struct {
 	TlsVerify bool   `yaml:"tlsVerify,omitempty"`
 	Master    string `yaml:"master,omitempty"`
 	Http      string `yaml:"http, omitempty"`
+	// 多个xchain节点地址, 配置后忽略host
+	Hosts []string `yaml:"hosts,omitempty"`
+	// 节点选择策略: primary-backup/round-robin/least-inflight
+	UpstreamPolicy string `yaml:"upstreamPolicy,omitempty"`
+	// 节点探活间隔, 单位秒
+	HealthCheckInterval int `yaml:"healthCheckInterval,omitempty"`
 }
 
 //SetDefaults set default values
@@ -51,8 +96,8 @@ func (c XchainServer) SetDefaults() {
 type DbConfig struct {
 	DbType          string `yaml:"dbType,omitempty"`
 	DbPath          string `yaml:"dbPath,omitempty"`
-	MysqlDbUser     string `yaml:"mysqlDbUser,omitempty"`
-	MysqlDbPwd      string `yaml:"mysqlDbPwd,omitempty"`
+	MysqlDbUser     string `yaml:"mysqlDbUser,omitempty" secret:"true"`
+	MysqlDbPwd      string `yaml:"mysqlDbPwd,omitempty" secret:"true"`
 	MysqlDbHost     string `yaml:"mysqlDbHost,omitempty"`
 	MysqlDbPort     string `yaml:"mysqlDbPort,omitempty"`
 	MysqlDbDatabase string `yaml:"mysqlDbDatabase,omitempty"`
@@ -63,14 +108,174 @@ func (c DbConfig) SetDefaults() {
 }
 
 type CaConfig struct {
-	CaSwitch bool   `yaml:"caSwitch,omitempty`
+	CaSwitch bool   `yaml:"caSwitch,omitempty"`
 	Host     string `yaml:"host,omitempty"`
+	// 证书到期前多久开始自动更新, 单位小时, 为0时不自动更新
+	RenewBefore int `yaml:"renewBefore,omitempty"`
+	// 检查证书是否需要更新的间隔, 单位分钟
+	RenewCheckInterval int `yaml:"renewCheckInterval,omitempty"`
+	// 撤销列表无法加载时拒绝所有连接, 默认为false即放行
+	RevokeFailClosed bool `yaml:"revokeFailClosed,omitempty"`
+	// 同步撤销列表的间隔, 单位秒, 默认600, 实际间隔有±10%的随机抖动
+	RevokeSyncInterval int `yaml:"revokeSyncInterval,omitempty"`
+	// 同步失败后指数退避重试, 重试间隔的上限, 单位秒, 默认与revokeSyncInterval相同
+	RevokeSyncMaxBackoff int `yaml:"revokeSyncMaxBackoff,omitempty"`
+	// 撤销列表超过该时间未同步成功时告警, 单位秒, 为0时不检查
+	RevokeStaleAfter int `yaml:"revokeStaleAfter,omitempty"`
+	// 撤销列表过期时拒绝新的连接
+	RevokeStaleReject bool `yaml:"revokeStaleReject,omitempty"`
+	// ca支持长轮询时每次请求在ca侧最多等待新撤销记录的时间, 单位秒, 默认60, 为负数时不使用长轮询
+	RevokeLongPoll int `yaml:"revokeLongPoll,omitempty"`
+	// 网络管理员地址, 从ca同步的撤销记录须由其中之一签名, 本地ca模式下只有这些地址和本节点的账户可以注册网络
+	NetAdmins []string `yaml:"netAdmins,omitempty"`
+	// 接受未签名或签名无效的撤销记录(仍输出审计日志), 用于兼容不返回签名的ca
+	AllowUnsignedRevoke bool `yaml:"allowUnsignedRevoke,omitempty"`
+	// 本地ca模式, front自身作为ca签发证书并提供Caserver服务, 用于测试网络, 开启后不访问host
+	LocalCaSwitch bool `yaml:"localCaSwitch,omitempty"`
+	// 本地ca服务的监听地址, 如":8080", 为空时不对外提供服务, 仅为本节点签发证书
+	LocalCaPort string `yaml:"localCaPort,omitempty"`
+	// 本地ca根证书和私钥的存放目录, 为网络和节点生成的私钥存放在其下的keys/, 默认./data/localca/
+	LocalCaPath string `yaml:"localCaPath,omitempty"`
+	// 本地ca签发的节点证书有效期, 单位天, 默认365
+	LocalCaValidity int `yaml:"localCaValidity,omitempty"`
+	// 本地ca服务使用明文, 默认使用本节点证书提供tls服务
+	LocalCaInsecure bool `yaml:"localCaInsecure,omitempty"`
+	// 访问远程ca的传输安全配置
+	Tls CaTls `yaml:"tls,omitempty"`
+}
+
+// CaTls 访问ca的tls配置, 未开启时只允许在allowInsecureCert下通过明文获取证书
+type CaTls struct {
+	Enable bool `yaml:"enable,omitempty"`
+	// 校验ca服务端证书的根证书(PEM文件), 为空且未配置fingerprint时使用系统根证书
+	RootCert string `yaml:"rootCert,omitempty"`
+	// 固定ca服务端证书的sha256指纹(十六进制, 可用冒号分隔), 只配置指纹时不校验证书链
+	Fingerprint string `yaml:"fingerprint,omitempty"`
+	// 校验ca服务端证书时使用的名称, 为空时使用host中的主机名
+	ServerName string `yaml:"serverName,omitempty"`
+	// 已获取节点证书后, 使用节点证书与ca进行双向认证
+	Mtls bool `yaml:"mtls,omitempty"`
+	// 允许通过明文连接从ca获取证书和私钥, 仅用于测试
+	AllowInsecureCert bool `yaml:"allowInsecureCert,omitempty"`
 }
 
 //SetDefaults set default values
 func (c CaConfig) SetDefaults() {
 }
 
+// Routing p2p消息的转发策略, 支持热加载
+type Routing struct {
+	// 未配置的消息类型的转发方式, 默认forward-with-response
+	Default string `yaml:"default,omitempty"`
+	// 消息类型(名称或编号) => 转发方式: forward-async/forward-with-response/drop
+	Rules map[string]string `yaml:"rules,omitempty"`
+}
+
+// RateLimit p2p消息限流配置, 令牌桶按对端节点地址区分, 支持热加载
+type RateLimit struct {
+	// 每个节点的总限制
+	Peer Limit `yaml:"peer,omitempty"`
+	// 消息类型(名称或编号) => 每个节点该类型消息的限制
+	MsgTypes map[string]Limit `yaml:"msgTypes,omitempty"`
+	// bcname => 每个节点该链消息的限制
+	Bcnames map[string]Limit `yaml:"bcnames,omitempty"`
+}
+
+// Limit 令牌桶参数, Rate为每秒帧数, 为0时不限流
+type Limit struct {
+	Rate  float64 `yaml:"rate,omitempty"`
+	Burst int     `yaml:"burst,omitempty"`
+}
+
+// Dedup 广播消息(routing中为forward-async的类型)去重缓存配置
+type Dedup struct {
+	// 缓存的最大key数量, 为0时不去重
+	Size int `yaml:"size,omitempty"`
+	// 去重时间窗口, 单位秒, 默认60
+	Ttl int `yaml:"ttl,omitempty"`
+}
+
+// Validation p2p消息转发前的校验配置, 支持热加载
+type Validation struct {
+	// 消息负载的默认上限, 单位字节, 默认1GiB
+	MaxPayload int `yaml:"maxPayload,omitempty"`
+	// 消息类型(名称或编号) => 该类型消息负载的上限
+	MsgTypes map[string]int `yaml:"msgTypes,omitempty"`
+}
+
+// Outbound 出口代理配置, 本地节点经由front访问其他节点, 使用默认网络的证书
+type Outbound struct {
+	// 出口代理监听地址, 为空时不开启; 未指定ip时只监听127.0.0.1, "unix:路径"监听unix socket
+	// 无论监听在哪个地址, 都只接受本机的连接
+	Port string `yaml:"port,omitempty"`
+	// 是否允许访问未在peers中配置的对端, 默认不允许, 开启后本机可以使用front的证书访问任意地址
+	AllowUnmapped bool `yaml:"allowUnmapped,omitempty"`
+	// 对端netURL到实际地址的映射
+	Peers []OutboundPeer `yaml:"peers,omitempty"`
+}
+
+type OutboundPeer struct {
+	// 本地节点使用的对端地址
+	NetURL string `yaml:"netURL,omitempty"`
+	// front实际连接的对端地址, 为空时与netURL相同
+	Address string `yaml:"address,omitempty"`
+}
+
+// Admin 运维接口配置
+type Admin struct {
+	// 运维接口的http监听地址, 为空时不开启, 建议只监听本地
+	Http string `yaml:"http,omitempty"`
+	// 访问运维接口需携带的token(Authorization: Bearer <token>)
+	// 为空时只提供查询接口, 且只能监听本地地址
+	Token string `yaml:"token,omitempty" secret:"true"`
+}
+
+// Crl 标准X.509撤销信息(CRL/OCSP)配置, 与ca的撤销列表同时生效
+type Crl struct {
+	// CRL来源, 文件路径或http(s)地址, 支持PEM和DER格式
+	Sources []string `yaml:"sources,omitempty"`
+	// 是否使用对端证书中的CRL分发点
+	DistributionPoints bool `yaml:"distributionPoints,omitempty"`
+	// CRL的刷新间隔, 单位分钟, 默认60
+	RefreshInterval int `yaml:"refreshInterval,omitempty"`
+	// CRL超过nextUpdate且未能刷新时拒绝该ca签发的证书, 默认只告警并继续使用过期的CRL
+	RejectStale bool `yaml:"rejectStale,omitempty"`
+	// 是否向对端证书中的OCSP地址查询证书状态
+	Ocsp bool `yaml:"ocsp,omitempty"`
+	// OCSP响应未给出nextUpdate时的缓存时间, 单位秒, 默认300
+	OcspCacheTtl int `yaml:"ocspCacheTtl,omitempty"`
+	// 导出签名CRL的文件路径, 为空时不导出
+	Export string `yaml:"export,omitempty"`
+	// 导出CRL的有效期, 单位小时, 默认24
+	ExportValidity int `yaml:"exportValidity,omitempty"`
+}
+
+// Keystore 私钥(账户私钥、tls私钥、hd私钥)的存储方式
+type Keystore struct {
+	// file: 明文文件, 权限0600(默认); encrypted: 口令加密的文件; socket: 通过unix socket访问外部签名服务
+	Type string `yaml:"type,omitempty"`
+	// encrypted时读取口令的环境变量, 默认XFRONT_KEY_PASSPHRASE
+	PassphraseEnv string `yaml:"passphraseEnv,omitempty"`
+	// encrypted时从该文件描述符读取口令, 大于0时优先于环境变量
+	PassphraseFd int `yaml:"passphraseFd,omitempty"`
+	// socket时外部签名服务的unix socket路径
+	Socket string `yaml:"socket,omitempty" secret:"true"`
+}
+
+// Net 一个联盟网络的配置, 每个网络有独立的ca、证书目录、撤销列表同步和xchain节点
+type Net struct {
+	// 网络名称, 即证书的CN, 对端握手时的SNI
+	Name string `yaml:"name,omitempty"`
+	// 该网络ca的地址, 为空时使用caConfig.host
+	CaHost string `yaml:"caHost,omitempty"`
+	// 该网络的证书目录, 为空时使用xchainServer.tlsPath
+	TlsPath string `yaml:"tlsPath,omitempty"`
+	// 访问该网络ca使用的账户私钥目录, 为空时使用keys
+	Keys string `yaml:"keys,omitempty"`
+	// 该网络的xchain节点, 为空时使用xchainServer.hosts/host
+	Hosts []string `yaml:"hosts,omitempty"`
+}
+
 type Log struct {
 	Level     string `yaml:"level,omitempty"`
 	Path      string `yaml:"path,omitempty"`
@@ -86,7 +291,7 @@ func (c *Log) SetDefaults() {
 
 func InstallFrontConfig(configFile string) error {
 	// 从配置文件中加载配置
-	config = &Config{}
+	config := &Config{}
 	config.SetDefaults()
 
 	filePath, fileName := filepath.Split(configFile)
@@ -95,7 +300,12 @@ func InstallFrontConfig(configFile string) error {
 	viper.SetConfigName(file)
 
 	viper.SetDefault("caConfig.caSwitch", "true")
-	viper.SetDefault("caConfig.localCaSwitch", "true")
+	viper.SetDefault("caConfig.localCaSwitch", false)
+	viper.SetDefault("caConfig.localCaPath", "./data/localca/")
+	viper.SetDefault("caConfig.localCaValidity", 365)
+	viper.SetDefault("caConfig.renewBefore", 168)
+	viper.SetDefault("caConfig.renewCheckInterval", 60)
+	viper.SetDefault("caConfig.revokeSyncInterval", 600)
 
 	err := viper.ReadInConfig()
 	if err != nil {
@@ -104,52 +314,111 @@ func InstallFrontConfig(configFile string) error {
 	if err := viper.Unmarshal(config); err != nil {
 		return fmt.Errorf("Config.InstallFrontConfig: Unmarshal config from file error, %v", err.Error())
 	}
+	current.Store(config)
 
-	// 监听配置变化, 重启加载
-	//viper.WatchConfig()
-	//viper.OnConfigChange(func(e fsnotify.Event) {
-	//	// 配置发生变化则重新加载
-	//	config = &Config{}
-	//	viper.Unmarshal(config)
-	//	printConfig()
-	//})
+	// 监听配置变化, 重新加载可热更新的配置
+	viper.WatchConfig()
+	viper.OnConfigChange(func(e fsnotify.Event) {
+		reloadConfig()
+	})
 
 	return nil
 }
 
+// reloadConfig 配置文件发生变化时重新加载, 目前仅routing、rateLimit和validation支持热加载
+func reloadConfig() {
+	newConfig := &Config{}
+	newConfig.SetDefaults()
+	if err := viper.Unmarshal(newConfig); err != nil {
+		return
+	}
+	config := update(func(c *Config) {
+		c.Routing = newConfig.Routing
+		c.RateLimit = newConfig.RateLimit
+		c.Validation = newConfig.Validation
+	})
+	reloadMtx.Lock()
+	hooks := reloadHooks
+	reloadMtx.Unlock()
+
+	for _, hook := range hooks {
+		hook(config)
+	}
+}
+
+// OnReload 注册配置热加载后的回调
+func OnReload(hook func(*Config)) {
+	reloadMtx.Lock()
+	defer reloadMtx.Unlock()
+	reloadHooks = append(reloadHooks, hook)
+}
+
 func printConfig() *Config {
-	return config
+	return snapshot()
 }
 
+// GetConfig 当前生效的配置, 返回的配置是只读的, 修改需通过Set方法
 func GetConfig() *Config {
-	return config
+	return snapshot()
 }
 
 func GetXchainServer() XchainServer {
-	return config.XchainServer
+	return snapshot().XchainServer
+}
+
+// GetUpstreamHosts 获取front代理的xchain节点列表, 未配置hosts时使用host
+func GetUpstreamHosts() []string {
+	config := snapshot()
+	if len(config.XchainServer.Hosts) > 0 {
+		return config.XchainServer.Hosts
+	}
+	if config.XchainServer.Host == "" {
+		return nil
+	}
+	return []string{config.XchainServer.Host}
 }
 
 func GetCaConfig() CaConfig {
-	return config.CaConfig
+	return snapshot().CaConfig
+}
+
+// GetLocalCaPath 本地ca根证书和私钥的存放目录, 以"/"结尾
+func GetLocalCaPath() string {
+	config := snapshot()
+	path := config.CaConfig.LocalCaPath
+	if strings.LastIndex(path, "/") != len([]rune(path))-1 {
+		path = path + "/"
+	}
+	return path
 }
 
 func GetDBConfig() *DbConfig {
-	return &config.DbConfig
+	return &snapshot().DbConfig
 }
 
 func SetKeys(keys string) {
-	config.Keys = keys
+	update(func(c *Config) {
+		c.Keys = keys
+	})
 }
 
 func SetTlsPath(path string) {
-	config.XchainServer.TlsPath = path
+	update(func(c *Config) {
+		c.XchainServer.TlsPath = path
+	})
 }
 
+// GetNet 默认网络, 未配置netName时使用nets中的第一个
 func GetNet() string {
+	config := snapshot()
+	if config.NetName == "" && len(config.Nets) > 0 {
+		return config.Nets[0].Name
+	}
 	return config.NetName
 }
 
 func GetKeys() string {
+	config := snapshot()
 	path := config.Keys
 	if strings.LastIndex(path, "/") != len([]rune(path))-1 {
 		path = path + "/"
@@ -158,13 +427,125 @@ func GetKeys() string {
 }
 
 func GetTlsPath() string {
+	config := snapshot()
 	path := config.XchainServer.TlsPath
+	if path == "" && len(config.Nets) > 0 {
+		path = config.Nets[0].TlsPath
+	}
 	if strings.LastIndex(path, "/") != len([]rune(path))-1 {
 		path = path + "/"
 	}
 	return path
 }
 
+// IsMultiNet 是否配置了nets
+func IsMultiNet() bool {
+	config := snapshot()
+	return len(config.Nets) > 0
+}
+
+// GetNets 获取front服务的所有网络, 未配置的字段使用全局配置, 目录均以"/"结尾
+// 未配置nets时只有netName一个网络
+func GetNets() []Net {
+	config := snapshot()
+	if len(config.Nets) == 0 {
+		return []Net{defaultNet(config.NetName)}
+	}
+	nets := make([]Net, 0, len(config.Nets))
+	for _, n := range config.Nets {
+		if n.CaHost == "" {
+			n.CaHost = config.CaConfig.Host
+		}
+		if n.TlsPath == "" {
+			n.TlsPath = config.XchainServer.TlsPath
+		}
+		if !strings.HasSuffix(n.TlsPath, "/") {
+			n.TlsPath = n.TlsPath + "/"
+		}
+		if n.Keys == "" {
+			n.Keys = config.Keys
+		}
+		if !strings.HasSuffix(n.Keys, "/") {
+			n.Keys = n.Keys + "/"
+		}
+		if len(n.Hosts) == 0 {
+			n.Hosts = GetUpstreamHosts()
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// GetNetConfig 获取某个网络的配置, 不在nets中的网络使用全局配置
+func GetNetConfig(name string) Net {
+	for _, n := range GetNets() {
+		if n.Name == name {
+			return n
+		}
+	}
+	return defaultNet(name)
+}
+
+func defaultNet(name string) Net {
+	config := snapshot()
+	return Net{
+		Name:    name,
+		CaHost:  config.CaConfig.Host,
+		TlsPath: GetTlsPath(),
+		Keys:    GetKeys(),
+		Hosts:   GetUpstreamHosts(),
+	}
+}
+
+func GetOutbound() Outbound {
+	return snapshot().Outbound
+}
+
+func SetOutbound(outbound Outbound) {
+	update(func(c *Config) {
+		c.Outbound = outbound
+	})
+}
+
+func GetAdmin() Admin {
+	return snapshot().Admin
+}
+
+func GetCrl() Crl {
+	return snapshot().Crl
+}
+
+func GetKeystore() Keystore {
+	return snapshot().Keystore
+}
+
+// GetCryptoType 密码学类型, 未加载配置时(如离线工具)为空, 即ecdsa
+func GetCryptoType() string {
+	config := snapshot()
+	if config == nil {
+		return ""
+	}
+	return config.CryptoType
+}
+
+func SetCryptoType(cryptoType string) {
+	update(func(c *Config) {
+		c.CryptoType = cryptoType
+	})
+}
+
 func GetLog() Log {
-	return config.Log
+	return snapshot().Log
+}
+
+func GetRouting() Routing {
+	return snapshot().Routing
+}
+
+func GetValidation() Validation {
+	return snapshot().Validation
+}
+
+func GetRateLimit() RateLimit {
+	return snapshot().RateLimit
 }
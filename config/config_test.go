@@ -14,3 +14,30 @@ func TestInstallFrontConfig(t *testing.T) {
 	InstallFrontConfig(defaultConfigFile)
 
 }
+
+func TestReloadConfigSnapshot(t *testing.T) {
+	if err := InstallFrontConfig(defaultConfigFile); err != nil {
+		t.Fatal(err)
+	}
+	before := GetConfig()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			reloadConfig()
+		}
+	}()
+	// 热加载与读取并发进行, 使用-race运行时不应报告数据竞争
+	for i := 0; i < 100; i++ {
+		cfg := *GetConfig()
+		_ = cfg.Routing
+		_ = GetRateLimit()
+		_ = GetValidation()
+	}
+	<-done
+
+	SetKeys("/tmp/keys/")
+	if GetKeys() != "/tmp/keys/" || before.Keys == "/tmp/keys/" {
+		t.Fatal("set should publish a new snapshot and keep the old one unchanged")
+	}
+}
@@ -4,10 +4,39 @@
 package crypto
 
 import (
+	"crypto/ecdsa"
+
+	"github.com/xuperchain/crypto/client/service/base"
+	"github.com/xuperchain/crypto/client/service/gm"
 	"github.com/xuperchain/crypto/client/service/xchain"
+
+	"github.com/xuperchain/xuper-front/config"
+)
+
+// 配置项cryptoType的取值
+const (
+	// CryptoTypeEcdsa NIST P-256 ECDSA, 默认
+	CryptoTypeEcdsa = "ecdsa"
+	// CryptoTypeGm 国密SM2/SM3
+	CryptoTypeGm = "gm"
 )
 
-// GetCryptoClient get crypto client
-func GetCryptoClient() *xchain.XchainCryptoClient {
+// CryptoClient xuper crypto的客户端, ecdsa和国密客户端都实现了该接口
+type CryptoClient interface {
+	base.CryptoClient
+	// 获取ECC公钥的json格式的表达
+	GetEcdsaPublicKeyJsonFormatStrFromPublicKey(k *ecdsa.PublicKey) (string, error)
+}
+
+// GetCryptoClient get crypto client, 根据配置的cryptoType选择ecdsa或国密客户端
+func GetCryptoClient() CryptoClient {
+	if IsGm() {
+		return &gm.GmCryptoClient{}
+	}
 	return &xchain.XchainCryptoClient{}
 }
+
+// IsGm 是否配置为国密
+func IsGm() bool {
+	return config.GetCryptoType() == CryptoTypeGm
+}
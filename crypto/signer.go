@@ -0,0 +1,76 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package crypto
+
+import (
+	gocrypto "crypto"
+	"crypto/ecdsa"
+	"errors"
+	"io"
+
+	"github.com/xuperchain/crypto/client/service/gm"
+	gmconfig "github.com/xuperchain/crypto/gm/config"
+	"github.com/xuperchain/crypto/gm/gmsm/sm2"
+)
+
+var ErrNotSm2Key = errors.New("not a sm2 key")
+
+// IsSm2Key 公钥是否在国密SM2曲线上
+func IsSm2Key(pub *ecdsa.PublicKey) bool {
+	return pub != nil && pub.Curve != nil && pub.Params().Name == gmconfig.CurveGm
+}
+
+// NewSigner 返回私钥的签名器, SM2曲线上的私钥使用SM2签名, 标准ecdsa.PrivateKey.Sign在该曲线上得到的签名无法被验证
+func NewSigner(key *ecdsa.PrivateKey) gocrypto.Signer {
+	if IsSm2Key(&key.PublicKey) {
+		return &sm2Signer{key: key}
+	}
+	return key
+}
+
+// sm2Signer 使用国密客户端签名, 与xuper的VerifyECDSA兼容
+type sm2Signer struct {
+	key *ecdsa.PrivateKey
+}
+
+func (s *sm2Signer) Public() gocrypto.PublicKey {
+	return &s.key.PublicKey
+}
+
+func (s *sm2Signer) Sign(_ io.Reader, msg []byte, _ gocrypto.SignerOpts) ([]byte, error) {
+	return (&gm.GmCryptoClient{}).SignECDSA(s.key, msg)
+}
+
+// ParseSm2PrivateKey 解析PKCS8 DER格式的SM2私钥
+func ParseSm2PrivateKey(der []byte) (*ecdsa.PrivateKey, error) {
+	key, err := sm2.ParsePKCS8UnecryptedPrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	return &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: key.Curve,
+			X:     key.X,
+			Y:     key.Y,
+		},
+		D: key.D,
+	}, nil
+}
+
+// ParseSm2PublicKey 解析PKIX DER格式的SM2公钥
+func ParseSm2PublicKey(der []byte) (*ecdsa.PublicKey, error) {
+	pub, err := sm2.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		if IsSm2Key(pub) {
+			return pub, nil
+		}
+	case *sm2.PublicKey:
+		return &ecdsa.PublicKey{Curve: pub.Curve, X: pub.X, Y: pub.Y}, nil
+	}
+	return nil, ErrNotSm2Key
+}
@@ -19,25 +19,134 @@ import (
 // 兼容旧rovoke表,存在则rename为revoke_node
 const isRevokeTableExistSql = `select count(*)  from sqlite_master where type='table' and name = 'revoke';`
 const renameTableSql = `ALTER TABLE revoke RENAME TO revoke_node;`
-const defaultSQLiteSchema = `
+
+// 旧版本revoke_node缺少撤销签名相关的列, 启动时补齐
+const isSQLiteColumnExistSql = `SELECT count(*) FROM pragma_table_info('revoke_node') WHERE name=?;`
+const isMysqlColumnExistSql = `SELECT count(*) FROM information_schema.columns WHERE table_schema=DATABASE() AND table_name='revoke_node' AND column_name=?;`
+
+// 旧版本revoke_node以id为主键、serial_num全局唯一, 多个网络的ca id和serialNum会冲突, 启动时改为按网络区分
+const isSQLiteRevokeKeyOldSql = `SELECT count(*) FROM pragma_table_info('revoke_node') WHERE pk > 0;`
+const isMysqlRevokeKeyOldSql = `SELECT count(*) FROM information_schema.key_column_usage WHERE table_schema=DATABASE() AND table_name='revoke_node' AND constraint_name='PRIMARY';`
+
+// sqlite不支持修改主键, 重建表并复制数据
+var migrateSQLiteRevokeKeySqls = []string{
+	`DROP INDEX IF EXISTS uidx_revoke_serial;`,
+	`DROP INDEX IF EXISTS uidx_revoke_net_serial;`,
+	`ALTER TABLE revoke_node RENAME TO revoke_node_v1;`,
+	sqliteRevokeNodeSchema,
+	"INSERT INTO revoke_node(`id`, `net`, `serial_num`, `create_time`, `address`, `signer`, `public_key`, `sign`) " +
+		"SELECT `id`, `net`, `serial_num`, `create_time`, `address`, `signer`, `public_key`, `sign` FROM revoke_node_v1;",
+	`DROP TABLE revoke_node_v1;`,
+}
+
+const migrateMysqlRevokeKeySql = `ALTER TABLE revoke_node MODIFY id BIGINT NOT NULL, DROP PRIMARY KEY, ADD PRIMARY KEY(net, id),
+    DROP INDEX uidx_revoke_serial, ADD UNIQUE KEY uidx_revoke_net_serial(net, serial_num);`
+
+var revokeSignColumns = [][2]string{
+	{"address", "address varchar(100) NOT NULL DEFAULT ''"},
+	{"signer", "signer varchar(100) NOT NULL DEFAULT ''"},
+	{"public_key", "public_key varchar(512) NOT NULL DEFAULT ''"},
+	{"sign", "sign varchar(512) NOT NULL DEFAULT ''"},
+}
+// revoke_node的id为ca中的id, 本地ca模式下为网络内自增
+const sqliteRevokeNodeSchema = `
 create table if not exists revoke_node (
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    id INTEGER NOT NULL,
 	net varchar(100) NOT NULL,
     serial_num varchar(100) NOT NULL,
+    create_time int(10) NOT NULL,
+    address varchar(100) NOT NULL DEFAULT '',
+    signer varchar(100) NOT NULL DEFAULT '',
+    public_key varchar(512) NOT NULL DEFAULT '',
+    sign varchar(512) NOT NULL DEFAULT '',
+    PRIMARY KEY (net, id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS uidx_revoke_net_serial ON revoke_node(net, serial_num);
+`
+
+const defaultSQLiteSchema = sqliteRevokeNodeSchema + `
+create table if not exists revoke_checkpoint (
+    net varchar(100) PRIMARY KEY NOT NULL,
+    last_id INTEGER NOT NULL,
+    serial_num varchar(100) NOT NULL,
+    create_time int(10) NOT NULL,
+    update_time int(10) NOT NULL
+);
+create table if not exists ca_net (
+    net varchar(100) PRIMARY KEY NOT NULL,
+    admin_address varchar(100) NOT NULL,
+    hd_master_key text NOT NULL,
+    create_time int(10) NOT NULL
+);
+create table if not exists ca_node (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    net varchar(100) NOT NULL,
+    address varchar(100) NOT NULL,
+    admin_address varchar(100) NOT NULL,
+    serial_num varchar(100) NOT NULL,
+    cert text NOT NULL,
+    private_key text NOT NULL,
+    hd_private_key text NOT NULL,
+    not_after int(10) NOT NULL,
+    create_time int(10) NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS uidx_ca_node ON ca_node(net, address);
+create table if not exists ca_cert (
+    serial_num varchar(100) PRIMARY KEY NOT NULL,
+    net varchar(100) NOT NULL,
+    address varchar(100) NOT NULL,
+    not_after int(10) NOT NULL,
     create_time int(10) NOT NULL
 );
-CREATE UNIQUE INDEX IF NOT EXISTS uidx_revoke_serial ON revoke_node(serial_num);
 `
 
 // mysql revoke关键字冲突,修改表名revoke_node
 const defaultMysqlSchema = `
 create table if not exists revoke_node(
+    id BIGINT NOT NULL,
+    net varchar(100) NOT NULL,
+    serial_num varchar(100) NOT NULL,
+    create_time int(10) NOT NULL,
+    address varchar(100) NOT NULL DEFAULT '',
+    signer varchar(100) NOT NULL DEFAULT '',
+    public_key varchar(512) NOT NULL DEFAULT '',
+    sign varchar(512) NOT NULL DEFAULT '',
+    PRIMARY KEY(net, id),
+    UNIQUE KEY uidx_revoke_net_serial(net, serial_num)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='节点撤销表';
+create table if not exists revoke_checkpoint(
+    net varchar(100) PRIMARY KEY NOT NULL,
+    last_id BIGINT NOT NULL,
+    serial_num varchar(100) NOT NULL,
+    create_time int(10) NOT NULL,
+    update_time int(10) NOT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='撤销列表同步进度表';
+create table if not exists ca_net(
+    net varchar(100) PRIMARY KEY NOT NULL,
+    admin_address varchar(100) NOT NULL,
+    hd_master_key text NOT NULL,
+    create_time int(10) NOT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='本地ca网络表';
+create table if not exists ca_node(
     id INTEGER PRIMARY KEY AUTO_INCREMENT NOT NULL,
     net varchar(100) NOT NULL,
+    address varchar(100) NOT NULL,
+    admin_address varchar(100) NOT NULL,
     serial_num varchar(100) NOT NULL,
+    cert text NOT NULL,
+    private_key text NOT NULL,
+    hd_private_key text NOT NULL,
+    not_after int(10) NOT NULL,
     create_time int(10) NOT NULL,
-    UNIQUE KEY uidx_revoke_serial(serial_num)
-) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8 COMMENT='节点撤销表';
+    UNIQUE KEY uidx_ca_node(net, address)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8 COMMENT='本地ca节点表';
+create table if not exists ca_cert(
+    serial_num varchar(100) PRIMARY KEY NOT NULL,
+    net varchar(100) NOT NULL,
+    address varchar(100) NOT NULL,
+    not_after int(10) NOT NULL,
+    create_time int(10) NOT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='本地ca签发的证书表';
 `
 
 type CaDb struct {
@@ -115,6 +224,14 @@ func InitTables() {
 		log.Println(err)
 		panic("create table failed")
 	}
+	if err := addRevokeSignColumns(dbConn, isSQLiteColumnExistSql); err != nil {
+		log.Println(err)
+		panic("alter table failed")
+	}
+	if err := migrateRevokeKey(dbConn, isSQLiteRevokeKeyOldSql, migrateSQLiteRevokeKeySqls); err != nil {
+		log.Println(err)
+		panic("migrate revoke_node failed")
+	}
 	log.Println("init tables success")
 	return
 }
@@ -132,10 +249,59 @@ func InitMysqlTable() {
 		log.Println(err)
 		panic("create table failed")
 	}
+	if err := addRevokeSignColumns(dbConn, isMysqlColumnExistSql); err != nil {
+		log.Println(err)
+		panic("alter table failed")
+	}
+	if err := migrateRevokeKey(dbConn, isMysqlRevokeKeyOldSql, []string{migrateMysqlRevokeKeySql}); err != nil {
+		log.Println(err)
+		panic("migrate revoke_node failed")
+	}
 	log.Println("init tables success")
 	return
 }
 
+// addRevokeSignColumns 为旧版本的revoke_node补齐撤销签名相关的列
+func addRevokeSignColumns(dbConn *CaDb, isColumnExistSql string) error {
+	for _, column := range revokeSignColumns {
+		total := 0
+		if err := dbConn.db.Get(&total, isColumnExistSql, column[0]); err != nil {
+			return err
+		}
+		if total > 0 {
+			continue
+		}
+		if _, err := dbConn.db.Exec("ALTER TABLE revoke_node ADD COLUMN " + column[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateRevokeKey 将旧版本revoke_node的主键改为(net, id), 唯一索引改为(net, serial_num)
+// 旧版本的主键只有id一列
+func migrateRevokeKey(dbConn *CaDb, isKeyOldSql string, migrateSqls []string) error {
+	total := 0
+	if err := dbConn.db.Get(&total, isKeyOldSql); err != nil {
+		return err
+	}
+	if total != 1 {
+		return nil
+	}
+	tx, err := dbConn.db.Beginx()
+	if err != nil {
+		return err
+	}
+	for _, migrateSql := range migrateSqls {
+		if _, err := tx.Exec(migrateSql); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	log.Println("migrate revoke_node primary key to (net, id)")
+	return tx.Commit()
+}
+
 // 初始化mysql connect配置
 func InitMysqlConnect() (string, error) {
 	username := config.GetDBConfig().MysqlDbUser
@@ -0,0 +1,83 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package dao
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/xuperchain/xuper-front/config"
+)
+
+// 旧版本的revoke_node, 以id为主键、serial_num全局唯一
+const oldSQLiteRevokeSchema = `
+create table revoke_node (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+	net varchar(100) NOT NULL,
+    serial_num varchar(100) NOT NULL,
+    create_time int(10) NOT NULL
+);
+CREATE UNIQUE INDEX uidx_revoke_serial ON revoke_node(serial_num);
+INSERT INTO revoke_node(id, net, serial_num, create_time) VALUES (1, 'net1', '100', 1600000000);
+`
+
+func TestMigrateRevokeKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dao")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dbPath := filepath.Join(dir, "ca.db")
+	old, err := sqlx.Connect("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := old.Exec(oldSQLiteRevokeSchema); err != nil {
+		t.Fatal(err)
+	}
+	old.Close()
+
+	path := filepath.Join(dir, "dao_front.yaml")
+	if err := ioutil.WriteFile(path, []byte("dbConfig:\n  dbType: sqlite3\n  dbPath: "+dbPath+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.InstallFrontConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	defer config.InstallFrontConfig("../conf/front.yaml")
+	caDb = nil
+	defer func() { caDb = nil }()
+	InitTables()
+	// 再次启动不重复迁移
+	InitTables()
+
+	total := 0
+	if err := GetDbInstance().db.Get(&total, isSQLiteRevokeKeyOldSql); err != nil || total != 2 {
+		t.Fatalf("primary key should be (net, id), got %d columns: %v", total, err)
+	}
+
+	revokeDao := &RevokeDao{}
+	revoke, err := revokeDao.GetBySerialNum("net1", "100")
+	if err != nil || revoke.Id != 1 {
+		t.Fatalf("existing revoke should be kept: %v", err)
+	}
+	// 不同网络的ca id和serialNum互不冲突
+	if _, err := revokeDao.SaveBatch("net2", []*Revoke{{Id: 1, SerialNum: "100", CreateTime: 1600000001}}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if total, err := revokeDao.Count("net2"); err != nil || total != 1 {
+		t.Fatalf("revoke of another net should be saved, total %d: %v", total, err)
+	}
+	if _, err := revokeDao.GetBySerialNum("net2", "100"); err != nil {
+		t.Fatal(err)
+	}
+	// 同一网络内serialNum仍唯一
+	if _, err := GetDbInstance().db.Exec("INSERT INTO revoke_node(`id`, `net`, `serial_num`, `create_time`) VALUES (2, 'net1', '100', 0)"); err == nil {
+		t.Fatal("duplicate serial in one net should be rejected")
+	}
+}
@@ -0,0 +1,196 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package dao
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/xuperchain/xuper-front/logs"
+)
+
+// CaNet 本地ca中注册的网络
+type CaNet struct {
+	Net          string `db:"net"`
+	AdminAddress string `db:"admin_address"`
+	// 旧版本明文保存的hd根私钥, 读取后迁移到keystore并清空
+	HdMasterKey string `db:"hd_master_key"`
+	CreateTime  int64  `db:"create_time"`
+}
+
+// CaNode 本地ca中注册的节点及其当前证书
+type CaNode struct {
+	Id           int64  `db:"id"`
+	Net          string `db:"net"`
+	Address      string `db:"address"`
+	AdminAddress string `db:"admin_address"`
+	SerialNum    string `db:"serial_num"`
+	Cert         string `db:"cert"`
+	// 旧版本明文保存的私钥, 读取后迁移到keystore并清空, 新的私钥不写入数据库
+	PrivateKey   string `db:"private_key"`
+	HdPrivateKey string `db:"hd_private_key"`
+	NotAfter     int64  `db:"not_after"`
+	CreateTime   int64  `db:"create_time"`
+}
+
+// 本地ca模式下的网络、节点和证书
+type LocalCaDao struct {
+	Log logs.Logger
+}
+
+// 查询网络, 不存在时返回nil
+func (localCaDao *LocalCaDao) GetNet(net string) (*CaNet, error) {
+	var caNet CaNet
+	caDb := GetDbInstance()
+	err := caDb.db.Get(&caNet, "SELECT * FROM ca_net WHERE net=?", net)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		localCaDao.Log.Warn("LocalCaDao.GetNet", "err", err)
+		return nil, err
+	}
+	return &caNet, nil
+}
+
+// 注册网络, hd根私钥保存在keystore中, 不写入数据库
+func (localCaDao *LocalCaDao) InsertNet(caNet *CaNet) error {
+	caDb := GetDbInstance()
+	_, err := caDb.db.Exec(
+		"INSERT INTO ca_net(`net`, `admin_address`, `hd_master_key`, `create_time`) VALUES (?,?,'',?)",
+		caNet.Net,
+		caNet.AdminAddress,
+		caNet.CreateTime)
+	if err != nil {
+		localCaDao.Log.Warn("LocalCaDao.InsertNet", "err", err)
+	}
+	return err
+}
+
+// 查询节点, 不存在时返回nil
+func (localCaDao *LocalCaDao) GetNode(net string, address string) (*CaNode, error) {
+	var node CaNode
+	caDb := GetDbInstance()
+	err := caDb.db.Get(&node, "SELECT * FROM ca_node WHERE net=? AND address=?", net, address)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		localCaDao.Log.Warn("LocalCaDao.GetNode", "err", err)
+		return nil, err
+	}
+	return &node, nil
+}
+
+// 注册节点, 证书在节点首次获取时签发
+func (localCaDao *LocalCaDao) InsertNode(node *CaNode) (int64, error) {
+	caDb := GetDbInstance()
+	result, err := caDb.db.Exec(
+		"INSERT INTO ca_node(`net`, `address`, `admin_address`, `serial_num`, `cert`, `private_key`, `hd_private_key`, `not_after`, `create_time`) VALUES (?,?,?,?,?,'','',?,?)",
+		node.Net,
+		node.Address,
+		node.AdminAddress,
+		node.SerialNum,
+		node.Cert,
+		node.NotAfter,
+		node.CreateTime)
+	if err != nil {
+		localCaDao.Log.Warn("LocalCaDao.InsertNode", "err", err)
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// 更新节点的当前证书, 同时记录签发的证书, 撤销节点时一并撤销, 私钥保存在keystore中, 同时清空旧版本的明文私钥
+func (localCaDao *LocalCaDao) UpdateNodeCert(node *CaNode) error {
+	caDb := GetDbInstance()
+	tx, err := caDb.db.Beginx()
+	if err != nil {
+		localCaDao.Log.Warn("LocalCaDao.UpdateNodeCert", "err", err)
+		return err
+	}
+	_, err = tx.Exec("UPDATE ca_node SET `serial_num`=?, `cert`=?, `private_key`='', `hd_private_key`='', `not_after`=? WHERE id=?",
+		node.SerialNum,
+		node.Cert,
+		node.NotAfter,
+		node.Id)
+	if err == nil {
+		_, err = tx.Exec("INSERT INTO ca_cert(`serial_num`, `net`, `address`, `not_after`, `create_time`) VALUES (?,?,?,?,?)",
+			node.SerialNum,
+			node.Net,
+			node.Address,
+			node.NotAfter,
+			time.Now().Unix())
+	}
+	if err != nil {
+		tx.Rollback()
+		localCaDao.Log.Warn("LocalCaDao.UpdateNodeCert", "err", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// 清空网络中旧版本明文保存的hd根私钥, 迁移到keystore后调用
+func (localCaDao *LocalCaDao) ClearNetKey(net string) error {
+	caDb := GetDbInstance()
+	_, err := caDb.db.Exec("UPDATE ca_net SET `hd_master_key`='' WHERE net=?", net)
+	if err != nil {
+		localCaDao.Log.Warn("LocalCaDao.ClearNetKey", "err", err)
+	}
+	return err
+}
+
+// 清空节点旧版本明文保存的私钥, 迁移到keystore后调用
+func (localCaDao *LocalCaDao) ClearNodeKeys(id int64) error {
+	caDb := GetDbInstance()
+	_, err := caDb.db.Exec("UPDATE ca_node SET `private_key`='', `hd_private_key`='' WHERE id=?", id)
+	if err != nil {
+		localCaDao.Log.Warn("LocalCaDao.ClearNodeKeys", "err", err)
+	}
+	return err
+}
+
+// 撤销节点: 将其未过期的证书写入revoke_node并删除节点, 返回撤销的serialNum
+// revoke中为网络、节点地址以及管理员的签名, 每个证书的撤销记录使用相同的签名
+func (localCaDao *LocalCaDao) RevokeNode(revoke *Revoke) ([]string, error) {
+	net, address := revoke.Net, revoke.Address
+	caDb := GetDbInstance()
+	tx, err := caDb.db.Beginx()
+	if err != nil {
+		localCaDao.Log.Warn("LocalCaDao.RevokeNode", "err", err)
+		return nil, err
+	}
+	now := time.Now().Unix()
+	var serials []string
+	err = tx.Select(&serials, "SELECT serial_num FROM ca_cert WHERE net=? AND address=? AND not_after>?", net, address, now)
+	for i := 0; err == nil && i < len(serials); i++ {
+		total := 0
+		err = tx.Get(&total, "SELECT count(*) FROM revoke_node WHERE net=? AND serial_num=?", net, serials[i])
+		// 本地ca的撤销记录id在网络内自增
+		var id int64
+		if err == nil && total == 0 {
+			err = tx.Get(&id, "SELECT IFNULL(MAX(id), 0) + 1 FROM revoke_node WHERE net=?", net)
+		}
+		if err == nil && total == 0 {
+			_, err = tx.Exec("INSERT INTO revoke_node(`id`, `net`, `serial_num`, `create_time`, `address`, `signer`, `public_key`, `sign`) VALUES (?,?,?,?,?,?,?,?)",
+				id,
+				net,
+				serials[i],
+				revoke.CreateTime,
+				address,
+				revoke.Signer,
+				revoke.PublicKey,
+				revoke.Sign)
+		}
+	}
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM ca_node WHERE net=? AND address=?", net, address)
+	}
+	if err != nil {
+		tx.Rollback()
+		localCaDao.Log.Warn("LocalCaDao.RevokeNode", "err", err)
+		return nil, err
+	}
+	return serials, tx.Commit()
+}
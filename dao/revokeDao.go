@@ -7,14 +7,31 @@ import (
 	"database/sql"
 	"errors"
 
+	"github.com/jmoiron/sqlx"
+
 	"github.com/xuperchain/xuper-front/logs"
 )
 
+// RevokeCheckpoint 撤销列表的同步进度, 记录已同步的ca最大id及其serialNum
+type RevokeCheckpoint struct {
+	Net        string `db:"net"`
+	LastId     int64  `db:"last_id"`
+	SerialNum  string `db:"serial_num"`
+	CreateTime int64  `db:"create_time"`
+	UpdateTime int64  `db:"update_time"`
+}
+
 type Revoke struct {
 	Id         int    `db:"id"`
 	Net        string `db:"net"`
 	SerialNum  string `db:"serial_num"`
 	CreateTime int    `db:"create_time"`
+	// 被撤销的节点地址
+	Address string `db:"address"`
+	// 签署撤销的网络管理员地址、公钥和签名(十六进制)
+	Signer    string `db:"signer"`
+	PublicKey string `db:"public_key"`
+	Sign      string `db:"sign"`
 }
 
 // front 本地的撤销节点列表
@@ -22,8 +39,8 @@ type RevokeDao struct {
 	Log logs.Logger
 }
 
-// 通过serialNum查询是否存在已撤销的证书
-func (revokeDao *RevokeDao) GetBySerialNum(serialNum string) (*Revoke, error) {
+// 通过serialNum查询网络中是否存在已撤销的证书
+func (revokeDao *RevokeDao) GetBySerialNum(net string, serialNum string) (*Revoke, error) {
 	if serialNum == "" {
 		return nil, errors.New("serial_num is illegal")
 	}
@@ -31,7 +48,7 @@ func (revokeDao *RevokeDao) GetBySerialNum(serialNum string) (*Revoke, error) {
 	var revoke Revoke
 	caDb := GetDbInstance()
 	err := caDb.db.Get(&revoke,
-		"SELECT * FROM revoke_node WHERE serial_num=?", serialNum)
+		"SELECT * FROM revoke_node WHERE net=? AND serial_num=?", net, serialNum)
 	if err != nil {
 		if err != sql.ErrNoRows {
 			revokeDao.Log.Warn("RevokeDao.GetBySerialNum", "err", err)
@@ -45,11 +62,15 @@ func (revokeDao *RevokeDao) Insert(revoke *Revoke) (int64, error) {
 
 	caDb := GetDbInstance()
 	result, err := caDb.db.Exec(
-		"INSERT INTO revoke_node(`id`, `net`, `serial_num`, `create_time`) VALUES (?,?,?,?)",
+		"INSERT INTO revoke_node(`id`, `net`, `serial_num`, `create_time`, `address`, `signer`, `public_key`, `sign`) VALUES (?,?,?,?,?,?,?,?)",
 		revoke.Id,
 		revoke.Net,
 		revoke.SerialNum,
-		revoke.CreateTime)
+		revoke.CreateTime,
+		revoke.Address,
+		revoke.Signer,
+		revoke.PublicKey,
+		revoke.Sign)
 	if err != nil {
 		revokeDao.Log.Warn("RevokeDao.Insert", "err", err)
 		return 0, err
@@ -62,13 +83,138 @@ func (revokeDao *RevokeDao) Insert(revoke *Revoke) (int64, error) {
 	return id, nil
 }
 
+// 获取该网络下所有撤销证书
+func (revokeDao *RevokeDao) List(net string) ([]Revoke, error) {
+	var revokes []Revoke
+	caDb := GetDbInstance()
+	err := caDb.db.Select(&revokes, "SELECT * FROM revoke_node WHERE net=? ORDER BY id", net)
+	if err != nil {
+		revokeDao.Log.Warn("RevokeDao.List", "err", err)
+		return nil, err
+	}
+	return revokes, nil
+}
+
+// 获取该网络下serialNum之后撤销的证书, serialNum为空或不存在时返回全部
+func (revokeDao *RevokeDao) ListAfter(net string, serialNum string) ([]Revoke, error) {
+	var revokes []Revoke
+	caDb := GetDbInstance()
+	err := caDb.db.Select(&revokes,
+		"SELECT * FROM revoke_node WHERE net=? AND id > IFNULL((SELECT id FROM revoke_node WHERE net=? AND serial_num=?), 0) ORDER BY id",
+		net, net, serialNum)
+	if err != nil {
+		revokeDao.Log.Warn("RevokeDao.ListAfter", "err", err)
+		return nil, err
+	}
+	return revokes, nil
+}
+
+// 获取该网络下撤销证书的数量
+func (revokeDao *RevokeDao) Count(net string) (int, error) {
+	total := 0
+	caDb := GetDbInstance()
+	err := caDb.db.Get(&total, "SELECT count(*) FROM revoke_node WHERE net=?", net)
+	if err != nil {
+		revokeDao.Log.Warn("RevokeDao.Count", "err", err)
+		return 0, err
+	}
+	return total, nil
+}
+
 // 获取数据库中最后写入的撤销证书serialNum
 func (revokeDao *RevokeDao) GetLatestSerialNum(net string) (string, error) {
 	var revoke Revoke
 	caDb := GetDbInstance()
-	err := caDb.db.Get(&revoke, "SELECT * FROM revoke_node WHERE net=? order BY id LIMIT 1", net)
+	err := caDb.db.Get(&revoke, "SELECT * FROM revoke_node WHERE net=? ORDER BY id DESC LIMIT 1", net)
 	if err != nil {
 		return "", err
 	}
 	return revoke.SerialNum, nil
 }
+
+// 获取该网络的同步进度, 未同步过时返回nil
+func (revokeDao *RevokeDao) GetCheckpoint(net string) (*RevokeCheckpoint, error) {
+	var checkpoint RevokeCheckpoint
+	caDb := GetDbInstance()
+	err := caDb.db.Get(&checkpoint, "SELECT * FROM revoke_checkpoint WHERE net=?", net)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		revokeDao.Log.Warn("RevokeDao.GetCheckpoint", "err", err)
+		return nil, err
+	}
+	return &checkpoint, nil
+}
+
+// 在一个事务中删除removes, 写入revokes中本地不存在的证书并更新同步进度, 返回写入的条数
+// checkpoint为nil时不更新同步进度
+func (revokeDao *RevokeDao) SaveBatch(net string, revokes []*Revoke, removes []string, checkpoint *RevokeCheckpoint) (int, error) {
+	caDb := GetDbInstance()
+	tx, err := caDb.db.Beginx()
+	if err != nil {
+		revokeDao.Log.Warn("RevokeDao.SaveBatch", "err", err)
+		return 0, err
+	}
+	inserted, err := saveBatch(tx, net, revokes, removes, checkpoint)
+	if err != nil {
+		tx.Rollback()
+		revokeDao.Log.Warn("RevokeDao.SaveBatch", "err", err)
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		revokeDao.Log.Warn("RevokeDao.SaveBatch", "err", err)
+		return 0, err
+	}
+	return inserted, nil
+}
+
+func saveBatch(tx *sqlx.Tx, net string, revokes []*Revoke, removes []string, checkpoint *RevokeCheckpoint) (int, error) {
+	for _, serialNum := range removes {
+		if _, err := tx.Exec("DELETE FROM revoke_node WHERE net=? AND serial_num=?", net, serialNum); err != nil {
+			return 0, err
+		}
+	}
+	inserted := 0
+	for _, revoke := range revokes {
+		total := 0
+		if err := tx.Get(&total, "SELECT count(*) FROM revoke_node WHERE net=? AND serial_num=?", net, revoke.SerialNum); err != nil {
+			return 0, err
+		}
+		// 已存在的证书跳过, 保证重复同步是幂等的
+		if total > 0 {
+			continue
+		}
+		_, err := tx.Exec(
+			"INSERT INTO revoke_node(`id`, `net`, `serial_num`, `create_time`, `address`, `signer`, `public_key`, `sign`) VALUES (?,?,?,?,?,?,?,?)",
+			revoke.Id,
+			net,
+			revoke.SerialNum,
+			revoke.CreateTime,
+			revoke.Address,
+			revoke.Signer,
+			revoke.PublicKey,
+			revoke.Sign)
+		if err != nil {
+			return 0, err
+		}
+		inserted++
+	}
+	if checkpoint != nil {
+		_, err := tx.Exec("DELETE FROM revoke_checkpoint WHERE net=?", net)
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.Exec(
+			"INSERT INTO revoke_checkpoint(`net`, `last_id`, `serial_num`, `create_time`, `update_time`) VALUES (?,?,?,?,?)",
+			net,
+			checkpoint.LastId,
+			checkpoint.SerialNum,
+			checkpoint.CreateTime,
+			checkpoint.UpdateTime)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return inserted, nil
+}
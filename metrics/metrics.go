@@ -0,0 +1,197 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package metrics
+
+import (
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	Namespace = "xfront"
+
+	SubsystemProxy     = "proxy"
+	SubsystemCa        = "ca"
+	SubsystemParachain = "parachain"
+
+	LabelBCName      = "bcname"
+	LabelMessageType = "message"
+	LabelPeer        = "peer"
+	LabelReason      = "reason"
+	LabelResult      = "result"
+	LabelNet         = "net"
+
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+
+	// PeerUnknown 没有证书地址的对端, peer标签不使用ip, 避免标签基数无上限
+	PeerUnknown = "unknown"
+)
+
+// 鉴权拒绝原因
+const (
+	ReasonCertInvalid   = "cert_invalid"
+	ReasonCertRevoked   = "cert_revoked"
+	ReasonParachainAuth = "parachain_unauthorized"
+	ReasonAddrInvalid   = "address_invalid"
+	ReasonRateLimited   = "rate_limited"
+	ReasonInvalidMsg    = "invalid_message"
+)
+
+var DefBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+// proxy
+var (
+	// 入站stream数
+	InboundStreamCounter = prom.NewCounter(
+		prom.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: SubsystemProxy,
+			Name:      "inbound_streams_total",
+			Help:      "Total number of inbound p2p streams.",
+		})
+	// 当前活跃的入站stream数
+	InboundStreamGauge = prom.NewGauge(
+		prom.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: SubsystemProxy,
+			Name:      "inbound_streams_active",
+			Help:      "Number of active inbound p2p streams.",
+		})
+	// 入站消息数
+	InboundMsgCounter = prom.NewCounterVec(
+		prom.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: SubsystemProxy,
+			Name:      "inbound_messages_total",
+			Help:      "Total number of inbound p2p messages.",
+		},
+		[]string{LabelMessageType, LabelBCName, LabelPeer})
+	// 转发给节点的耗时
+	ForwardHistogram = prom.NewHistogramVec(
+		prom.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: SubsystemProxy,
+			Name:      "forward_duration_seconds",
+			Help:      "Histogram of forwarding messages to the upstream node.",
+			Buckets:   DefBuckets,
+		},
+		[]string{LabelMessageType})
+	// 转发给节点失败数
+	ForwardErrorCounter = prom.NewCounterVec(
+		prom.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: SubsystemProxy,
+			Name:      "forward_errors_total",
+			Help:      "Total number of errors forwarding messages to the upstream node.",
+		},
+		[]string{LabelMessageType})
+	// 鉴权拒绝数
+	AuthRejectCounter = prom.NewCounterVec(
+		prom.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: SubsystemProxy,
+			Name:      "auth_rejections_total",
+			Help:      "Total number of rejected streams or messages.",
+		},
+		[]string{LabelReason})
+	// 广播去重缓存命中情况
+	DedupCounter = prom.NewCounterVec(
+		prom.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: SubsystemProxy,
+			Name:      "dedup_lookups_total",
+			Help:      "Total number of broadcast dedup cache lookups.",
+		},
+		[]string{LabelResult})
+)
+
+// ca
+var (
+	// 撤销列表同步次数
+	RevokeSyncCounter = prom.NewCounterVec(
+		prom.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: SubsystemCa,
+			Name:      "revoke_sync_total",
+			Help:      "Total number of revoke list syncs.",
+		},
+		[]string{LabelNet, LabelResult})
+	// 最近一次同步成功的时间
+	RevokeSyncLastSuccessGauge = prom.NewGaugeVec(
+		prom.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: SubsystemCa,
+			Name:      "revoke_sync_last_success_timestamp_seconds",
+			Help:      "Unix time of the last successful revoke list sync.",
+		},
+		[]string{LabelNet})
+	// 撤销列表是否超过revokeStaleAfter未同步成功
+	RevokeListStaleGauge = prom.NewGaugeVec(
+		prom.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: SubsystemCa,
+			Name:      "revoke_list_stale",
+			Help:      "Whether the revoke list has not been synced within the staleness threshold.",
+		},
+		[]string{LabelNet})
+	// 本地撤销证书数
+	RevokedEntriesGauge = prom.NewGaugeVec(
+		prom.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: SubsystemCa,
+			Name:      "revoked_entries",
+			Help:      "Number of revoked certs held locally.",
+		},
+		[]string{LabelNet})
+	// 签名校验未通过被拒绝的撤销记录数
+	RevokeRejectedCounter = prom.NewCounterVec(
+		prom.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: SubsystemCa,
+			Name:      "revoke_rejected_total",
+			Help:      "Total number of revoke entries rejected for missing or invalid admin signatures.",
+		},
+		[]string{LabelNet})
+)
+
+// parachain
+var (
+	// 平行链群组缓存大小
+	GroupCacheSizeGauge = prom.NewGaugeVec(
+		prom.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: SubsystemParachain,
+			Name:      "group_cache_size",
+			Help:      "Number of addresses in the parachain group cache.",
+		},
+		[]string{LabelBCName})
+	// 平行链事件订阅重连次数
+	EventStreamReconnectCounter = prom.NewCounterVec(
+		prom.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: SubsystemParachain,
+			Name:      "event_stream_reconnects_total",
+			Help:      "Total number of parachain event stream reconnects.",
+		},
+		[]string{LabelBCName})
+)
+
+func init() {
+	prom.MustRegister(InboundStreamCounter)
+	prom.MustRegister(InboundStreamGauge)
+	prom.MustRegister(InboundMsgCounter)
+	prom.MustRegister(ForwardHistogram)
+	prom.MustRegister(ForwardErrorCounter)
+	prom.MustRegister(AuthRejectCounter)
+	prom.MustRegister(DedupCounter)
+
+	prom.MustRegister(RevokeSyncCounter)
+	prom.MustRegister(RevokeSyncLastSuccessGauge)
+	prom.MustRegister(RevokedEntriesGauge)
+	prom.MustRegister(RevokeListStaleGauge)
+	prom.MustRegister(RevokeRejectedCounter)
+
+	prom.MustRegister(GroupCacheSizeGauge)
+	prom.MustRegister(EventStreamReconnectCounter)
+}
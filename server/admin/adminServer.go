@@ -0,0 +1,295 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/xuperchain/xuper-front/config"
+	"github.com/xuperchain/xuper-front/dao"
+	logs "github.com/xuperchain/xuper-front/logs"
+	server_xchain "github.com/xuperchain/xuper-front/server/xchain"
+	serv_ca "github.com/xuperchain/xuper-front/service/ca"
+	serv_proxy_xchain "github.com/xuperchain/xuper-front/service/proxyxchain"
+	util_cert "github.com/xuperchain/xuper-front/util/cert"
+)
+
+const redacted = "******"
+
+// adminServer 运维接口, 与p2p端口分开监听, 用于查询front的运行状态
+type adminServer struct {
+	token string
+	log   logs.Logger
+}
+
+// CertInfo 本节点证书信息
+type CertInfo struct {
+	SerialNum string    `json:"serialNum"`
+	Subject   string    `json:"subject"`
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+}
+
+// routes 未配置token时只注册查询接口, 触发同步和刷新的接口需要token
+func (a *adminServer) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/config", a.get(a.handleConfig))
+	mux.HandleFunc("/v1/upstreams", a.get(a.handleUpstreams))
+	mux.HandleFunc("/v1/groups", a.get(a.handleGroups))
+	mux.HandleFunc("/v1/revokes", a.get(a.handleRevokes))
+	mux.HandleFunc("/v1/cert", a.get(a.handleCert))
+	mux.HandleFunc("/v1/streams", a.get(a.handleStreams))
+	if a.token != "" {
+		mux.HandleFunc("/v1/groups/refresh", a.post(a.handleGroupsRefresh))
+		mux.HandleFunc("/v1/revokes/sync", a.post(a.handleRevokesSync))
+	}
+	return mux
+}
+
+// handleConfig 当前加载的配置, 敏感信息脱敏
+func (a *adminServer) handleConfig(r *http.Request) (interface{}, error) {
+	return redact(reflect.ValueOf(*config.GetConfig())).Interface(), nil
+}
+
+// redact 深拷贝配置, 标记了secret:"true"的非零字段替换为脱敏值, 不修改原配置
+func redact(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Struct:
+		ret := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			if field.Tag.Get("secret") == "true" && !v.Field(i).IsZero() {
+				if field.Type.Kind() == reflect.String {
+					ret.Field(i).SetString(redacted)
+				}
+				// 非字符串的敏感字段保持零值
+				continue
+			}
+			ret.Field(i).Set(redact(v.Field(i)))
+		}
+		return ret
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		ret := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			ret.Index(i).Set(redact(v.Index(i)))
+		}
+		return ret
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		ret := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			ret.SetMapIndex(iter.Key(), redact(iter.Value()))
+		}
+		return ret
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		ret := reflect.New(v.Type().Elem())
+		ret.Elem().Set(redact(v.Elem()))
+		return ret
+	}
+	return v
+}
+
+// handleUpstreams xchain节点连接池状态, 可通过net指定网络, 只查询已配置的网络, 不新建连接池
+func (a *adminServer) handleUpstreams(r *http.Request) (interface{}, error) {
+	net := netOf(r)
+	if !configuredNet(net) {
+		return nil, &statusError{code: http.StatusNotFound, msg: "unknown net " + net}
+	}
+	c := serv_proxy_xchain.LookupNetP2pProxy(net)
+	if c == nil {
+		// 该网络还没有转发过消息, 连接池尚未建立
+		return []serv_proxy_xchain.UpstreamState{}, nil
+	}
+	return c.Upstreams(), nil
+}
+
+// handleGroups 缓存的平行链群组
+func (a *adminServer) handleGroups(r *http.Request) (interface{}, error) {
+	return server_xchain.Groups(), nil
+}
+
+// handleGroupsRefresh 刷新平行链群组缓存, 可通过bcname指定平行链
+func (a *adminServer) handleGroupsRefresh(r *http.Request) (interface{}, error) {
+	bcName := r.URL.Query().Get("bcname")
+	if err := server_xchain.RefreshGroups(bcName); err != nil {
+		return nil, err
+	}
+	a.log.Info("AdminServer: groups refreshed", "bcname", bcName)
+	return server_xchain.Groups(), nil
+}
+
+// handleRevokes 本地撤销证书列表, 可通过net指定网络
+func (a *adminServer) handleRevokes(r *http.Request) (interface{}, error) {
+	revokeDao := dao.RevokeDao{
+		Log: a.log,
+	}
+	return revokeDao.List(netOf(r))
+}
+
+// handleRevokesSync 立即从ca同步撤销证书列表
+func (a *adminServer) handleRevokesSync(r *http.Request) (interface{}, error) {
+	net := netOf(r)
+	if err := serv_ca.GetRevokeList(net); err != nil {
+		return nil, err
+	}
+	a.log.Info("AdminServer: revoke list synced", "net", net)
+	revokeDao := dao.RevokeDao{
+		Log: a.log,
+	}
+	return revokeDao.List(net)
+}
+
+// handleCert 本节点证书的序列号和有效期, 可通过net指定网络
+func (a *adminServer) handleCert(r *http.Request) (interface{}, error) {
+	cert, err := util_cert.LoadCert(netOf(r))
+	if err != nil {
+		return nil, err
+	}
+	return &CertInfo{
+		SerialNum: cert.SerialNumber.String(),
+		Subject:   cert.Subject.String(),
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+	}, nil
+}
+
+// handleStreams 活跃的入站stream及对端地址
+func (a *adminServer) handleStreams(r *http.Request) (interface{}, error) {
+	return server_xchain.ActiveStreams(), nil
+}
+
+func netOf(r *http.Request) string {
+	if net := r.URL.Query().Get("net"); net != "" {
+		return net
+	}
+	return config.GetNet()
+}
+
+func configuredNet(net string) bool {
+	for _, n := range config.GetNets() {
+		if n.Name == net {
+			return true
+		}
+	}
+	return false
+}
+
+////////////// http helpers ///////////////
+
+type handlerFunc func(r *http.Request) (interface{}, error)
+
+// statusError 需要以指定http状态码返回的错误, 其它错误返回500
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return e.msg
+}
+
+func (a *adminServer) get(h handlerFunc) http.HandlerFunc {
+	return a.wrap(http.MethodGet, h)
+}
+
+func (a *adminServer) post(h handlerFunc) http.HandlerFunc {
+	return a.wrap(http.MethodPost, h)
+}
+
+// wrap 校验请求方法和token, 并将结果以json返回
+func (a *adminServer) wrap(method string, h handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		if a.token != "" {
+			auth := r.Header.Get("Authorization")
+			if subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+a.token)) != 1 {
+				writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+				return
+			}
+		}
+		ret, err := h(r)
+		if err != nil {
+			a.log.Warn("AdminServer: request failed", "path", r.URL.Path, "err", err)
+			code := http.StatusInternalServerError
+			if se, ok := err.(*statusError); ok {
+				code = se.code
+			}
+			writeJSON(w, code, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, ret)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// checkListen 未配置token时只允许监听本地地址
+func checkListen(addr string, token string) error {
+	if token != "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return errors.New("admin token is required when listening on " + addr)
+}
+
+// StartAdminServer 开启运维接口服务
+func StartAdminServer(quit chan int) {
+	log, err := logs.NewLogger("adminServer")
+	if err != nil {
+		return
+	}
+	a := &adminServer{
+		token: config.GetAdmin().Token,
+		log:   log,
+	}
+	if err := checkListen(config.GetAdmin().Http, a.token); err != nil {
+		log.Error("AdminServer.StartAdminServer: refuse to start", "err", err)
+		quit <- 1
+		return
+	}
+	if a.token == "" {
+		log.Warn("AdminServer.StartAdminServer: admin token is empty, only read-only endpoints are served")
+	}
+	log.Info("AdminServer.StartAdminServer: server start", "Http", config.GetAdmin().Http)
+	if err := http.ListenAndServe(config.GetAdmin().Http, a.routes()); err != nil {
+		log.Error("AdminServer.StartAdminServer: serve failed", "err", err)
+		quit <- 1
+	}
+}
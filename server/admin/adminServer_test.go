@@ -0,0 +1,133 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xuperchain/xuper-front/config"
+	serv_proxy_xchain "github.com/xuperchain/xuper-front/service/proxyxchain"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Error(string, ...interface{}) {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Trace(string, ...interface{}) {}
+
+func serve(a *adminServer, method, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	a.routes().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdminAuth(t *testing.T) {
+	if err := config.InstallFrontConfig("../../conf/front.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	a := &adminServer{token: "secret-token", log: nopLogger{}}
+	if rec := serve(a, http.MethodGet, "/v1/config", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("request without token got %d", rec.Code)
+	}
+	if rec := serve(a, http.MethodGet, "/v1/config", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("request with wrong token got %d", rec.Code)
+	}
+	rec := serve(a, http.MethodGet, "/v1/config", "secret-token")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("request with token got %d", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "secret-token") {
+		t.Fatal("admin token should be redacted")
+	}
+	if rec := serve(a, http.MethodPost, "/v1/groups/refresh", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("post without token got %d", rec.Code)
+	}
+}
+
+func TestAdminWithoutToken(t *testing.T) {
+	if err := config.InstallFrontConfig("../../conf/front.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	a := &adminServer{log: nopLogger{}}
+	if rec := serve(a, http.MethodGet, "/v1/config", ""); rec.Code != http.StatusOK {
+		t.Fatalf("read-only endpoint got %d", rec.Code)
+	}
+	// 未配置token时不注册写接口
+	for _, path := range []string{"/v1/groups/refresh", "/v1/revokes/sync"} {
+		if rec := serve(a, http.MethodPost, path, ""); rec.Code != http.StatusNotFound {
+			t.Fatalf("%s should not be served without token, got %d", path, rec.Code)
+		}
+	}
+}
+
+func TestUpstreamsUnknownNet(t *testing.T) {
+	if err := config.InstallFrontConfig("../../conf/front.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	a := &adminServer{log: nopLogger{}}
+	if rec := serve(a, http.MethodGet, "/v1/upstreams", ""); rec.Code != http.StatusOK {
+		t.Fatalf("configured net got %d", rec.Code)
+	}
+	// 未配置的网络返回404, 且不会为其建立连接池
+	if rec := serve(a, http.MethodGet, "/v1/upstreams?net=nosuchnet", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown net got %d", rec.Code)
+	}
+	if serv_proxy_xchain.LookupNetP2pProxy("nosuchnet") != nil {
+		t.Fatal("unknown net should not create an upstream pool")
+	}
+}
+
+func TestRedact(t *testing.T) {
+	cfg := config.Config{
+		DbConfig: config.DbConfig{MysqlDbUser: "root", MysqlDbPwd: "pwd"},
+		Admin:    config.Admin{Http: "127.0.0.1:17104", Token: "token"},
+		Keystore: config.Keystore{Type: "socket", PassphraseEnv: "FRONT_PASS", PassphraseFd: 3, Socket: "/run/signer.sock"},
+	}
+	ret := redact(reflect.ValueOf(cfg)).Interface().(config.Config)
+	if ret.DbConfig.MysqlDbUser != redacted || ret.DbConfig.MysqlDbPwd != redacted || ret.Admin.Token != redacted {
+		t.Fatalf("secrets not redacted: %+v %+v", ret.DbConfig, ret.Admin)
+	}
+	if ret.Keystore.Socket != redacted {
+		t.Fatalf("keystore not redacted: %+v", ret.Keystore)
+	}
+	// 口令的环境变量名和文件描述符不是口令本身, 原样返回
+	if ret.Admin.Http != cfg.Admin.Http || ret.Keystore.Type != cfg.Keystore.Type ||
+		ret.Keystore.PassphraseEnv != cfg.Keystore.PassphraseEnv || ret.Keystore.PassphraseFd != cfg.Keystore.PassphraseFd {
+		t.Fatal("non-secret fields should be kept")
+	}
+	// 不修改原配置
+	if cfg.DbConfig.MysqlDbPwd != "pwd" || cfg.Keystore.Socket != "/run/signer.sock" {
+		t.Fatal("original config should not be modified")
+	}
+}
+
+func TestCheckListen(t *testing.T) {
+	cases := []struct {
+		addr  string
+		token string
+		ok    bool
+	}{
+		{"127.0.0.1:17104", "", true},
+		{"localhost:17104", "", true},
+		{"[::1]:17104", "", true},
+		{"0.0.0.0:17104", "", false},
+		{":17104", "", false},
+		{"10.0.0.1:17104", "", false},
+		{"0.0.0.0:17104", "token", true},
+	}
+	for _, c := range cases {
+		if err := checkListen(c.addr, c.token); (err == nil) != c.ok {
+			t.Errorf("checkListen(%q, %q) = %v", c.addr, c.token, err)
+		}
+	}
+}
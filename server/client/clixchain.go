@@ -15,6 +15,7 @@ import (
 	"github.com/golang/protobuf/proto"
 	"github.com/xuperchain/xuper-front/config"
 	logs "github.com/xuperchain/xuper-front/logs"
+	"github.com/xuperchain/xuper-front/metrics"
 	pb "github.com/xuperchain/xuperchain/service/pb"
 	"github.com/xuperchain/xupercore/lib/utils"
 
@@ -92,6 +93,7 @@ func (cli *GroupClient) Init() error {
 		cli.Cache = &groupCache{
 			value: make([]string, 0),
 		}
+		metrics.GroupCacheSizeGauge.WithLabelValues(cli.bcName).Set(0)
 		return cli.listenParachainEvent(cli.Cache)
 	}
 	err = json.Unmarshal(resp.Body, &group)
@@ -109,6 +111,7 @@ func (cli *GroupClient) Init() error {
 		value: group.GetAddrs(),
 	}
 	cli.Cache = &cache
+	metrics.GroupCacheSizeGauge.WithLabelValues(cli.bcName).Set(float64(len(cache.value)))
 	return cli.listenParachainEvent(cli.Cache)
 }
 
@@ -138,6 +141,10 @@ func (cli *GroupClient) listenParachainEvent(cache *groupCache) (err error) {
 				return err
 			}
 			// 抢注一个stream，并loop检查它
+			if cli.eventListener.subscribed {
+				metrics.EventStreamReconnectCounter.WithLabelValues(cli.bcName).Inc()
+			}
+			cli.eventListener.subscribed = true
 			cli.eventListener.stream = stream
 			cli.eventListener.listenEvent(cache)
 			return nil
@@ -157,6 +164,8 @@ type eventListener struct {
 	close  chan struct{}
 	mutex  sync.Mutex
 	log    logs.Logger
+	// subscribed 是否订阅过, 用于统计重连次数
+	subscribed bool
 }
 
 func (e *eventListener) reset() {
@@ -195,6 +204,7 @@ func (e *eventListener) listenEvent(cache *groupCache) {
 				if groups != nil {
 					e.log.Info("EventListener.listenEvent: refresh value", "value", groups, "bcname", e.bcName)
 					cache.put(groups)
+					metrics.GroupCacheSizeGauge.WithLabelValues(e.bcName).Set(float64(len(groups)))
 					time.Sleep(napDuration)
 					continue
 				}
@@ -250,6 +260,11 @@ type groupCache struct {
 	sync.RWMutex
 }
 
+// Get 返回缓存的群组地址, 不检查事件订阅
+func (c *groupCache) Get() []string {
+	return c.get()
+}
+
 func (c *groupCache) get() []string {
 	c.RLock()
 	defer c.RUnlock()
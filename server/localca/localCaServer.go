@@ -0,0 +1,136 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package localca
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/xuperchain/xuper-front/config"
+	logs "github.com/xuperchain/xuper-front/logs"
+	"github.com/xuperchain/xuper-front/pb"
+	serv_ca "github.com/xuperchain/xuper-front/service/ca"
+	serv_localca "github.com/xuperchain/xuper-front/service/localca"
+	util_cert "github.com/xuperchain/xuper-front/util/cert"
+)
+
+// localCaServer 本地ca模式下提供与远程ca相同的Caserver服务, 其他front将caConfig.host配置为本服务即可注册
+type localCaServer struct {
+	log logs.Logger
+}
+
+func (s *localCaServer) NetAdminEnroll(ctx context.Context, request *pb.EnrollNetRequest) (*pb.EnrollResponse, error) {
+	if err := serv_localca.EnrollNet(request.Sign, request.Net, request.Address); err != nil {
+		s.log.Warn("LocalCaServer.NetAdminEnroll: enroll net failed", "err", err, "net", request.Net)
+		return nil, toStatus(err)
+	}
+	return &pb.EnrollResponse{Logid: request.Logid}, nil
+}
+
+func (s *localCaServer) NodeEnroll(ctx context.Context, request *pb.EnrollNodeRequest) (*pb.EnrollResponse, error) {
+	if err := serv_localca.EnrollNode(request.Sign, request.Net, request.Address, request.AdminAddress); err != nil {
+		s.log.Warn("LocalCaServer.NodeEnroll: enroll node failed", "err", err, "net", request.Net, "address", request.Address)
+		return nil, toStatus(err)
+	}
+	return &pb.EnrollResponse{Logid: request.Logid}, nil
+}
+
+func (s *localCaServer) GetCurrentCert(ctx context.Context, request *pb.CurrentCertRequest) (*pb.CurrentCertResponse, error) {
+	cert, nodeHdPriKey, err := serv_localca.GetCurrentCert(request.Sign, request.Net, request.Address)
+	if err != nil {
+		s.log.Warn("LocalCaServer.GetCurrentCert: get cert failed", "err", err, "net", request.Net, "address", request.Address)
+		return nil, toStatus(err)
+	}
+	return &pb.CurrentCertResponse{
+		Logid:        request.Logid,
+		CaCert:       cert.CaCert,
+		Cert:         cert.Cert,
+		PrivateKey:   cert.PrivateKey,
+		NodeHdPriKey: nodeHdPriKey,
+	}, nil
+}
+
+func (s *localCaServer) GetRevokeList(ctx context.Context, request *pb.RevokeListRequest) (*pb.RevokeListResponse, error) {
+	// 请求携带等待时间时长轮询, 响应header表明本服务支持长轮询
+	var wait time.Duration
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(serv_ca.RevokeWaitKey); len(v) > 0 {
+			if secs, err := strconv.Atoi(v[0]); err == nil {
+				wait = time.Duration(secs) * time.Second
+			}
+			grpc.SetHeader(ctx, metadata.Pairs(serv_ca.RevokeLongPollKey, "1"))
+		}
+	}
+	list, err := serv_localca.WaitRevokeList(ctx, request.Sign, request.Net, request.SerialNum, wait)
+	if err != nil {
+		s.log.Warn("LocalCaServer.GetRevokeList: get revoke list failed", "err", err, "net", request.Net)
+		return nil, toStatus(err)
+	}
+	return &pb.RevokeListResponse{
+		Logid: request.Logid,
+		List:  list,
+	}, nil
+}
+
+func (s *localCaServer) RevokeCert(ctx context.Context, request *pb.RevokeNodeRequest) (*pb.RevokeNodeResponse, error) {
+	if err := serv_localca.RevokeNode(request.Sign, request.Net, request.Address); err != nil {
+		s.log.Warn("LocalCaServer.RevokeCert: revoke node failed", "err", err, "net", request.Net, "address", request.Address)
+		return nil, toStatus(err)
+	}
+	return &pb.RevokeNodeResponse{Logid: request.Logid}, nil
+}
+
+// toStatus 将本地ca的错误转换为grpc状态码
+func toStatus(err error) error {
+	switch err {
+	case serv_localca.ErrInvalidSign:
+		return status.Error(codes.Unauthenticated, err.Error())
+	case serv_localca.ErrPermissionDenied:
+		return status.Error(codes.PermissionDenied, err.Error())
+	case serv_localca.ErrNetNotEnrolled, serv_localca.ErrNodeNotEnrolled:
+		return status.Error(codes.NotFound, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// StartLocalCaServer 启动本地ca服务, 需先调用service/localca.Init
+func StartLocalCaServer(quit chan int) {
+	log, err := logs.NewLogger("localCaServer")
+	if err != nil {
+		return
+	}
+	lis, err := net.Listen("tcp", config.GetCaConfig().LocalCaPort)
+	if err != nil {
+		log.Error("LocalCaServer.StartLocalCaServer: listen failed", "err", err)
+		quit <- 1
+		return
+	}
+	var opts []grpc.ServerOption
+	if !config.GetCaConfig().LocalCaInsecure {
+		// 使用本地ca为本节点签发的证书, 其他front通过caConfig.tls校验
+		creds, err := util_cert.GenCaServerCreds()
+		if err != nil {
+			log.Error("LocalCaServer.StartLocalCaServer: load tls creds failed", "err", err)
+			quit <- 1
+			return
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	s := grpc.NewServer(opts...)
+	pb.RegisterCaserverServer(s, &localCaServer{
+		log: log,
+	})
+	log.Info("LocalCaServer.StartLocalCaServer: server start", "port", config.GetCaConfig().LocalCaPort)
+	if err := s.Serve(lis); err != nil {
+		log.Error("LocalCaServer.StartLocalCaServer: serve failed", "err", err)
+		quit <- 1
+	}
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package xchain
+
+import (
+	"container/list"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xuperchain/xuper-front/config"
+	"github.com/xuperchain/xuper-front/metrics"
+	p2p "github.com/xuperchain/xupercore/protos"
+)
+
+const (
+	// defaultDedupTTL 未配置ttl时广播消息的去重时间窗口
+	defaultDedupTTL = 60 * time.Second
+)
+
+var (
+	// broadcastDedup 广播消息去重缓存, size为0时不去重
+	broadcastDedup *dedupCache
+
+	dedupHits   uint64
+	dedupMisses uint64
+)
+
+type dedupEntry struct {
+	key    string
+	expire time.Time
+}
+
+// dedupCache 有界的TTL缓存, 超出容量时淘汰最早写入的key
+type dedupCache struct {
+	size  int
+	ttl   time.Duration
+	items map[string]*list.Element
+	order *list.List
+	mutex sync.Mutex
+}
+
+func newDedupCache(size int, ttl time.Duration) *dedupCache {
+	if ttl <= 0 {
+		ttl = defaultDedupTTL
+	}
+	return &dedupCache{
+		size:  size,
+		ttl:   ttl,
+		items: make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+// seen 判断key是否在时间窗口内出现过, 未出现过时记录该key
+func (c *dedupCache) seen(key string) bool {
+	now := time.Now()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if elem, ok := c.items[key]; ok {
+		if now.Before(elem.Value.(*dedupEntry).expire) {
+			atomic.AddUint64(&dedupHits, 1)
+			metrics.DedupCounter.WithLabelValues("hit").Inc()
+			return true
+		}
+		c.order.Remove(elem)
+		delete(c.items, key)
+	}
+	atomic.AddUint64(&dedupMisses, 1)
+	metrics.DedupCounter.WithLabelValues("miss").Inc()
+
+	// 先淘汰过期的, 仍超出容量时淘汰最早写入的
+	for elem := c.order.Front(); elem != nil; elem = c.order.Front() {
+		entry := elem.Value.(*dedupEntry)
+		if now.Before(entry.expire) && c.order.Len() < c.size {
+			break
+		}
+		c.order.Remove(elem)
+		delete(c.items, entry.key)
+	}
+	c.items[key] = c.order.PushBack(&dedupEntry{
+		key:    key,
+		expire: now.Add(c.ttl),
+	})
+	return false
+}
+
+// forget 转发失败时移除key, 使后续副本可以继续转发
+func (c *dedupCache) forget(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if elem, ok := c.items[key]; ok {
+		c.order.Remove(elem)
+		delete(c.items, key)
+	}
+}
+
+func (c *dedupCache) len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.order.Len()
+}
+
+// dedupKey 广播消息的去重key, 由logid和数据校验和组成
+func dedupKey(msg *p2p.XuperMessage) string {
+	return msg.GetHeader().GetLogid() + "/" + strconv.FormatUint(uint64(msg.GetHeader().GetDataCheckSum()), 10)
+}
+
+// DedupStats 返回广播去重缓存的命中和未命中次数
+func DedupStats() (hits uint64, misses uint64) {
+	return atomic.LoadUint64(&dedupHits), atomic.LoadUint64(&dedupMisses)
+}
+
+func initDedup(cfg config.Dedup) {
+	if cfg.Size <= 0 {
+		return
+	}
+	broadcastDedup = newDedupCache(cfg.Size, time.Duration(cfg.Ttl)*time.Second)
+}
@@ -0,0 +1,37 @@
+package xchain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDedupCache(t *testing.T) {
+	c := newDedupCache(2, time.Minute)
+	if c.seen("a") {
+		t.Errorf("first a should not be seen")
+	}
+	if !c.seen("a") {
+		t.Errorf("second a should be seen")
+	}
+	c.seen("b")
+	c.seen("c")
+	if c.len() != 2 {
+		t.Errorf("cache should be bounded, len = %d", c.len())
+	}
+	if c.seen("a") {
+		t.Errorf("a should be evicted")
+	}
+	c.forget("a")
+	if c.seen("a") {
+		t.Errorf("a should be forgotten")
+	}
+}
+
+func TestDedupCacheTTL(t *testing.T) {
+	c := newDedupCache(10, time.Millisecond)
+	c.seen("a")
+	time.Sleep(5 * time.Millisecond)
+	if c.seen("a") {
+		t.Errorf("a should be expired")
+	}
+}
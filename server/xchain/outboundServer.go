@@ -0,0 +1,264 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package xchain
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xuperchain/xuper-front/config"
+	logs "github.com/xuperchain/xuper-front/logs"
+	serv_ca "github.com/xuperchain/xuper-front/service/ca"
+	util_cert "github.com/xuperchain/xuper-front/util/cert"
+	p2p "github.com/xuperchain/xupercore/protos"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// OutboundPeerHeader 本地节点可以通过该metadata显式指定对端的netURL
+	OutboundPeerHeader = "x-front-peer"
+)
+
+var (
+	ErrPeerNotMapped = errors.New("peer is not in outbound peers")
+	ErrPeerRevoked   = errors.New("peer cert is revoked")
+)
+
+// outboundProxyServer 本地节点访问其他节点的出口代理, 本地节点连接front, front使用自己的证书连接对端
+type outboundProxyServer struct {
+	peers map[string]string
+	conns map[string]*grpc.ClientConn
+	mutex sync.Mutex
+
+	log logs.Logger
+}
+
+// resolvePeer 根据metadata或:authority确定本地节点要访问的对端, 并映射为实际地址
+func (o *outboundProxyServer) resolvePeer(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var netURL string
+	if values := md.Get(OutboundPeerHeader); len(values) > 0 {
+		netURL = values[0]
+	} else if values := md.Get(":authority"); len(values) > 0 {
+		netURL = values[0]
+	}
+	if netURL == "" {
+		return "", ErrRpcAddInvalid
+	}
+	if address, ok := o.peers[netURL]; ok {
+		return address, nil
+	}
+	if config.GetOutbound().AllowUnmapped {
+		o.log.Warn("OutboundProxyServer.resolvePeer: relay to unmapped peer", "peer", netURL)
+		return netURL, nil
+	}
+	return "", ErrPeerNotMapped
+}
+
+// getConn 复用到对端的连接, 连接失效时重建
+func (o *outboundProxyServer) getConn(address string) (*grpc.ClientConn, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if conn, ok := o.conns[address]; ok {
+		state := conn.GetState()
+		if state != connectivity.TransientFailure && state != connectivity.Shutdown {
+			return conn, nil
+		}
+		conn.Close()
+		delete(o.conns, address)
+	}
+	var conn *grpc.ClientConn
+	var err error
+	if config.GetCaConfig().CaSwitch {
+		creds, credsErr := util_cert.GenCreds(config.GetNet())
+		if credsErr != nil {
+			return nil, credsErr
+		}
+		conn, err = grpc.Dial(address, grpc.WithTransportCredentials(&revocationCheckedCreds{creds}),
+			grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(maxMessageSize), grpc.MaxCallSendMsgSize(maxMessageSize)))
+	} else {
+		conn, err = grpc.Dial(address, grpc.WithInsecure(),
+			grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(maxMessageSize), grpc.MaxCallSendMsgSize(maxMessageSize)))
+	}
+	if err != nil {
+		return nil, err
+	}
+	o.conns[address] = conn
+	return conn, nil
+}
+
+// SendP2PMessage 将本地节点的stream双向转发给对端
+func (o *outboundProxyServer) SendP2PMessage(stream p2p.P2PService_SendP2PMessageServer) error {
+	ctx := stream.Context()
+	address, err := o.resolvePeer(ctx)
+	if err != nil {
+		o.log.Warn("OutboundProxyServer.SendP2PMessage: resolve peer failed", "err", err)
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	conn, err := o.getConn(address)
+	if err != nil {
+		o.log.Error("OutboundProxyServer.SendP2PMessage: dial peer failed", "peer", address, "err", err)
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	remote, err := p2p.NewP2PServiceClient(conn).SendP2PMessage(ctx)
+	if err != nil {
+		o.log.Error("OutboundProxyServer.SendP2PMessage: open stream to peer failed", "peer", address, "err", err)
+		return err
+	}
+
+	// 本地节点 => 对端, 本地写端结束后关闭对端的写端
+	go func() {
+		for {
+			msg, err := stream.Recv()
+			if err == io.EOF {
+				remote.CloseSend()
+				return
+			}
+			if err != nil {
+				return
+			}
+			if err := remote.Send(msg); err != nil {
+				o.log.Warn("OutboundProxyServer.SendP2PMessage: relay to peer failed", "peer", address, "err", err)
+				return
+			}
+		}
+	}()
+
+	// 对端 => 本地节点
+	for {
+		msg, err := remote.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			o.log.Warn("OutboundProxyServer.SendP2PMessage: recv from peer failed", "peer", address, "err", err)
+			return err
+		}
+		if err := stream.Send(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// outboundListener 出口代理的监听, "unix:"开头时监听unix socket, 未指定ip时只监听127.0.0.1
+func outboundListener(port string) (net.Listener, error) {
+	if strings.HasPrefix(port, "unix:") {
+		return net.Listen("unix", strings.TrimPrefix(port, "unix:"))
+	}
+	host, p, err := net.SplitHostPort(port)
+	if err != nil {
+		// 只配置了端口号
+		host, p = "", port
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	return net.Listen("tcp", net.JoinHostPort(host, p))
+}
+
+// isLocalPeer 连接是否来自本机, 出口代理使用front的证书访问对端, 不能对外提供
+func isLocalPeer(ctx context.Context) bool {
+	pr, ok := peer.FromContext(ctx)
+	if !ok || pr.Addr == nil {
+		return false
+	}
+	switch addr := pr.Addr.(type) {
+	case *net.UnixAddr:
+		return true
+	case *net.TCPAddr:
+		return addr.IP.IsLoopback()
+	}
+	return false
+}
+
+// localOnlyInterceptor 拒绝非本机的连接
+func localOnlyInterceptor(log logs.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !isLocalPeer(ss.Context()) {
+			pr, _ := peer.FromContext(ss.Context())
+			log.Warn("OutboundProxyServer: reject non-local connection", "peer", pr)
+			return status.Error(codes.PermissionDenied, "outbound proxy only accepts local connections")
+		}
+		return handler(srv, ss)
+	}
+}
+
+// StartOutboundProxyServer 开启出口代理服务, 仅接受本机节点的连接
+func StartOutboundProxyServer(quit chan int) {
+	log, err := logs.NewLogger("outboundProxyServer")
+	if err != nil {
+		return
+	}
+	outbound := config.GetOutbound()
+	lis, err := outboundListener(outbound.Port)
+	if err != nil {
+		log.Error("OutboundProxyServer.StartOutboundProxyServer: listen failed", "err", err)
+		quit <- 1
+		return
+	}
+	o := &outboundProxyServer{
+		peers: make(map[string]string),
+		conns: make(map[string]*grpc.ClientConn),
+		log:   log,
+	}
+	for _, p := range outbound.Peers {
+		address := p.Address
+		if address == "" {
+			address = p.NetURL
+		}
+		o.peers[strings.TrimSpace(p.NetURL)] = address
+	}
+	if outbound.AllowUnmapped {
+		log.Warn("OutboundProxyServer.StartOutboundProxyServer: allowUnmapped is enabled, local clients can reach any address with front's certificate")
+	}
+	s := grpc.NewServer(grpc.MaxRecvMsgSize(maxMessageSize), grpc.MaxSendMsgSize(maxMessageSize),
+		grpc.MaxConcurrentStreams(MaxConcurrentStreams), grpc.ConnectionTimeout(time.Second*time.Duration(GRPCTIMEOUT)),
+		grpc.StreamInterceptor(localOnlyInterceptor(log)))
+	p2p.RegisterP2PServiceServer(s, o)
+
+	log.Info("OutboundProxyServer.StartOutboundProxyServer: server start", "Port", outbound.Port, "peers", o.peers)
+	if err := s.Serve(lis); err != nil {
+		log.Error("OutboundProxyServer.StartOutboundProxyServer: serve failed", "err", err)
+		quit <- 1
+	}
+}
+
+////////////// revocationCheckedCreds ///////////////
+
+// revocationCheckedCreds 握手完成后校验对端服务端证书是否已被撤销
+type revocationCheckedCreds struct {
+	credentials.TransportCredentials
+}
+
+func (c *revocationCheckedCreds) ClientHandshake(ctx context.Context, authority string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	conn, authInfo, err := c.TransportCredentials.ClientHandshake(ctx, authority, rawConn)
+	if err != nil {
+		return nil, nil, err
+	}
+	tlsInfo, ok := authInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.PeerCertificates) == 0 {
+		conn.Close()
+		return nil, nil, ErrUnAuthorized
+	}
+	if !serv_ca.IsValidPeerCert(config.GetNet(), tlsInfo.State.PeerCertificates[0]) {
+		conn.Close()
+		return nil, nil, ErrPeerRevoked
+	}
+	return conn, authInfo, nil
+}
+
+func (c *revocationCheckedCreds) Clone() credentials.TransportCredentials {
+	return &revocationCheckedCreds{c.TransportCredentials.Clone()}
+}
@@ -0,0 +1,161 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package xchain
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/xuperchain/xuper-front/config"
+	p2p "github.com/xuperchain/xupercore/protos"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func newOutboundTestServer() *outboundProxyServer {
+	return &outboundProxyServer{
+		peers: map[string]string{
+			"10.23.30.16:17101": "10.23.30.16:17101",
+			"node2.xuper:17101": "10.23.30.17:17101",
+		},
+		log: nopLogger{},
+	}
+}
+
+func TestResolvePeer(t *testing.T) {
+	if err := config.InstallFrontConfig("../../conf/front.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	defer config.SetOutbound(config.GetOutbound())
+	o := newOutboundTestServer()
+
+	// header优先于:authority, 映射为实际地址
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		OutboundPeerHeader, "node2.xuper:17101", ":authority", "10.23.30.16:17101"))
+	if address, err := o.resolvePeer(ctx); err != nil || address != "10.23.30.17:17101" {
+		t.Fatalf("mapped peer by header: %s, %v", address, err)
+	}
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs(":authority", "10.23.30.16:17101"))
+	if address, err := o.resolvePeer(ctx); err != nil || address != "10.23.30.16:17101" {
+		t.Fatalf("mapped peer by authority: %s, %v", address, err)
+	}
+	if _, err := o.resolvePeer(context.Background()); err != ErrRpcAddInvalid {
+		t.Fatalf("missing peer should be rejected, err = %v", err)
+	}
+
+	// 默认不允许访问未配置的对端
+	unmapped := metadata.NewIncomingContext(context.Background(), metadata.Pairs(OutboundPeerHeader, "evil.example:443"))
+	config.SetOutbound(config.Outbound{})
+	if _, err := o.resolvePeer(unmapped); err != ErrPeerNotMapped {
+		t.Fatalf("unmapped peer should be rejected by default, err = %v", err)
+	}
+	config.SetOutbound(config.Outbound{AllowUnmapped: true})
+	if address, err := o.resolvePeer(unmapped); err != nil || address != "evil.example:443" {
+		t.Fatalf("unmapped peer should be allowed when opted in: %s, %v", address, err)
+	}
+}
+
+func TestOutboundListenerDefaultsToLoopback(t *testing.T) {
+	lis, err := outboundListener(":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	if !lis.Addr().(*net.TCPAddr).IP.IsLoopback() {
+		t.Fatalf("listener without ip should bind loopback, got %s", lis.Addr())
+	}
+}
+
+func TestIsLocalPeer(t *testing.T) {
+	cases := []struct {
+		addr  net.Addr
+		local bool
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1234}, true},
+		{&net.TCPAddr{IP: net.ParseIP("::1"), Port: 1234}, true},
+		{&net.UnixAddr{Name: "/tmp/outbound.sock", Net: "unix"}, true},
+		{&net.TCPAddr{IP: net.ParseIP("10.23.30.16"), Port: 1234}, false},
+	}
+	for _, c := range cases {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: c.addr})
+		if isLocalPeer(ctx) != c.local {
+			t.Errorf("isLocalPeer(%s) should be %v", c.addr, c.local)
+		}
+	}
+	if isLocalPeer(context.Background()) {
+		t.Error("unknown peer should not be local")
+	}
+}
+
+// echoP2PServer 把收到的每一帧原样返回, 对端写端关闭后结束
+type echoP2PServer struct{}
+
+func (echoP2PServer) SendP2PMessage(stream p2p.P2PService_SendP2PMessageServer) error {
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(msg); err != nil {
+			return err
+		}
+	}
+}
+
+func TestOutboundRelay(t *testing.T) {
+	if err := config.InstallFrontConfig("../../conf/front.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	defer config.SetOutbound(config.GetOutbound())
+	config.SetOutbound(config.Outbound{})
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	p2p.RegisterP2PServiceServer(s, echoP2PServer{})
+	go s.Serve(lis)
+	defer s.Stop()
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := newOutboundTestServer()
+	o.peers["node3.xuper:17101"] = lis.Addr().String()
+	o.conns = map[string]*grpc.ClientConn{lis.Addr().String(): conn}
+	defer conn.Close()
+
+	// 已映射的对端: 帧被转发, 对端的返回回传给本地节点
+	stream := &fakeP2PStream{
+		ctx: metadata.NewIncomingContext(context.Background(), metadata.Pairs(OutboundPeerHeader, "node3.xuper:17101")),
+		in:  []*p2p.XuperMessage{newTestMsg(p2p.XuperMessage_GET_BLOCK, "xuper")},
+	}
+	if err := o.SendP2PMessage(stream); err != nil {
+		t.Fatalf("relay to mapped peer failed: %v", err)
+	}
+	if len(stream.sent) != 1 || stream.sent[0].GetHeader().GetBcname() != "xuper" {
+		t.Fatalf("peer response should be relayed back, got %v", stream.sent)
+	}
+
+	// 未映射的对端直接拒绝, 不会建立连接
+	stream = &fakeP2PStream{
+		ctx: metadata.NewIncomingContext(context.Background(), metadata.Pairs(OutboundPeerHeader, lis.Addr().String())),
+		in:  []*p2p.XuperMessage{newTestMsg(p2p.XuperMessage_GET_BLOCK, "xuper")},
+	}
+	if err := o.SendP2PMessage(stream); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("unmapped peer should be denied, err = %v", err)
+	}
+	if len(stream.sent) != 0 {
+		t.Fatal("nothing should be relayed to an unmapped peer")
+	}
+}
@@ -0,0 +1,201 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package xchain
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xuperchain/xuper-front/config"
+	p2p "github.com/xuperchain/xupercore/protos"
+)
+
+const (
+	// bucketIdleTimeout 超过该时间未使用的令牌桶会被回收
+	bucketIdleTimeout = 10 * time.Minute
+
+	limitByPeer    = "peer"
+	limitByMsgType = "msg_type"
+	limitByBcname  = "bcname"
+)
+
+var (
+	// currentLimiter 当前生效的限流器, 配置热加载时整体替换
+	currentLimiter atomic.Value
+	// rateLimitRejected 被拒绝的帧数, 按限流维度统计, 不随配置热加载重置
+	rateLimitRejected sync.Map
+)
+
+////////////// tokenBucket ///////////////
+
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit config.Limit, now time.Time) *tokenBucket {
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   limit.Rate,
+		burst:  burst,
+		tokens: burst,
+		last:   now,
+	}
+}
+
+// refill 按流逝的时间补充令牌
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+////////////// rateLimiter ///////////////
+
+// bucketKey 令牌桶按限流维度和节点地址区分
+type bucketKey struct {
+	dim  string
+	peer string
+}
+
+// rateLimiter 按节点地址限流, 同时支持按消息类型和bcname单独限流, 令牌桶均以节点地址区分
+type rateLimiter struct {
+	// limits 限流维度 => 限制, 维度如peer、msg_type/POSTTX、bcname/xuper
+	limits map[string]config.Limit
+
+	buckets   map[bucketKey]*tokenBucket
+	lastSweep time.Time
+	mutex     sync.Mutex
+}
+
+// newRateLimiter 创建限流器, prev不为空时继承限制未变化的维度的令牌桶, 热加载不会重置限流状态
+func newRateLimiter(cfg config.RateLimit, prev *rateLimiter) (*rateLimiter, error) {
+	limiter := &rateLimiter{
+		limits:    make(map[string]config.Limit),
+		buckets:   make(map[bucketKey]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+	limiter.limits[limitByPeer] = cfg.Peer
+	for name, limit := range cfg.MsgTypes {
+		msgType, err := parseMsgType(name)
+		if err != nil {
+			return nil, err
+		}
+		limiter.limits[msgTypeDim(msgType)] = limit
+	}
+	for bcname, limit := range cfg.Bcnames {
+		// viper读取的key均为小写, bcname不区分大小写
+		limiter.limits[bcnameDim(bcname)] = limit
+	}
+	if prev != nil {
+		prev.mutex.Lock()
+		defer prev.mutex.Unlock()
+		for key, bucket := range prev.buckets {
+			if limit, ok := limiter.limits[key.dim]; ok && limit == prev.limits[key.dim] {
+				// 复制一份, 切换前仍在使用旧限流器的请求不会与新限流器竞争同一个令牌桶
+				copied := *bucket
+				limiter.buckets[key] = &copied
+			}
+		}
+	}
+	return limiter, nil
+}
+
+func msgTypeDim(msgType p2p.XuperMessage_MessageType) string {
+	return limitByMsgType + "/" + msgType.String()
+}
+
+func bcnameDim(bcname string) string {
+	return limitByBcname + "/" + strings.ToLower(bcname)
+}
+
+// allow 判断该节点的这一帧是否允许转发, 不允许时返回触发限流的维度
+// 所有适用的令牌桶都有令牌时才一起扣减, 被某个维度拒绝的帧不消耗其它维度的令牌
+func (l *rateLimiter) allow(peer string, msg *p2p.XuperMessage) (bool, string) {
+	now := time.Now()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.sweep(now)
+
+	dims := []string{limitByPeer, msgTypeDim(msg.GetHeader().GetType()), bcnameDim(msg.GetHeader().GetBcname())}
+	bys := []string{limitByPeer, limitByMsgType, limitByBcname}
+	var buckets []*tokenBucket
+	for i, dim := range dims {
+		limit, ok := l.limits[dim]
+		// rate为0表示不限流
+		if !ok || limit.Rate <= 0 {
+			continue
+		}
+		key := bucketKey{dim: dim, peer: peer}
+		bucket, ok := l.buckets[key]
+		if !ok {
+			bucket = newTokenBucket(limit, now)
+			l.buckets[key] = bucket
+		}
+		bucket.refill(now)
+		if bucket.tokens < 1 {
+			return false, l.reject(bys[i])
+		}
+		buckets = append(buckets, bucket)
+	}
+	for _, bucket := range buckets {
+		bucket.tokens--
+	}
+	return true, ""
+}
+
+func (l *rateLimiter) reject(by string) string {
+	counter, _ := rateLimitRejected.LoadOrStore(by, new(uint64))
+	atomic.AddUint64(counter.(*uint64), 1)
+	return by
+}
+
+// RateLimitRejected 返回各限流维度被拒绝的帧数
+func RateLimitRejected() map[string]uint64 {
+	ret := make(map[string]uint64)
+	rateLimitRejected.Range(func(key, value interface{}) bool {
+		ret[key.(string)] = atomic.LoadUint64(value.(*uint64))
+		return true
+	})
+	return ret
+}
+
+// sweep 回收长时间未使用的令牌桶, 调用方需持有锁
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < bucketIdleTimeout {
+		return
+	}
+	l.lastSweep = now
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.last) > bucketIdleTimeout {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func getRateLimiter() *rateLimiter {
+	if limiter, ok := currentLimiter.Load().(*rateLimiter); ok {
+		return limiter
+	}
+	return nil
+}
+
+// loadRateLimit 加载限流配置, 配置非法时保留当前限流器
+func loadRateLimit(cfg config.RateLimit) error {
+	limiter, err := newRateLimiter(cfg, getRateLimiter())
+	if err != nil {
+		return err
+	}
+	currentLimiter.Store(limiter)
+	return nil
+}
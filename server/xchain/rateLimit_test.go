@@ -0,0 +1,112 @@
+package xchain
+
+import (
+	"testing"
+
+	"github.com/xuperchain/xuper-front/config"
+	p2p "github.com/xuperchain/xupercore/protos"
+)
+
+func newTestMsg(msgType p2p.XuperMessage_MessageType, bcname string) *p2p.XuperMessage {
+	return &p2p.XuperMessage{
+		Header: &p2p.XuperMessage_MessageHeader{
+			Type:   msgType,
+			Bcname: bcname,
+		},
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter, err := newRateLimiter(config.RateLimit{
+		MsgTypes: map[string]config.Limit{
+			"posttx": {Rate: 0.001, Burst: 2},
+		},
+		Bcnames: map[string]config.Limit{
+			"xuper": {Rate: 0.001, Burst: 3},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("newRateLimiter error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if ok, _ := limiter.allow("A", newTestMsg(p2p.XuperMessage_POSTTX, "other")); !ok {
+			t.Errorf("frame %d should be allowed", i)
+		}
+	}
+	if ok, by := limiter.allow("A", newTestMsg(p2p.XuperMessage_POSTTX, "other")); ok || by != limitByMsgType {
+		t.Errorf("third POSTTX should be limited by msg type, by = %s", by)
+	}
+	// 不同节点使用不同的令牌桶
+	if ok, _ := limiter.allow("B", newTestMsg(p2p.XuperMessage_POSTTX, "other")); !ok {
+		t.Errorf("another peer should be allowed")
+	}
+	for i := 0; i < 3; i++ {
+		limiter.allow("A", newTestMsg(p2p.XuperMessage_GET_BLOCK, "xuper"))
+	}
+	if ok, by := limiter.allow("A", newTestMsg(p2p.XuperMessage_GET_BLOCK, "xuper")); ok || by != limitByBcname {
+		t.Errorf("fourth xuper frame should be limited by bcname, by = %s", by)
+	}
+	if RateLimitRejected()[limitByMsgType] == 0 {
+		t.Errorf("rejected frames should be counted")
+	}
+}
+
+func TestRateLimiterRejectKeepsTokens(t *testing.T) {
+	limiter, err := newRateLimiter(config.RateLimit{
+		Peer: config.Limit{Rate: 0.001, Burst: 2},
+		MsgTypes: map[string]config.Limit{
+			"posttx": {Rate: 0.001, Burst: 1},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("newRateLimiter error: %v", err)
+	}
+	limiter.allow("A", newTestMsg(p2p.XuperMessage_POSTTX, "xuper"))
+	// 被msg_type拒绝的帧不消耗peer的令牌
+	for i := 0; i < 3; i++ {
+		if ok, by := limiter.allow("A", newTestMsg(p2p.XuperMessage_POSTTX, "xuper")); ok || by != limitByMsgType {
+			t.Fatalf("POSTTX should be limited by msg type, by = %s", by)
+		}
+	}
+	if ok, _ := limiter.allow("A", newTestMsg(p2p.XuperMessage_GET_BLOCK, "xuper")); !ok {
+		t.Fatalf("peer budget should not be drained by rejected frames")
+	}
+	if ok, by := limiter.allow("A", newTestMsg(p2p.XuperMessage_GET_BLOCK, "xuper")); ok || by != limitByPeer {
+		t.Fatalf("peer budget should be used up, by = %s", by)
+	}
+}
+
+func TestRateLimiterReloadKeepsBuckets(t *testing.T) {
+	cfg := config.RateLimit{
+		Peer: config.Limit{Rate: 0.001, Burst: 1},
+		Bcnames: map[string]config.Limit{
+			"xuper": {Rate: 0.001, Burst: 1},
+		},
+	}
+	limiter, err := newRateLimiter(cfg, nil)
+	if err != nil {
+		t.Fatalf("newRateLimiter error: %v", err)
+	}
+	if ok, _ := limiter.allow("A", newTestMsg(p2p.XuperMessage_GET_BLOCK, "xuper")); !ok {
+		t.Fatalf("first frame should be allowed")
+	}
+
+	// 限制不变时热加载不重置令牌桶
+	reloaded, err := newRateLimiter(cfg, limiter)
+	if err != nil {
+		t.Fatalf("newRateLimiter error: %v", err)
+	}
+	if ok, by := reloaded.allow("A", newTestMsg(p2p.XuperMessage_GET_BLOCK, "xuper")); ok || by != limitByPeer {
+		t.Fatalf("reload should keep the drained bucket, by = %s", by)
+	}
+
+	// 限制变化的维度重新开始计数
+	cfg.Peer = config.Limit{Rate: 0.001, Burst: 5}
+	changed, err := newRateLimiter(cfg, reloaded)
+	if err != nil {
+		t.Fatalf("newRateLimiter error: %v", err)
+	}
+	if ok, by := changed.allow("A", newTestMsg(p2p.XuperMessage_GET_BLOCK, "xuper")); ok || by != limitByBcname {
+		t.Fatalf("peer bucket should be reset while bcname bucket is kept, by = %s", by)
+	}
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package xchain
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/xuperchain/xuper-front/config"
+	p2p "github.com/xuperchain/xupercore/protos"
+)
+
+// routeMode 消息的转发方式
+type routeMode string
+
+const (
+	// RouteForwardAsync 转发给节点, 不期望节点返回
+	RouteForwardAsync routeMode = "forward-async"
+	// RouteForwardWithResponse 转发给节点, 并将节点的返回回传
+	RouteForwardWithResponse routeMode = "forward-with-response"
+	// RouteDrop 直接丢弃
+	RouteDrop routeMode = "drop"
+)
+
+var (
+	// defaultAsyncMsgTypes 未配置routing时, 不期望节点返回的消息类型
+	defaultAsyncMsgTypes = []p2p.XuperMessage_MessageType{
+		p2p.XuperMessage_POSTTX,
+		p2p.XuperMessage_SENDBLOCK,
+		p2p.XuperMessage_BATCHPOSTTX,
+		p2p.XuperMessage_NEW_BLOCKID,
+		p2p.XuperMessage_CHAINED_BFT_NEW_PROPOSAL_MSG,
+		p2p.XuperMessage_CHAINED_BFT_VOTE_MSG,
+	}
+
+	// currentRouting 当前生效的路由表, 配置热加载时整体替换
+	currentRouting atomic.Value
+)
+
+// routingTable 消息类型到转发方式的映射
+type routingTable struct {
+	rules       map[p2p.XuperMessage_MessageType]routeMode
+	defaultMode routeMode
+}
+
+func defaultRoutingTable() *routingTable {
+	table := &routingTable{
+		rules:       make(map[p2p.XuperMessage_MessageType]routeMode),
+		defaultMode: RouteForwardWithResponse,
+	}
+	for _, t := range defaultAsyncMsgTypes {
+		table.rules[t] = RouteForwardAsync
+	}
+	return table
+}
+
+// newRoutingTable 解析routing配置, 未配置时与历史行为保持一致
+func newRoutingTable(cfg config.Routing) (*routingTable, error) {
+	if cfg.Default == "" && len(cfg.Rules) == 0 {
+		return defaultRoutingTable(), nil
+	}
+	table := &routingTable{
+		rules:       make(map[p2p.XuperMessage_MessageType]routeMode),
+		defaultMode: RouteForwardWithResponse,
+	}
+	if cfg.Default != "" {
+		mode, err := parseRouteMode(cfg.Default)
+		if err != nil {
+			return nil, err
+		}
+		table.defaultMode = mode
+	}
+	for name, value := range cfg.Rules {
+		msgType, err := parseMsgType(name)
+		if err != nil {
+			return nil, err
+		}
+		mode, err := parseRouteMode(value)
+		if err != nil {
+			return nil, err
+		}
+		table.rules[msgType] = mode
+	}
+	return table, nil
+}
+
+// route 获取消息类型对应的转发方式
+func (t *routingTable) route(msgType p2p.XuperMessage_MessageType) routeMode {
+	if mode, ok := t.rules[msgType]; ok {
+		return mode
+	}
+	return t.defaultMode
+}
+
+// asyncTypes 返回所有配置为forward-async的消息类型
+func (t *routingTable) asyncTypes() []p2p.XuperMessage_MessageType {
+	var types []p2p.XuperMessage_MessageType
+	for msgType, mode := range t.rules {
+		if mode == RouteForwardAsync {
+			types = append(types, msgType)
+		}
+	}
+	return types
+}
+
+func parseRouteMode(value string) (routeMode, error) {
+	mode := routeMode(strings.ToLower(strings.TrimSpace(value)))
+	switch mode {
+	case RouteForwardAsync, RouteForwardWithResponse, RouteDrop:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown route mode: %s", value)
+}
+
+// parseMsgType 支持消息类型名称(大小写不敏感)或编号, 编号用于尚未收录的新消息类型
+func parseMsgType(name string) (p2p.XuperMessage_MessageType, error) {
+	name = strings.TrimSpace(name)
+	if value, ok := p2p.XuperMessage_MessageType_value[strings.ToUpper(name)]; ok {
+		return p2p.XuperMessage_MessageType(value), nil
+	}
+	value, err := strconv.ParseInt(name, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("unknown message type: %s", name)
+	}
+	return p2p.XuperMessage_MessageType(value), nil
+}
+
+func getRoutingTable() *routingTable {
+	if table, ok := currentRouting.Load().(*routingTable); ok {
+		return table
+	}
+	return defaultRoutingTable()
+}
+
+// loadRouting 加载routing配置, 配置非法时保留当前路由表
+func loadRouting(cfg config.Routing) error {
+	table, err := newRoutingTable(cfg)
+	if err != nil {
+		return err
+	}
+	currentRouting.Store(table)
+	return nil
+}
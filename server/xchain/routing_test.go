@@ -0,0 +1,50 @@
+package xchain
+
+import (
+	"testing"
+
+	"github.com/xuperchain/xuper-front/config"
+	p2p "github.com/xuperchain/xupercore/protos"
+)
+
+func TestDefaultRouting(t *testing.T) {
+	table, err := newRoutingTable(config.Routing{})
+	if err != nil {
+		t.Fatalf("newRoutingTable error: %v", err)
+	}
+	if table.route(p2p.XuperMessage_POSTTX) != RouteForwardAsync {
+		t.Errorf("POSTTX should be forward-async")
+	}
+	if table.route(p2p.XuperMessage_GET_BLOCK) != RouteForwardWithResponse {
+		t.Errorf("GET_BLOCK should be forward-with-response")
+	}
+}
+
+func TestRoutingRules(t *testing.T) {
+	table, err := newRoutingTable(config.Routing{
+		Default: "drop",
+		Rules: map[string]string{
+			"posttx": "forward-async",
+			"3":      "forward-with-response",
+		},
+	})
+	if err != nil {
+		t.Fatalf("newRoutingTable error: %v", err)
+	}
+	if table.route(p2p.XuperMessage_POSTTX) != RouteForwardAsync {
+		t.Errorf("POSTTX should be forward-async")
+	}
+	if table.route(p2p.XuperMessage_GET_BLOCK) != RouteForwardWithResponse {
+		t.Errorf("GET_BLOCK should be forward-with-response")
+	}
+	if table.route(p2p.XuperMessage_PING) != RouteDrop {
+		t.Errorf("unknown type should use default")
+	}
+
+	if _, err := newRoutingTable(config.Routing{Rules: map[string]string{"NOT_A_TYPE": "drop"}}); err == nil {
+		t.Errorf("unknown message type should fail")
+	}
+	if _, err := newRoutingTable(config.Routing{Default: "forward"}); err == nil {
+		t.Errorf("unknown route mode should fail")
+	}
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package xchain
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/peer"
+)
+
+// StreamInfo 一个活跃的入站stream
+type StreamInfo struct {
+	Id         uint64    `json:"id"`
+	Net        string    `json:"net,omitempty"`
+	Address    string    `json:"address"`
+	SerialNum  string    `json:"serialNum,omitempty"`
+	RemoteAddr string    `json:"remoteAddr"`
+	StartTime  time.Time `json:"startTime"`
+}
+
+// streamEntry 登记中的stream, 可被主动关闭
+type streamEntry struct {
+	info StreamInfo
+	// 对端证书的RawIssuer
+	issuer  []byte
+	ctx     context.Context
+	cancel  context.CancelFunc
+	revoked int32
+}
+
+// isRevoked stream是否因证书撤销被关闭
+func (e *streamEntry) isRevoked() bool {
+	return atomic.LoadInt32(&e.revoked) == 1
+}
+
+// streamRegistry 记录所有活跃的入站stream, 证书撤销时据此关闭对应的stream
+type streamRegistry struct {
+	streams map[uint64]*streamEntry
+	nextId  uint64
+	mutex   sync.RWMutex
+}
+
+var activeStreams = newStreamRegistry()
+
+func newStreamRegistry() *streamRegistry {
+	return &streamRegistry{
+		streams: make(map[uint64]*streamEntry),
+	}
+}
+
+// register 登记一个入站stream, 返回的entry.ctx在stream被关闭时取消
+func (r *streamRegistry) register(ctx context.Context) *streamEntry {
+	entry := &streamEntry{
+		info: StreamInfo{
+			Id:        atomic.AddUint64(&r.nextId, 1),
+			Address:   peerAddress(ctx),
+			StartTime: time.Now(),
+		},
+	}
+	if net, ok := ctx.Value("net").(string); ok {
+		entry.info.Net = net
+	}
+	if serial, ok := ctx.Value("serial").(string); ok {
+		entry.info.SerialNum = serial
+	}
+	if issuer, ok := ctx.Value("issuer").([]byte); ok {
+		entry.issuer = issuer
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		entry.info.RemoteAddr = p.Addr.String()
+	}
+	entry.ctx, entry.cancel = context.WithCancel(ctx)
+	r.mutex.Lock()
+	r.streams[entry.info.Id] = entry
+	r.mutex.Unlock()
+	return entry
+}
+
+// unregister 注销stream, stream结束时调用
+func (r *streamRegistry) unregister(entry *streamEntry) {
+	r.mutex.Lock()
+	delete(r.streams, entry.info.Id)
+	r.mutex.Unlock()
+	entry.cancel()
+}
+
+// closeRevoked 关闭证书已被撤销的stream, 返回被关闭的stream
+func (r *streamRegistry) closeRevoked(isRevoked func(net string, issuer []byte, serialNum string) bool) []StreamInfo {
+	r.mutex.RLock()
+	var entries []*streamEntry
+	for _, entry := range r.streams {
+		if entry.info.SerialNum != "" && isRevoked(entry.info.Net, entry.issuer, entry.info.SerialNum) {
+			entries = append(entries, entry)
+		}
+	}
+	r.mutex.RUnlock()
+	ret := make([]StreamInfo, 0, len(entries))
+	for _, entry := range entries {
+		atomic.StoreInt32(&entry.revoked, 1)
+		entry.cancel()
+		ret = append(ret, entry.info)
+	}
+	return ret
+}
+
+func (r *streamRegistry) list() []StreamInfo {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	ret := make([]StreamInfo, 0, len(r.streams))
+	for _, entry := range r.streams {
+		ret = append(ret, entry.info)
+	}
+	return ret
+}
+
+// ActiveStreams 返回当前所有活跃的入站stream
+func ActiveStreams() []StreamInfo {
+	return activeStreams.list()
+}
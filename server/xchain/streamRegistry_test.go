@@ -0,0 +1,34 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package xchain
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCloseRevoked(t *testing.T) {
+	r := newStreamRegistry()
+	revoked := r.register(context.WithValue(context.Background(), "serial", "1"))
+	valid := r.register(context.WithValue(context.Background(), "serial", "2"))
+	anonymous := r.register(context.Background())
+
+	closed := r.closeRevoked(func(net string, issuer []byte, serialNum string) bool {
+		return serialNum == "1"
+	})
+	if len(closed) != 1 || closed[0].SerialNum != "1" {
+		t.Fatalf("unexpected closed streams: %v", closed)
+	}
+	if !revoked.isRevoked() || revoked.ctx.Err() == nil {
+		t.Fatal("revoked stream should be canceled")
+	}
+	if valid.isRevoked() || valid.ctx.Err() != nil || anonymous.ctx.Err() != nil {
+		t.Fatal("other streams should stay open")
+	}
+
+	r.unregister(valid)
+	if len(r.list()) != 2 || valid.ctx.Err() == nil {
+		t.Fatal("unregister should remove and cancel the stream")
+	}
+}
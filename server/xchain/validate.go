@@ -0,0 +1,103 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package xchain
+
+import (
+	"fmt"
+	"hash/crc32"
+	"sync/atomic"
+
+	"github.com/golang/snappy"
+	"github.com/xuperchain/xuper-front/config"
+	p2p "github.com/xuperchain/xupercore/protos"
+)
+
+// currentValidator 当前生效的消息校验器, 配置热加载时整体替换
+var currentValidator atomic.Value
+
+// msgValidator 转发前对消息头做合法性校验
+type msgValidator struct {
+	maxPayload int
+	msgTypes   map[p2p.XuperMessage_MessageType]int
+}
+
+func newMsgValidator(cfg config.Validation) (*msgValidator, error) {
+	v := &msgValidator{
+		maxPayload: cfg.MaxPayload,
+		msgTypes:   make(map[p2p.XuperMessage_MessageType]int),
+	}
+	if v.maxPayload <= 0 {
+		v.maxPayload = maxMessageSize
+	}
+	for name, max := range cfg.MsgTypes {
+		msgType, err := parseMsgType(name)
+		if err != nil {
+			return nil, err
+		}
+		v.msgTypes[msgType] = max
+	}
+	return v, nil
+}
+
+// validate 校验消息类型、bcname、数据校验和、压缩标记以及负载大小
+func (v *msgValidator) validate(msg *p2p.XuperMessage) error {
+	header := msg.GetHeader()
+	if header == nil {
+		return fmt.Errorf("header is empty")
+	}
+	msgType := header.GetType()
+	if _, ok := p2p.XuperMessage_MessageType_name[int32(msgType)]; !ok {
+		// 路由表中显式配置过的新消息类型同样认为是合法的
+		if _, ok := getRoutingTable().rules[msgType]; !ok {
+			return fmt.Errorf("unknown message type %d", msgType)
+		}
+	}
+	if header.GetBcname() == "" {
+		return fmt.Errorf("bcname is empty")
+	}
+
+	data := msg.GetData().GetMsgInfo()
+	max := v.maxPayload
+	if typeMax, ok := v.msgTypes[msgType]; ok && typeMax > 0 {
+		max = typeMax
+	}
+	if len(data) > max {
+		return fmt.Errorf("payload size %d exceeds limit %d", len(data), max)
+	}
+	if crc32.ChecksumIEEE(data) != header.GetDataCheckSum() {
+		return fmt.Errorf("data checksum mismatch")
+	}
+	// 空数据不会被压缩; 压缩数据需能解析出解压后的长度, 同时限制解压后的大小
+	if header.GetEnableCompress() {
+		if len(data) == 0 {
+			return fmt.Errorf("compress flag set on empty data")
+		}
+		decodedLen, err := snappy.DecodedLen(data)
+		if err != nil {
+			return fmt.Errorf("compress flag mismatch: %v", err)
+		}
+		if decodedLen > max {
+			return fmt.Errorf("decompressed size %d exceeds limit %d", decodedLen, max)
+		}
+	}
+	return nil
+}
+
+func getMsgValidator() *msgValidator {
+	if v, ok := currentValidator.Load().(*msgValidator); ok {
+		return v
+	}
+	v, _ := newMsgValidator(config.Validation{})
+	return v
+}
+
+// loadValidation 加载消息校验配置, 配置非法时保留当前校验器
+func loadValidation(cfg config.Validation) error {
+	v, err := newMsgValidator(cfg)
+	if err != nil {
+		return err
+	}
+	currentValidator.Store(v)
+	return nil
+}
@@ -0,0 +1,66 @@
+package xchain
+
+import (
+	"hash/crc32"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/xuperchain/xuper-front/config"
+	p2p "github.com/xuperchain/xupercore/protos"
+)
+
+func newDataMsg(msgType p2p.XuperMessage_MessageType, data []byte, compress bool) *p2p.XuperMessage {
+	if compress {
+		data = snappy.Encode(nil, data)
+	}
+	return &p2p.XuperMessage{
+		Header: &p2p.XuperMessage_MessageHeader{
+			Type:           msgType,
+			Bcname:         "xuper",
+			EnableCompress: compress,
+			DataCheckSum:   crc32.ChecksumIEEE(data),
+		},
+		Data: &p2p.XuperMessage_MessageData{
+			MsgInfo: data,
+		},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	v, err := newMsgValidator(config.Validation{
+		MsgTypes: map[string]int{"POSTTX": 8},
+	})
+	if err != nil {
+		t.Fatalf("newMsgValidator error: %v", err)
+	}
+	if err := v.validate(newDataMsg(p2p.XuperMessage_GET_BLOCK, []byte("block"), true)); err != nil {
+		t.Errorf("valid message rejected: %v", err)
+	}
+
+	msg := newDataMsg(p2p.XuperMessage_GET_BLOCK, []byte("block"), false)
+	msg.Header.DataCheckSum++
+	if err := v.validate(msg); err == nil {
+		t.Errorf("checksum mismatch should be rejected")
+	}
+
+	msg = newDataMsg(p2p.XuperMessage_GET_BLOCK, []byte("block"), false)
+	msg.Header.Bcname = ""
+	if err := v.validate(msg); err == nil {
+		t.Errorf("empty bcname should be rejected")
+	}
+
+	msg = newDataMsg(p2p.XuperMessage_MessageType(1000), []byte("block"), false)
+	if err := v.validate(msg); err == nil {
+		t.Errorf("unknown type should be rejected")
+	}
+
+	msg = newDataMsg(p2p.XuperMessage_GET_BLOCK, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, false)
+	msg.Header.EnableCompress = true
+	if err := v.validate(msg); err == nil {
+		t.Errorf("compress flag mismatch should be rejected")
+	}
+
+	if err := v.validate(newDataMsg(p2p.XuperMessage_POSTTX, []byte("too large tx"), false)); err == nil {
+		t.Errorf("oversize payload should be rejected")
+	}
+}
@@ -5,7 +5,6 @@ package xchain
 
 import (
 	"context"
-	"crypto/x509"
 	"errors"
 	"io"
 	"net"
@@ -14,6 +13,7 @@ import (
 
 	"github.com/xuperchain/xuper-front/config"
 	logs "github.com/xuperchain/xuper-front/logs"
+	"github.com/xuperchain/xuper-front/metrics"
 	clixchain "github.com/xuperchain/xuper-front/server/client"
 	serv_ca "github.com/xuperchain/xuper-front/service/ca"
 	serv_proxy_xchain "github.com/xuperchain/xuper-front/service/proxyxchain"
@@ -21,10 +21,12 @@ import (
 	pb "github.com/xuperchain/xuperchain/service/pb"
 	p2p "github.com/xuperchain/xupercore/protos"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 )
 
 const (
@@ -41,18 +43,11 @@ var (
 	ErrInvalidPKType = errors.New("unknown type of public key")
 	ErrParseEcdsa    = errors.New("parse ecdsa public key error")
 	ErrRpcAddInvalid = errors.New("address invalid")
-
-	// SendMsgMap
-	sendMsgMap = map[p2p.XuperMessage_MessageType]bool{
-		p2p.XuperMessage_POSTTX:                       true,
-		p2p.XuperMessage_SENDBLOCK:                    true,
-		p2p.XuperMessage_BATCHPOSTTX:                  true,
-		p2p.XuperMessage_NEW_BLOCKID:                  true,
-		p2p.XuperMessage_CHAINED_BFT_NEW_PROPOSAL_MSG: true,
-		p2p.XuperMessage_CHAINED_BFT_VOTE_MSG:         true,
-	}
 )
 
+// proxyServer 当前运行的代理服务, 供运维接口查询
+var proxyServer *xchainProxyServer
+
 type xchainProxyServer struct {
 	pb.XchainClient
 	pb.EventServiceClient
@@ -85,6 +80,53 @@ func (proxy *xchainProxyServer) GetGroupClient(bcName string) (*clixchain.GroupC
 	return client, nil
 }
 
+// Groups 返回已缓存的平行链群组
+func Groups() map[string][]string {
+	ret := make(map[string][]string)
+	proxy := proxyServer
+	if proxy == nil || proxy.groups == nil {
+		return ret
+	}
+	proxy.mutex.Lock()
+	clients := make(map[string]*clixchain.GroupClient, len(proxy.groups))
+	for bcName, client := range proxy.groups {
+		clients[bcName] = client
+	}
+	proxy.mutex.Unlock()
+	for bcName, client := range clients {
+		ret[bcName] = client.Cache.Get()
+	}
+	return ret
+}
+
+// RefreshGroups 丢弃平行链群组缓存并重新从xchain获取, bcName为空时刷新全部
+func RefreshGroups(bcName string) error {
+	proxy := proxyServer
+	if proxy == nil || proxy.groups == nil {
+		return errors.New("parachain auth is not enabled")
+	}
+	proxy.mutex.Lock()
+	var bcNames []string
+	for name, client := range proxy.groups {
+		if bcName != "" && name != bcName {
+			continue
+		}
+		client.Stop()
+		delete(proxy.groups, name)
+		bcNames = append(bcNames, name)
+	}
+	proxy.mutex.Unlock()
+	if bcName != "" && len(bcNames) == 0 {
+		bcNames = append(bcNames, bcName)
+	}
+	for _, name := range bcNames {
+		if _, err := proxy.GetGroupClient(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (proxy *xchainProxyServer) CheckParachainAuth(bcName string, from string) bool {
 	client, err := proxy.GetGroupClient(bcName)
 	if err != nil {
@@ -99,62 +141,184 @@ func (proxy *xchainProxyServer) CheckParachainAuth(bcName string, from string) b
 	return false
 }
 
+// SendP2PMessage 在stream生命周期内双向转发消息, 每一帧都会进行权限校验后按接收顺序转发给节点, 节点的返回依次回传
+// 校验不通过的帧以InvalidArgument关闭stream, 被限流的帧以ResourceExhausted关闭stream; 对端证书在stream存活期间被撤销时, stream会被关闭
 func (proxy *xchainProxyServer) SendP2PMessage(stream p2p.P2PService_SendP2PMessageServer) error {
-	ctx := stream.Context()
-	in, err := stream.Recv()
-	if err == io.EOF {
-		proxy.log.Warn("XchainProxyServer.SendP2PMessage: streamServer meets EOF", "logid", in.GetHeader().Logid)
-		return nil
+	metrics.InboundStreamCounter.Inc()
+	metrics.InboundStreamGauge.Inc()
+	defer metrics.InboundStreamGauge.Dec()
+	entry := activeStreams.register(stream.Context())
+	defer activeStreams.unregister(entry)
+	ctx := entry.ctx
+	relay := newStreamRelay(stream, func(msg *p2p.XuperMessage, reply func(*p2p.XuperMessage) error) {
+		proxy.forward(ctx, msg, reply)
+	})
+	// 返回前等待队列中的帧转发结束, 保证节点的返回能回传给对端
+	defer relay.close()
+	received := recvMessages(stream)
+	for {
+		var in *p2p.XuperMessage
+		select {
+		case <-ctx.Done():
+			if entry.isRevoked() {
+				return status.Error(codes.PermissionDenied, "peer certificate revoked")
+			}
+			return ctx.Err()
+		case ret := <-received:
+			if ret.err == io.EOF {
+				return nil
+			}
+			if ret.err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				proxy.log.Error("XchainProxyServer.SendP2PMessage: streamServer err", "error", ret.err)
+				return ret.err
+			}
+			in = ret.msg
+		}
+		metrics.InboundMsgCounter.WithLabelValues(in.GetHeader().GetType().String(), in.GetHeader().GetBcname(), peerLabel(ctx)).Inc()
+		if err := proxy.checkAuth(ctx, in); err != nil {
+			if err == ErrRpcAddInvalid {
+				metrics.AuthRejectCounter.WithLabelValues(metrics.ReasonAddrInvalid).Inc()
+			} else {
+				metrics.AuthRejectCounter.WithLabelValues(metrics.ReasonParachainAuth).Inc()
+			}
+			proxy.log.Warn("XchainProxyServer.SendP2PMessage: check auth failed", "logid", in.GetHeader().GetLogid(),
+				"bcname", in.GetHeader().GetBcname(), "from", in.GetHeader().GetFrom(), "err", err)
+			return err
+		}
+		// 先校验再限流, 格式错误的帧不消耗对端的令牌
+		if err := proxy.validate(ctx, in); err != nil {
+			return err
+		}
+		if err := proxy.allow(ctx, in); err != nil {
+			return err
+		}
+		// 队列满时阻塞在这里, 不再读取对端的帧, 形成背压
+		if err := relay.enqueue(ctx, in); err != nil {
+			return err
+		}
 	}
-	if err != nil {
-		proxy.log.Error("XchainProxyServer.SendP2PMessage: streamServer err", "error", err)
-		return err
+}
+
+// validate 校验消息头和数据, 不通过时返回InvalidArgument
+func (proxy *xchainProxyServer) validate(ctx context.Context, in *p2p.XuperMessage) error {
+	if err := getMsgValidator().validate(in); err != nil {
+		metrics.AuthRejectCounter.WithLabelValues(metrics.ReasonInvalidMsg).Inc()
+		proxy.log.Warn("XchainProxyServer.SendP2PMessage: invalid message", "logid", in.GetHeader().GetLogid(),
+			"type", in.GetHeader().GetType(), "bcname", in.GetHeader().GetBcname(), "peer", peerAddress(ctx), "err", err)
+		return status.Errorf(codes.InvalidArgument, "invalid message: %v", err)
 	}
-	if config.GetXchainServer().Master != "" {
-		address := ctx.Value("address")
-		add, ok := address.(string)
-		if !ok {
-			return ErrRpcAddInvalid
-		}
-		// 若为平行链请求，需要进行群组权限检验
-		if in.GetHeader().GetBcname() != config.GetXchainServer().Master &&
-			!proxy.CheckParachainAuth(in.GetHeader().GetBcname(), add) {
-			return ErrUnAuthorized
+	return nil
+}
+
+// allow 限流, 被限流时返回ResourceExhausted
+func (proxy *xchainProxyServer) allow(ctx context.Context, in *p2p.XuperMessage) error {
+	if limiter := getRateLimiter(); limiter != nil {
+		if ok, by := limiter.allow(peerAddress(ctx), in); !ok {
+			metrics.AuthRejectCounter.WithLabelValues(metrics.ReasonRateLimited).Inc()
+			proxy.log.Warn("XchainProxyServer.SendP2PMessage: rate limited", "logid", in.GetHeader().GetLogid(),
+				"type", in.GetHeader().GetType(), "bcname", in.GetHeader().GetBcname(), "peer", peerAddress(ctx), "by", by)
+			return status.Errorf(codes.ResourceExhausted, "rate limited by %s", by)
 		}
 	}
-	ret, err := handleReceivedMsg(ctx, in)
+	return nil
+}
+
+// forward 转发一帧并记录耗时和错误
+func (proxy *xchainProxyServer) forward(ctx context.Context, msg *p2p.XuperMessage, reply func(*p2p.XuperMessage) error) {
+	msgType := msg.GetHeader().GetType().String()
+	start := time.Now()
+	err := handleReceivedMsg(ctx, msg, reply)
+	metrics.ForwardHistogram.WithLabelValues(msgType).Observe(time.Since(start).Seconds())
 	if err != nil {
-		proxy.log.Error("XchainProxyServer.SendP2PMessageServer: handleReceivedMsg error", "logid", in.GetHeader().Logid, "type", in.GetHeader().Type,
-			"from", in.GetHeader().From, "err", err)
+		metrics.ForwardErrorCounter.WithLabelValues(msgType).Inc()
+		proxy.log.Error("XchainProxyServer.SendP2PMessageServer: handleReceivedMsg error", "logid", msg.GetHeader().GetLogid(),
+			"type", msg.GetHeader().GetType(), "from", msg.GetHeader().GetFrom(), "err", err)
+	}
+}
+
+// checkAuth 若为平行链请求，需要进行群组权限检验
+func (proxy *xchainProxyServer) checkAuth(ctx context.Context, in *p2p.XuperMessage) error {
+	if config.GetXchainServer().Master == "" {
+		return nil
+	}
+	address := ctx.Value("address")
+	add, ok := address.(string)
+	if !ok {
+		return ErrRpcAddInvalid
+	}
+	if in.GetHeader().GetBcname() != config.GetXchainServer().Master &&
+		!proxy.CheckParachainAuth(in.GetHeader().GetBcname(), add) {
+		return ErrUnAuthorized
+	}
+	return nil
+}
+
+// peerAddress 获取对端节点标识, 使用tls时为证书中的地址, 否则为对端ip
+func peerAddress(ctx context.Context) string {
+	if address, ok := ctx.Value("address").(string); ok && address != "" {
+		return address
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		host, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			return p.Addr.String()
+		}
+		return host
 	}
-	if ret != nil {
-		stream.Send(ret)
+	return ""
+}
+
+// peerLabel 监控中的对端标签, 只使用证书中的地址, 未使用tls时统一为unknown
+func peerLabel(ctx context.Context) string {
+	if address, ok := ctx.Value("address").(string); ok && address != "" {
+		return address
 	}
-	return err
+	return metrics.PeerUnknown
 }
 
-func handleReceivedMsg(ctx context.Context, msg *p2p.XuperMessage) (*p2p.XuperMessage, error) {
-	c := serv_proxy_xchain.GetXchainP2pProxy()
+// peerNet 对端所属的网络, 未使用tls时为默认网络
+func peerNet(ctx context.Context) string {
+	if netName, ok := ctx.Value("net").(string); ok && netName != "" {
+		return netName
+	}
+	return config.GetNet()
+}
+
+// handleReceivedMsg 将消息转发给对端所属网络的节点, 节点的每一个返回都通过reply回传
+func handleReceivedMsg(ctx context.Context, msg *p2p.XuperMessage, reply func(*p2p.XuperMessage) error) error {
+	c := serv_proxy_xchain.GetNetP2pProxy(peerNet(ctx))
 	if c == nil {
-		return nil, errors.New("cat get client")
+		return errors.New("cat get client")
 	}
 
-	// check msg type
-	msgType := msg.GetHeader().GetType()
-	if _, ok := sendMsgMap[msgType]; !ok {
+	// 根据routing配置决定转发方式
+	switch getRoutingTable().route(msg.GetHeader().GetType()) {
+	case RouteDrop:
+		return nil
+	case RouteForwardAsync:
+		// 广播消息会从多个节点重复到达, 时间窗口内只转发一次, 重复的副本直接确认
+		if broadcastDedup != nil {
+			key := dedupKey(msg)
+			if broadcastDedup.seen(key) {
+				return nil
+			}
+			if err := c.SendMessage(ctx, msg); err != nil {
+				broadcastDedup.forget(key)
+				return err
+			}
+			return nil
+		}
+		// 发送给节点, 不期望节点返回
+		// 统一透传别的xchain作为client时的context
+		return c.SendMessage(ctx, msg)
+	default:
 		// 期望节点处理后有返回的请求
 		// 统一透传别的xchain作为client时的context
-		ret, err := c.SendMessageWithResponse(ctx, msg)
-		return ret, err
-	}
-
-	// 发送给节点, 不期望节点返回
-	// 统一透传别的xchain作为client时的context
-	err := c.SendMessage(ctx, msg)
-	if err != nil {
-		return nil, err
+		return c.SendMessageWithResponses(ctx, msg, reply)
 	}
-	return nil, nil
 }
 
 // StartXchainProxyServer 开启服务
@@ -166,28 +330,59 @@ func StartXchainProxyServer(quit chan int) {
 	if err != nil {
 		return
 	}
-	proxy := xchainProxyServer{
+	proxy := &xchainProxyServer{
 		log: log,
 	}
 	if config.GetXchainServer().Master != "" {
 		proxy.groups = make(map[string]*clixchain.GroupClient)
 	}
+	// 加载消息路由表, 配置变化时重新加载
+	if err := loadRouting(config.GetRouting()); err != nil {
+		proxy.log.Error("XchainProxyServer.StartXchainProxyServer: load routing failed, use default", "err", err)
+	}
+	if err := loadValidation(config.GetValidation()); err != nil {
+		proxy.log.Error("XchainProxyServer.StartXchainProxyServer: load validation failed, use default", "err", err)
+	}
+	initDedup(config.GetConfig().Dedup)
+	if err := loadRateLimit(config.GetRateLimit()); err != nil {
+		proxy.log.Error("XchainProxyServer.StartXchainProxyServer: load rate limit failed, no limit", "err", err)
+	}
+	config.OnReload(func(cfg *config.Config) {
+		if err := loadRouting(cfg.Routing); err != nil {
+			proxy.log.Error("XchainProxyServer: reload routing failed, keep current", "err", err)
+		} else {
+			proxy.log.Info("XchainProxyServer: routing reloaded", "routing", cfg.Routing)
+		}
+		if err := loadRateLimit(cfg.RateLimit); err != nil {
+			proxy.log.Error("XchainProxyServer: reload rate limit failed, keep current", "err", err)
+		} else {
+			proxy.log.Info("XchainProxyServer: rate limit reloaded", "rateLimit", cfg.RateLimit)
+		}
+		if err := loadValidation(cfg.Validation); err != nil {
+			proxy.log.Error("XchainProxyServer: reload validation failed, keep current", "err", err)
+		} else {
+			proxy.log.Info("XchainProxyServer: validation reloaded", "validation", cfg.Validation)
+		}
+	})
 	var s *grpc.Server
 	// 是否使用tls
 	if config.GetCaConfig().CaSwitch {
-		// 接收xchian过来的tls请求
-		creds, err := util_cert.GenCreds()
+		// 接收xchian过来的tls请求, 按SNI选择网络的证书
+		creds, err := util_cert.GenServerCreds()
 		if err != nil {
 			proxy.log.Error("XchainProxyServer.StartXchainProxyServer: failed to serve", "err", err)
 		}
 		s = grpc.NewServer(grpc.StreamInterceptor(CheckInterceptor()), grpc.Creds(creds), grpc.MaxRecvMsgSize(maxMessageSize), grpc.MaxSendMsgSize(maxMessageSize),
 			grpc.MaxConcurrentStreams(MaxConcurrentStreams), grpc.ConnectionTimeout(time.Second*time.Duration(GRPCTIMEOUT)))
-		p2p.RegisterP2PServiceServer(s, &proxy)
+		p2p.RegisterP2PServiceServer(s, proxy)
+		// 撤销列表更新后关闭已撤销证书的stream
+		serv_ca.OnRevokeListUpdated(proxy.closeRevokedStreams)
 	} else {
 		s = grpc.NewServer(grpc.MaxRecvMsgSize(maxMessageSize), grpc.MaxSendMsgSize(maxMessageSize),
 			grpc.MaxConcurrentStreams(MaxConcurrentStreams), grpc.ConnectionTimeout(time.Second*time.Duration(GRPCTIMEOUT)))
-		p2p.RegisterP2PServiceServer(s, &proxy)
+		p2p.RegisterP2PServiceServer(s, proxy)
 	}
+	proxyServer = proxy
 	// Register reflection service on gRPC server.
 	reflection.Register(s)
 
@@ -218,23 +413,109 @@ func StartXchainProxyServer(quit chan int) {
 func CheckInterceptor() grpc.StreamServerInterceptor {
 	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
 		p, _ := peer.FromContext(ss.Context())
-		hh, err := x509.ParseCertificate(p.AuthInfo.(credentials.TLSInfo).State.PeerCertificates[0].Raw)
+		state := p.AuthInfo.(credentials.TLSInfo).State
+		hh, err := util_cert.ParseCertificate(state.PeerCertificates[0].Raw)
 		if err != nil {
+			metrics.AuthRejectCounter.WithLabelValues(metrics.ReasonCertInvalid).Inc()
 			return errors.New("cert is not valid")
 		}
-		ok := serv_ca.IsValidCert(hh.SerialNumber.String())
+		// 对端所属的网络, 决定撤销列表和转发的xchain节点
+		netName := util_cert.PeerNet(state)
+		ok := serv_ca.IsValidPeerCert(netName, hh)
 		if ok == false {
+			metrics.AuthRejectCounter.WithLabelValues(metrics.ReasonCertRevoked).Inc()
 			return errors.New("cert is not valid")
 		}
-		if config.GetXchainServer().Master == "" {
-			return handler(srv, ss)
-		}
+		// 证书中的地址用于平行链权限校验和限流
 		address := hh.Subject.SerialNumber
 		ctx := context.WithValue(ss.Context(), "address", address)
+		ctx = context.WithValue(ctx, "serial", hh.SerialNumber.String())
+		ctx = context.WithValue(ctx, "issuer", hh.RawIssuer)
+		ctx = context.WithValue(ctx, "net", netName)
 		return handler(srv, newWrappedStream(ss, ctx))
 	}
 }
 
+type recvResult struct {
+	msg *p2p.XuperMessage
+	err error
+}
+
+// recvMessages 在单独的协程中读取stream, 使handler可以在Recv阻塞时返回
+// handler返回后stream的context被取消, Recv返回错误, 协程随之退出
+func recvMessages(stream p2p.P2PService_SendP2PMessageServer) <-chan recvResult {
+	received := make(chan recvResult)
+	go func() {
+		for {
+			msg, err := stream.Recv()
+			select {
+			case received <- recvResult{msg: msg, err: err}:
+			case <-stream.Context().Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return received
+}
+
+// closeRevokedStreams 撤销列表更新后关闭证书已被撤销的stream
+func (proxy *xchainProxyServer) closeRevokedStreams() {
+	closed := activeStreams.closeRevoked(serv_ca.IsRevoked)
+	for _, info := range closed {
+		metrics.AuthRejectCounter.WithLabelValues(metrics.ReasonCertRevoked).Inc()
+		proxy.log.Warn("XchainProxyServer: close stream of revoked cert", "net", info.Net, "address", info.Address,
+			"serialNum", info.SerialNum, "remoteAddr", info.RemoteAddr)
+	}
+}
+
+////////////// streamRelay ///////////////
+
+// relayQueueSize 每个stream等待转发的帧数上限
+const relayQueueSize = 64
+
+// streamRelay 每个stream一个转发协程, 按接收顺序逐帧转发, 节点的返回写回同一个server stream
+type streamRelay struct {
+	stream   p2p.P2PService_SendP2PMessageServer
+	queue    chan *p2p.XuperMessage
+	finished chan struct{}
+}
+
+func newStreamRelay(stream p2p.P2PService_SendP2PMessageServer,
+	forward func(msg *p2p.XuperMessage, reply func(*p2p.XuperMessage) error)) *streamRelay {
+	r := &streamRelay{
+		stream:   stream,
+		queue:    make(chan *p2p.XuperMessage, relayQueueSize),
+		finished: make(chan struct{}),
+	}
+	go func() {
+		defer close(r.finished)
+		// 只有转发协程写stream, 不需要对Send加锁
+		for msg := range r.queue {
+			forward(msg, r.stream.Send)
+		}
+	}()
+	return r
+}
+
+// enqueue 将帧放入转发队列, 队列满时阻塞直到有空位或stream结束
+func (r *streamRelay) enqueue(ctx context.Context, msg *p2p.XuperMessage) error {
+	select {
+	case r.queue <- msg:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// close 不再接收新的帧, 等待队列中的帧转发完成
+func (r *streamRelay) close() {
+	close(r.queue)
+	<-r.finished
+}
+
 ////////////// wrappedStream ///////////////
 
 type wrappedStream struct {
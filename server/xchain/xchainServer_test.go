@@ -0,0 +1,303 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package xchain
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/xuperchain/xuper-front/config"
+	logs "github.com/xuperchain/xuper-front/logs"
+	"github.com/xuperchain/xuper-front/metrics"
+	p2p "github.com/xuperchain/xupercore/protos"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// fakeP2PStream 按顺序返回预置的帧, 读完后返回EOF
+type fakeP2PStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	in   []*p2p.XuperMessage
+	sent []*p2p.XuperMessage
+}
+
+func (s *fakeP2PStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeP2PStream) Recv() (*p2p.XuperMessage, error) {
+	if len(s.in) == 0 {
+		return nil, io.EOF
+	}
+	msg := s.in[0]
+	s.in = s.in[1:]
+	return msg, nil
+}
+
+func (s *fakeP2PStream) Send(msg *p2p.XuperMessage) error {
+	s.sent = append(s.sent, msg)
+	return nil
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Error(string, ...interface{}) {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Trace(string, ...interface{}) {}
+
+func TestStreamRelayKeepsOrder(t *testing.T) {
+	stream := &fakeP2PStream{ctx: context.Background()}
+	var mutex sync.Mutex
+	var forwarded []string
+	relay := newStreamRelay(stream, func(msg *p2p.XuperMessage, reply func(*p2p.XuperMessage) error) {
+		// 转发耗时不同, 顺序仍与接收顺序一致
+		time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+		mutex.Lock()
+		forwarded = append(forwarded, msg.GetHeader().GetLogid())
+		mutex.Unlock()
+		reply(msg)
+	})
+	for i := 0; i < 100; i++ {
+		msg := newTestMsg(p2p.XuperMessage_GET_BLOCK, "xuper")
+		msg.Header.Logid = string(rune('a' + i%26))
+		if err := relay.enqueue(context.Background(), msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	relay.close()
+	if len(forwarded) != 100 || len(stream.sent) != 100 {
+		t.Fatalf("unexpected forwarded %d, sent %d", len(forwarded), len(stream.sent))
+	}
+	for i, logid := range forwarded {
+		if logid != string(rune('a'+i%26)) || stream.sent[i].GetHeader().GetLogid() != logid {
+			t.Fatalf("frame %d out of order", i)
+		}
+	}
+}
+
+func TestStreamRelayBackpressure(t *testing.T) {
+	block := make(chan struct{})
+	relay := newStreamRelay(&fakeP2PStream{ctx: context.Background()}, func(*p2p.XuperMessage, func(*p2p.XuperMessage) error) {
+		<-block
+	})
+	// 转发协程阻塞在第一帧, 队列再放满relayQueueSize帧后enqueue阻塞
+	for i := 0; i <= relayQueueSize; i++ {
+		if err := relay.enqueue(context.Background(), newTestMsg(p2p.XuperMessage_GET_BLOCK, "xuper")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := relay.enqueue(ctx, newTestMsg(p2p.XuperMessage_GET_BLOCK, "xuper")); err == nil {
+		t.Fatal("enqueue should block when the queue is full")
+	}
+	close(block)
+	relay.close()
+}
+
+func TestSendP2PMessageRejectsInvalidFrame(t *testing.T) {
+	if err := config.InstallFrontConfig("../../conf/front.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	defer currentValidator.Store(getMsgValidator())
+	defer currentLimiter.Store((*rateLimiter)(nil))
+	if err := loadRateLimit(config.RateLimit{Peer: config.Limit{Rate: 0.001, Burst: 1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := newDataMsg(p2p.XuperMessage_GET_BLOCK, []byte("block"), false)
+	invalid.Header.DataCheckSum++
+	// 校验失败的帧以InvalidArgument关闭stream, 后续的帧不再转发
+	stream := &fakeP2PStream{
+		ctx: context.WithValue(context.Background(), "address", "invalid-frame-peer"),
+		in:  []*p2p.XuperMessage{invalid, invalid},
+	}
+	proxy := &xchainProxyServer{log: nopLogger{}}
+	if err := proxy.SendP2PMessage(stream); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("invalid frame should close the stream with InvalidArgument, got %v", err)
+	}
+	if len(stream.sent) != 0 {
+		t.Fatalf("invalid frames should not be forwarded, sent %d", len(stream.sent))
+	}
+	// 校验在限流之前, 无效帧没有消耗对端唯一的令牌
+	if ok, by := getRateLimiter().allow("invalid-frame-peer", newDataMsg(p2p.XuperMessage_GET_BLOCK, []byte("block"), false)); !ok {
+		t.Fatalf("invalid frame should not consume the token, limited by %s", by)
+	}
+}
+
+func TestSendP2PMessageRateLimited(t *testing.T) {
+	if err := config.InstallFrontConfig("../../conf/front.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	defer currentLimiter.Store((*rateLimiter)(nil))
+	if err := loadRateLimit(config.RateLimit{Peer: config.Limit{Rate: 0.001, Burst: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	// 对端唯一的令牌已被消耗, 下一帧以ResourceExhausted关闭stream
+	getRateLimiter().allow("rate-limited-peer", newDataMsg(p2p.XuperMessage_GET_BLOCK, []byte("block"), false))
+	stream := &fakeP2PStream{
+		ctx: context.WithValue(context.Background(), "address", "rate-limited-peer"),
+		in:  []*p2p.XuperMessage{newDataMsg(p2p.XuperMessage_GET_BLOCK, []byte("block"), false)},
+	}
+	proxy := &xchainProxyServer{log: nopLogger{}}
+	if err := proxy.SendP2PMessage(stream); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("over-limit frame should close the stream with ResourceExhausted, got %v", err)
+	}
+	if len(stream.sent) != 0 {
+		t.Fatalf("over-limit frames should not be forwarded, sent %d", len(stream.sent))
+	}
+}
+
+var echoUpstreams int32
+
+// startEchoUpstream 启动回显节点并安装以其为xchainServer的配置, 返回的函数停止节点并恢复默认配置
+// 每次使用不同的网络名, 避免重复运行时复用指向已停止节点的连接池
+func startEchoUpstream(t *testing.T, prefix string) func() {
+	netName := fmt.Sprintf("%s%d", prefix, atomic.AddInt32(&echoUpstreams, 1))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	p2p.RegisterP2PServiceServer(s, echoP2PServer{})
+	go s.Serve(lis)
+
+	dir, err := ioutil.TempDir("", netName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := fmt.Sprintf("netName: %s\nxchainServer:\n  host: %s\ncaConfig:\n  caSwitch: false\n", netName, lis.Addr())
+	file := filepath.Join(dir, netName+"_front.yaml")
+	if err := ioutil.WriteFile(file, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.InstallFrontConfig(file); err != nil {
+		t.Fatal(err)
+	}
+	logs.InitLog("xchain_test", dir)
+	return func() {
+		config.InstallFrontConfig("../../conf/front.yaml")
+		s.Stop()
+		os.RemoveAll(dir)
+	}
+}
+
+// newRelayMsgs 生成logid依次为prefix0, prefix1...的合法帧
+func newRelayMsgs(prefix string, n int) []*p2p.XuperMessage {
+	msgs := make([]*p2p.XuperMessage, n)
+	for i := range msgs {
+		msgs[i] = newDataMsg(p2p.XuperMessage_GET_BLOCK, []byte("block"), false)
+		msgs[i].Header.Logid = fmt.Sprintf("%s%d", prefix, i)
+	}
+	return msgs
+}
+
+func sentLogids(stream *fakeP2PStream) []string {
+	var logids []string
+	for _, msg := range stream.sent {
+		logids = append(logids, msg.GetHeader().GetLogid())
+	}
+	return logids
+}
+
+func TestSendP2PMessageRelaysUntilRejected(t *testing.T) {
+	defer startEchoUpstream(t, "relaynet")()
+	defer currentLimiter.Store((*rateLimiter)(nil))
+	invalidCounter := metrics.AuthRejectCounter.WithLabelValues(metrics.ReasonInvalidMsg)
+	limitedCounter := metrics.AuthRejectCounter.WithLabelValues(metrics.ReasonRateLimited)
+	proxy := &xchainProxyServer{log: nopLogger{}}
+
+	// 无效帧之前的帧全部转发并回传, 无效帧及其后的帧不转发
+	invalidBefore, limitedBefore := testutil.ToFloat64(invalidCounter), testutil.ToFloat64(limitedCounter)
+	in := newRelayMsgs("valid", 4)
+	in[2].Header.DataCheckSum++
+	stream := &fakeP2PStream{ctx: context.WithValue(context.Background(), "address", "relay-invalid-peer"), in: in}
+	if err := proxy.SendP2PMessage(stream); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("invalid frame should close the stream with InvalidArgument, got %v", err)
+	}
+	if got := fmt.Sprint(sentLogids(stream)); got != "[valid0 valid1]" {
+		t.Errorf("unexpected relayed frames %s", got)
+	}
+	if got := testutil.ToFloat64(invalidCounter) - invalidBefore; got != 1 {
+		t.Errorf("invalid message counter increased by %v, expect 1", got)
+	}
+	if got := testutil.ToFloat64(limitedCounter) - limitedBefore; got != 0 {
+		t.Errorf("rate limited counter increased by %v, expect 0", got)
+	}
+
+	// 令牌用完前的帧全部转发并回传, 超限的帧及其后的帧不转发
+	if err := loadRateLimit(config.RateLimit{Peer: config.Limit{Rate: 0.001, Burst: 2}}); err != nil {
+		t.Fatal(err)
+	}
+	invalidBefore, limitedBefore = testutil.ToFloat64(invalidCounter), testutil.ToFloat64(limitedCounter)
+	stream = &fakeP2PStream{ctx: context.WithValue(context.Background(), "address", "relay-limited-peer"), in: newRelayMsgs("limited", 4)}
+	if err := proxy.SendP2PMessage(stream); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("over-limit frame should close the stream with ResourceExhausted, got %v", err)
+	}
+	if got := fmt.Sprint(sentLogids(stream)); got != "[limited0 limited1]" {
+		t.Errorf("unexpected relayed frames %s", got)
+	}
+	if got := testutil.ToFloat64(limitedCounter) - limitedBefore; got != 1 {
+		t.Errorf("rate limited counter increased by %v, expect 1", got)
+	}
+	if got := testutil.ToFloat64(invalidCounter) - invalidBefore; got != 0 {
+		t.Errorf("invalid message counter increased by %v, expect 0", got)
+	}
+}
+
+func TestInboundMetrics(t *testing.T) {
+	defer startEchoUpstream(t, "metricsnet")()
+
+	inbound := metrics.InboundMsgCounter.WithLabelValues("GET_BLOCK", "xuper", metrics.PeerUnknown)
+	anonymousBefore := testutil.ToFloat64(inbound)
+	certified := metrics.InboundMsgCounter.WithLabelValues("GET_BLOCK", "xuper", "dpzuVdosQrF2kmzumhVeFQZa1aYcdgFpN")
+	certifiedBefore := testutil.ToFloat64(certified)
+	forwardErrBefore := testutil.ToFloat64(metrics.ForwardErrorCounter.WithLabelValues("GET_BLOCK"))
+
+	// 没有证书地址的对端使用unknown标签, 不使用ip
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}})
+	proxy := &xchainProxyServer{log: nopLogger{}}
+	stream := &fakeP2PStream{ctx: ctx, in: []*p2p.XuperMessage{newDataMsg(p2p.XuperMessage_GET_BLOCK, []byte("block"), false)}}
+	if err := proxy.SendP2PMessage(stream); err != nil {
+		t.Fatal(err)
+	}
+	if len(stream.sent) != 1 {
+		t.Fatalf("frame should be forwarded and the response relayed back, sent %d", len(stream.sent))
+	}
+	stream = &fakeP2PStream{
+		ctx: context.WithValue(ctx, "address", "dpzuVdosQrF2kmzumhVeFQZa1aYcdgFpN"),
+		in:  []*p2p.XuperMessage{newDataMsg(p2p.XuperMessage_GET_BLOCK, []byte("block"), false)},
+	}
+	if err := proxy.SendP2PMessage(stream); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := testutil.ToFloat64(inbound) - anonymousBefore; got != 1 {
+		t.Errorf("anonymous inbound counter increased by %v, expect 1", got)
+	}
+	if got := testutil.ToFloat64(certified) - certifiedBefore; got != 1 {
+		t.Errorf("certified inbound counter increased by %v, expect 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.InboundMsgCounter.WithLabelValues("GET_BLOCK", "xuper", "10.0.0.1")); got != 0 {
+		t.Errorf("peer ip should not be used as label")
+	}
+	if got := testutil.ToFloat64(metrics.ForwardErrorCounter.WithLabelValues("GET_BLOCK")) - forwardErrBefore; got != 0 {
+		t.Errorf("forwarded frames should not be counted as errors")
+	}
+}
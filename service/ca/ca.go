@@ -5,6 +5,10 @@ package service
 
 import (
 	"context"
+	gocrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
 	"os"
 	"strconv"
 	"time"
@@ -16,11 +20,14 @@ import (
 	"github.com/xuperchain/xuper-front/pb"
 	util_cert "github.com/xuperchain/xuper-front/util/cert"
 	util_file "github.com/xuperchain/xuper-front/util/file"
+	"github.com/xuperchain/xuper-front/util/keystore"
 	"google.golang.org/grpc"
 )
 
 var log *logs.LogFitter
 
+var ErrNotEcdsaKey = errors.New("account key is not an ecdsa key")
+
 func StartCaHandler() {
 	log, _ = logs.NewLogger("CaServer")
 }
@@ -31,27 +38,52 @@ type CurrentCert struct {
 	CaCert     string
 }
 
-// 访问ca的签名校验, 检验的data根据接口不同而不同
-func sign(data []byte) (*pb.Sign, error) {
-	// 获取账户
-	cryptoClient := crypto.GetCryptoClient()
-	privateKey, err := cryptoClient.GetEcdsaPrivateKeyFromFile(config.GetKeys() + "private.key")
+// localCertSource 本地ca模式下为本节点签发证书, 为nil时向远程ca获取
+var localCertSource func(net string) (*CurrentCert, string, error)
+
+// UseLocalCa 开启本地ca模式: 本节点的证书由本地签发, 撤销列表以本地数据库为准, 不再从远程ca同步
+func UseLocalCa(source func(net string) (*CurrentCert, string, error)) {
+	localCertSource = source
+}
+
+// fetchCurrentCert 获取本节点的证书, 本地ca模式下由本地签发
+func fetchCurrentCert(net string) (*CurrentCert, string, error) {
+	if localCertSource != nil {
+		return localCertSource(net)
+	}
+	return GetCurrentCert(net)
+}
+
+// 访问ca的签名校验, 检验的data根据接口不同而不同, 使用该网络的账户私钥签名
+func sign(net string, data []byte) (*pb.Sign, error) {
+	// 获取账户, 私钥通过keystore使用
+	signer, _, err := accountSigner(net)
 	if err != nil {
-		log.Warn("CaServer.sign: can not get `private.key`", "err", err)
+		log.Warn("CaServer.sign: can not get account key", "err", err)
 		return nil, err
 	}
+	return signWith(signer, data)
+}
+
+// signWith 使用指定的账户私钥签名, 密钥轮换时新私钥在切换前尚未写入keystore
+func signWith(signer gocrypto.Signer, data []byte) (*pb.Sign, error) {
+	publicKey, ok := signer.Public().(*ecdsa.PublicKey)
+	if !ok {
+		return nil, ErrNotEcdsaKey
+	}
 
-	pubKey, err := cryptoClient.GetEcdsaPublicKeyJsonFormatStr(privateKey)
+	cryptoClient := crypto.GetCryptoClient()
+	pubKey, err := cryptoClient.GetEcdsaPublicKeyJsonFormatStrFromPublicKey(publicKey)
 	if err != nil {
 		log.Warn("CaServer.sign: can not get `public.key`", "err", err)
 		return nil, err
 	}
 
-	address, err := cryptoClient.GetAddressFromPublicKey(&privateKey.PublicKey)
+	address, err := cryptoClient.GetAddressFromPublicKey(publicKey)
 
 	// 对数据进行加密
 	nonce := strconv.Itoa(int(time.Now().Unix()))
-	sign, err := cryptoClient.SignECDSA(privateKey, []byte(string(data)+nonce))
+	sign, err := signer.Sign(rand.Reader, []byte(string(data)+nonce), nil)
 	if err != nil {
 		log.Warn("CaServer.sign: sign failed", "err", err)
 		return nil, err
@@ -64,23 +96,65 @@ func sign(data []byte) (*pb.Sign, error) {
 	}, nil
 }
 
+// accountSigner 获取网络的账户私钥的签名器和公钥
+func accountSigner(net string) (gocrypto.Signer, *ecdsa.PublicKey, error) {
+	signer, err := keystore.Signer(keystore.NetKey(keystore.KeyAccount, net))
+	if err != nil {
+		return nil, nil, err
+	}
+	publicKey, ok := signer.Public().(*ecdsa.PublicKey)
+	if !ok {
+		return nil, nil, ErrNotEcdsaKey
+	}
+	return signer, publicKey, nil
+}
+
+// AccountAddress 获取本节点在网络中的账户地址
+func AccountAddress(net string) (string, error) {
+	signer, _, err := accountSigner(net)
+	if err != nil {
+		return "", err
+	}
+	return signerAddress(signer)
+}
+
+// signerAddress 私钥对应的账户地址
+func signerAddress(signer gocrypto.Signer) (string, error) {
+	publicKey, ok := signer.Public().(*ecdsa.PublicKey)
+	if !ok {
+		return "", ErrNotEcdsaKey
+	}
+	return crypto.GetCryptoClient().GetAddressFromPublicKey(publicKey)
+}
+
 // 请求ca增加节点
 func AddNode(address, net, adminAddress string) error {
+	signer, _, err := accountSigner(net)
+	if err != nil {
+		log.Warn("CaServer.AddNode: can not get account key", "err", err)
+		return err
+	}
+	return addNode(address, net, adminAddress, signer)
+}
+
+// addNode 使用signer签名请求ca增加节点
+func addNode(address, net, adminAddress string, signer gocrypto.Signer) error {
 	request := &pb.EnrollNodeRequest{
 		Net:          net,
 		AdminAddress: adminAddress,
 		Address:      address,
 	}
 
-	conn, err := grpc.Dial(config.GetCaConfig().Host, grpc.WithInsecure())
+	conn, err := dialCa(net)
 	if err != nil {
 		log.Warn("CaServer.AddNode: create conn to ca failed")
 		return err
 	}
+	defer conn.Close()
 	client := pb.NewCaserverClient(conn)
 	ctx := context.Background()
 
-	sign, err := sign([]byte(string(request.Address + request.Net)))
+	sign, err := signWith(signer, []byte(string(request.Address+request.Net)))
 	if err != nil {
 		log.Warn("CaServer.AddNode: sign error", "err", err)
 		return err
@@ -95,10 +169,87 @@ func AddNode(address, net, adminAddress string) error {
 	return nil
 }
 
-// 请求ca 获取节点的证书, 并写入文件
+// 请求ca撤销网络中的节点, 需使用网络管理员的keys签名
+func RevokeNode(address, net string) error {
+	signer, _, err := accountSigner(net)
+	if err != nil {
+		log.Warn("CaServer.RevokeNode: can not get account key", "err", err)
+		return err
+	}
+	return revokeNode(address, net, signer)
+}
+
+// revokeNode 使用signer签名请求ca撤销节点
+func revokeNode(address, net string, signer gocrypto.Signer) error {
+	request := &pb.RevokeNodeRequest{
+		Net:     net,
+		Address: address,
+	}
+
+	conn, err := dialCa(net)
+	if err != nil {
+		log.Warn("CaServer.RevokeNode: create conn to ca failed")
+		return err
+	}
+	defer conn.Close()
+	client := pb.NewCaserverClient(conn)
+
+	sign, err := signWith(signer, []byte(request.Address+request.Net))
+	if err != nil {
+		log.Warn("CaServer.RevokeNode: sign error", "err", err)
+		return err
+	}
+	request.Sign = sign
+
+	_, err = client.RevokeCert(context.Background(), request)
+	if err != nil {
+		log.Warn("CaServer.RevokeNode: revoke node failed", "err", err)
+		return err
+	}
+	return nil
+}
+
+// 请求ca注册网络, address为网络管理员地址, 为空时使用keys对应的地址
+func EnrollNet(address, net string) error {
+	if address == "" {
+		var err error
+		address, err = AccountAddress(net)
+		if err != nil {
+			log.Warn("CaServer.EnrollNet: get address failed", "err", err)
+			return err
+		}
+	}
+	sign, err := sign(net, []byte(address+net))
+	if err != nil {
+		log.Warn("CaServer.EnrollNet: sign error", "err", err)
+		return err
+	}
+	request := &pb.EnrollNetRequest{
+		Net:     net,
+		Address: address,
+		Sign:    sign,
+	}
+
+	conn, err := dialCa(net)
+	if err != nil {
+		log.Warn("CaServer.EnrollNet: create conn to ca failed")
+		return err
+	}
+	defer conn.Close()
+	client := pb.NewCaserverClient(conn)
+
+	_, err = client.NetAdminEnroll(context.Background(), request)
+	if err != nil {
+		log.Warn("CaServer.EnrollNet: enroll net failed", "err", err)
+		return err
+	}
+	return nil
+}
+
+// 请求ca 获取节点在网络中的证书, 并写入该网络的tlsPath
 func GetAndWriteCert(net string) error {
-	log.Info("CaServer.GetAndWriteCert: get node's cert")
-	path := config.GetTlsPath()
+	log.Info("CaServer.GetAndWriteCert: get node's cert", "net", net)
+	path := config.GetNetConfig(net).TlsPath
 
 	// 判断文件是否存在, 取一个文件
 	if ok := util_file.Exist(path + util_cert.CACERT); ok {
@@ -106,47 +257,65 @@ func GetAndWriteCert(net string) error {
 		return nil
 	}
 
-	if ok, _ := util_file.PathExists(config.GetTlsPath()); !ok {
-		os.MkdirAll(config.GetTlsPath(), os.ModePerm)
+	if ok, _ := util_file.PathExists(path); !ok {
+		os.MkdirAll(path, os.ModePerm)
 	}
 
 	// 先拉取下证书
-	cert, nodeHdPriKey, err := GetCurrentCert(net)
+	cert, nodeHdPriKey, err := fetchCurrentCert(net)
 	if err != nil {
 		return err
 	}
-	// 存储节点一级子私钥
+	// 存储节点一级子私钥, 私钥通过keystore保存
 	if nodeHdPriKey != "" {
-		err = util_file.WriteFileUsingFilename(path+util_cert.NODEHDPRIKEY, []byte(nodeHdPriKey))
+		if err := keystore.Write(keystore.NetKey(keystore.KeyHd, net), []byte(nodeHdPriKey)); err != nil {
+			log.Error("CaServer.GetAndWriteCert: write hd private key failed", "err", err)
+			return err
+		}
+	}
+	if err := keystore.Write(keystore.NetKey(keystore.KeyTls, net), []byte(cert.PrivateKey)); err != nil {
+		log.Error("CaServer.GetAndWriteCert: write private key failed", "err", err)
+		return err
 	}
-	log.Error("CaServer.GetAndWriteCert: get create ca conn failed", "err", nodeHdPriKey)
 	// 写文件
-	err = util_file.WriteFileUsingFilename(path+util_cert.CACERT, []byte(cert.CaCert))
 	err = util_file.WriteFileUsingFilename(path+util_cert.CERT, []byte(cert.Cert))
-	err = util_file.WriteFileUsingFilename(path+util_cert.PRIVATEKEY, []byte(cert.PrivateKey))
-	return err
+	if err != nil {
+		return err
+	}
+	// cacert最后写入, 以其存在判断证书是否已拉取
+	return util_file.WriteFileUsingFilename(path+util_cert.CACERT, []byte(cert.CaCert))
 }
 
 // 请求ca获取本节点的证书
 func GetCurrentCert(net string) (*CurrentCert, string, error) {
+	signer, _, err := accountSigner(net)
+	if err != nil {
+		log.Error("CaServer.GetCurrentCert: can not get account key", "err", err)
+		return nil, "", err
+	}
+	return getCurrentCert(net, signer)
+}
+
+// getCurrentCert 请求ca获取signer对应账户的证书
+func getCurrentCert(net string, signer gocrypto.Signer) (*CurrentCert, string, error) {
+	if err := checkCertTransport(); err != nil {
+		log.Error("CaServer.GetCurrentCert: refuse insecure ca conn", "err", err)
+		return nil, "", err
+	}
 	// 拿不到证书 3秒超时
-	conn, err := grpc.Dial(config.GetCaConfig().Host, grpc.WithInsecure(),
-		grpc.WithTimeout(time.Second*time.Duration(3)))
+	conn, err := dialCa(net, grpc.WithTimeout(time.Second*time.Duration(3)))
 	if err != nil {
 		log.Error("CaServer.GetCurrentCert: create ca conn failed", "err", err)
 		return nil, "", err
 	}
 	defer conn.Close()
 
-	cryptoClient := crypto.GetCryptoClient()
-	// get publicKey
-	publicKey, err := cryptoClient.GetEcdsaPublicKeyFromFile(config.GetKeys() + "public.key")
-	address, err := cryptoClient.GetAddressFromPublicKey(publicKey)
+	address, err := signerAddress(signer)
 	if err != nil {
 		log.Warn("CaServer.GetCurrentCert: get address failed", "err", err)
 	}
 
-	sign, err := sign([]byte(address + net))
+	sign, err := signWith(signer, []byte(address+net))
 	if err != nil {
 		log.Error("CaServer.GetCurrentCert: sign error", "err", err)
 		return nil, "", err
@@ -169,91 +338,63 @@ func GetCurrentCert(net string) (*CurrentCert, string, error) {
 	}, ret.NodeHdPriKey, nil
 }
 
-// 获取证书的撤销列表
+// 获取证书的撤销列表, 从本地记录的同步进度开始增量同步, 本地ca模式下直接从本地数据库加载
 func GetRevokeList(net string) error {
-	// 从数据库获取最新的serial_num
+	_, err := syncRevokeList(net, 0)
+	return err
+}
+
+// syncRevokeList 增量同步撤销列表, wait大于0时请求ca长轮询, 返回ca是否支持长轮询
+func syncRevokeList(net string, wait time.Duration) (bool, error) {
+	if localCertSource != nil {
+		return false, LoadRevokeSet(net)
+	}
+	if err := checkRevokeVerify(); err != nil {
+		return false, err
+	}
 	revokeDao := dao.RevokeDao{
 		Log: log,
 	}
-	serialNum, err := revokeDao.GetLatestSerialNum(net)
+	checkpoint, err := revokeDao.GetCheckpoint(net)
 	if err != nil {
-		log.Warn("CaServer.GetRevokeList: cat get latest serial num")
+		return false, err
 	}
-
-	request := &pb.RevokeListRequest{
-		Net:       net,
-		SerialNum: serialNum,
-	}
-
-	conn, err := grpc.Dial(config.GetCaConfig().Host, grpc.WithInsecure())
-	if err != nil {
-		log.Error("CaServer.GetRevokeList: create ca conn failed", "err", err)
-		return err
+	var serialNum string
+	var lastId int64
+	if checkpoint != nil {
+		serialNum = checkpoint.SerialNum
+		lastId = checkpoint.LastId
+	} else if serialNum, err = revokeDao.GetLatestSerialNum(net); err != nil {
+		// 兼容没有同步进度的旧数据, 本地为空时全量同步
+		serialNum = ""
 	}
-	defer conn.Close()
 
-	sign, err := sign([]byte(serialNum + net))
+	list, longPoll, err := fetchRevokeList(net, serialNum, wait)
 	if err != nil {
-		log.Error("CaServer.GetRevokeList: sign error", "err", err)
-		return err
+		return false, err
 	}
 
-	client := pb.NewCaserverClient(conn)
-	ctx := context.Background()
-	request.Sign = sign
-
-	ret, err := client.GetRevokeList(ctx, request)
-	if err != nil {
-		log.Error("CaServer.GetRevokeList: get revoke list request failed")
-		return err
-	}
-
-	// 保存到数据库
-	for _, row := range ret.List {
-		_, err = revokeDao.Insert(&dao.Revoke{
-			Id:         int(row.Id),
-			Net:        net,
-			SerialNum:  row.SerialNum,
-			CreateTime: int(row.CreateTime),
-		})
+	// 整批在一个事务中写入并推进同步进度, 遇到未通过签名校验的记录时只写入并推进到它之前,
+	// 下次同步从该记录重新开始, 直到ca返回正确签名的记录或调整netAdmins配置
+	accepted, rejected := acceptedRevokes(net, list, lastId)
+	revokes, next := newRevokeBatch(net, accepted, lastId)
+	if next != nil {
+		inserted, err := revokeDao.SaveBatch(net, revokes, nil, next)
 		if err != nil {
-			// @todo batch insert?
-			log.Warn("CaServer.GetRevokeList: insert into revoke failed", "err", err, "id", row.Id)
+			log.Error("CaServer.GetRevokeList: save revoke list failed", "err", err, "net", net)
+			return longPoll, err
 		}
+		log.Info("CaServer.GetRevokeList: revoke list synced", "net", net, "inserted", inserted, "lastId", next.LastId)
 	}
-	return nil
-}
-
-// 启动定时器拉取撤销证书
-func GetRevokeListRegularly(net string) error {
-	go func() {
-		for {
-			// 拉取证书撤销列表
-			err := GetRevokeList(net)
-			if err != nil {
-				log.Error("CaServer.GetRevokeListRegularly: get revoke list", "err", err)
-			}
-			now := time.Now()
-			// 每十分钟执行一次
-			next := now.Add(time.Minute * 10)
-			next = time.Date(next.Year(), next.Month(), next.Day(), next.Hour(), next.Minute(), next.Second(), 0,
-				next.Location())
-			t := time.NewTimer(next.Sub(now))
-			<-t.C
+	if len(rejected) > 0 {
+		// 同步未完成, 不更新同步时间, 长时间未解决时触发撤销列表过期告警
+		log.Error("CaServer.GetRevokeList: revoke sync blocked by rejected entries", "net", net, "rejected", rejected)
+		if err := LoadRevokeSet(net); err != nil {
+			return longPoll, err
 		}
-	}()
-	return nil
-}
-
-// 证书是否有效,使用serialNum进行判断
-func IsValidCert(serialNum string) bool {
-	revoleNodeDao := dao.RevokeDao{
-		Log: log,
-	}
-	ret, err := revoleNodeDao.GetBySerialNum(serialNum)
-	// 数据库中查不到
-	if err != nil || ret == nil {
-		return true
+		return longPoll, ErrRevokeRejected
 	}
-	return false
+	markRevokeSynced(net)
+	// 同步后重新加载内存中的撤销列表
+	return longPoll, LoadRevokeSet(net)
 }
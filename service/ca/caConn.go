@@ -0,0 +1,49 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package service
+
+import (
+	"errors"
+
+	"google.golang.org/grpc"
+
+	"github.com/xuperchain/xuper-front/config"
+	util_cert "github.com/xuperchain/xuper-front/util/cert"
+)
+
+var ErrInsecureCaConn = errors.New("refuse to get cert from ca over plaintext: configure caConfig.tls with enable: true and rootCert (or fingerprint) of the ca server, " +
+	"or set caConfig.tls.allowInsecureCert: true for test networks, see conf/front.yaml")
+
+// dialCa 建立到网络ca的连接, 开启caConfig.tls时使用tls并校验ca服务端证书
+func dialCa(net string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	cfg := config.GetCaConfig()
+	if cfg.Tls.Enable {
+		creds, err := util_cert.GenCaCreds(net, cfg.Tls)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.WithTransportCredentials(creds))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+	return grpc.Dial(config.GetNetConfig(net).CaHost, opts...)
+}
+
+// checkCertTransport 证书和私钥只允许通过tls获取, 除非显式允许明文
+func checkCertTransport() error {
+	cfg := config.GetCaConfig().Tls
+	if !cfg.Enable && !cfg.AllowInsecureCert {
+		return ErrInsecureCaConn
+	}
+	return nil
+}
+
+// CheckCaConfig 启动时检查访问远程ca的配置, 避免启动后获取证书时才失败
+func CheckCaConfig() error {
+	cfg := config.GetCaConfig()
+	if !cfg.CaSwitch || cfg.LocalCaSwitch {
+		return nil
+	}
+	return checkCertTransport()
+}
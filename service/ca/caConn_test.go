@@ -0,0 +1,47 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/xuperchain/xuper-front/config"
+)
+
+func TestCheckCaConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "caconn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer config.InstallFrontConfig("../../conf/front.yaml")
+
+	cases := []struct {
+		name string
+		conf string
+		ok   bool
+	}{
+		{"plaintext", "caConfig:\n  caSwitch: true\n", false},
+		{"tls", "caConfig:\n  caSwitch: true\n  tls:\n    enable: true\n", true},
+		{"insecure", "caConfig:\n  caSwitch: true\n  tls:\n    allowInsecureCert: true\n", true},
+		{"localca", "caConfig:\n  caSwitch: true\n  localCaSwitch: true\n", true},
+		{"public", "caConfig:\n  caSwitch: false\n", true},
+	}
+	for _, c := range cases {
+		// viper会累积配置目录, 文件名需唯一
+		path := filepath.Join(dir, "caconn_"+c.name+".yaml")
+		if err := ioutil.WriteFile(path, []byte(c.conf), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := config.InstallFrontConfig(path); err != nil {
+			t.Fatal(err)
+		}
+		if err := CheckCaConfig(); (err == nil) != c.ok {
+			t.Errorf("%s: unexpected result %v", c.name, err)
+		}
+	}
+}
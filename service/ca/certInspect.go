@@ -0,0 +1,124 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package service
+
+import (
+	"crypto/x509"
+	"database/sql"
+	"io/ioutil"
+	"time"
+
+	"github.com/xuperchain/xuper-front/config"
+	"github.com/xuperchain/xuper-front/dao"
+	util_cert "github.com/xuperchain/xuper-front/util/cert"
+)
+
+// CertReport tlsPath下节点证书的检查结果
+type CertReport struct {
+	Path      string `json:"path"`
+	Subject   string `json:"subject"`
+	Issuer    string `json:"issuer"`
+	SerialNum string `json:"serialNum"`
+	// 证书Subject.SerialNumber中的节点地址
+	Address     string    `json:"address"`
+	DNSNames    []string  `json:"dnsNames"`
+	IPAddresses []string  `json:"ipAddresses"`
+	NotBefore   time.Time `json:"notBefore"`
+	NotAfter    time.Time `json:"notAfter"`
+	// 当前时间是否在有效期内
+	Valid bool `json:"valid"`
+	// 证书能否通过cacert.pem校验
+	Chained    bool   `json:"chained"`
+	ChainError string `json:"chainError,omitempty"`
+	// serialNum是否在本地revoke_node中
+	Revoked     bool   `json:"revoked"`
+	RevokeError string `json:"revokeError,omitempty"`
+	// 私钥是否与证书匹配
+	KeyMatch bool   `json:"keyMatch"`
+	KeyError string `json:"keyError,omitempty"`
+}
+
+// Healthy 证书在有效期内、能通过cacert校验、未被撤销且私钥匹配
+func (r *CertReport) Healthy() bool {
+	return r.Valid && r.Chained && !r.Revoked && r.RevokeError == "" && r.KeyMatch
+}
+
+// InspectCert 检查网络tlsPath下的证书: 证书信息、证书链、本地撤销列表以及私钥是否匹配
+func InspectCert(net string) (*CertReport, error) {
+	path := config.GetNetConfig(net).TlsPath
+	certPem, err := ioutil.ReadFile(path + util_cert.CERT)
+	if err != nil {
+		return nil, err
+	}
+	caPem, err := ioutil.ReadFile(path + util_cert.CACERT)
+	if err != nil {
+		return nil, err
+	}
+	report, err := inspectCert(certPem, caPem, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	report.Path = path
+
+	revokeDao := dao.RevokeDao{
+		Log: log,
+	}
+	if _, err := revokeDao.GetBySerialNum(net, report.SerialNum); err == nil {
+		report.Revoked = true
+	} else if err != sql.ErrNoRows {
+		report.RevokeError = err.Error()
+	}
+
+	// 私钥通过keystore读取, 与证书不匹配时返回错误
+	if _, err := util_cert.LoadKeyPair(net); err != nil {
+		report.KeyError = err.Error()
+	} else {
+		report.KeyMatch = true
+	}
+	return report, nil
+}
+
+// inspectCert 解析证书并使用caPem校验证书链, cert.pem中第一个之后的证书作为中间证书
+func inspectCert(certPem []byte, caPem []byte, now time.Time) (*CertReport, error) {
+	certs, err := util_cert.ParseCerts(certPem)
+	if err != nil {
+		return nil, err
+	}
+	cert := certs[0]
+	report := &CertReport{
+		Subject:   cert.Subject.String(),
+		Issuer:    cert.Issuer.String(),
+		SerialNum: cert.SerialNumber.String(),
+		Address:   cert.Subject.SerialNumber,
+		DNSNames:  cert.DNSNames,
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+		Valid:     !now.Before(cert.NotBefore) && !now.After(cert.NotAfter),
+	}
+	for _, ip := range cert.IPAddresses {
+		report.IPAddresses = append(report.IPAddresses, ip.String())
+	}
+
+	roots := x509.NewCertPool()
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+	if !roots.AppendCertsFromPEM(caPem) {
+		report.ChainError = "no certificate found in " + util_cert.CACERT
+		return report, nil
+	}
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		report.ChainError = err.Error()
+	} else {
+		report.Chained = true
+	}
+	return report, nil
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestInspectCert(t *testing.T) {
+	caCert, caKey := newTestCa(t, "ca")
+	otherCa, _ := newTestCa(t, "other")
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "testnet", SerialNumber: "dpzuVdosQrF2kmzumhVeFQZa1aYcdgFpN"},
+		DNSNames:     []string{"testnet"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(30 * time.Minute),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	caPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})
+
+	report, err := inspectCert(certPem, caPem, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.SerialNum != "42" || report.Address != "dpzuVdosQrF2kmzumhVeFQZa1aYcdgFpN" || len(report.DNSNames) != 1 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if !report.Valid || !report.Chained {
+		t.Fatalf("cert should be valid and chained: %+v", report)
+	}
+
+	// 过期的证书
+	report, _ = inspectCert(certPem, caPem, time.Now().Add(time.Hour))
+	if report.Valid || report.Chained {
+		t.Fatalf("expired cert should not be valid: %+v", report)
+	}
+
+	// 其他ca签发的证书
+	otherPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: otherCa.Raw})
+	report, _ = inspectCert(certPem, otherPem, time.Now())
+	if report.Chained || report.ChainError == "" {
+		t.Fatalf("cert should not chain to other ca: %+v", report)
+	}
+}
@@ -0,0 +1,397 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package service
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xuperchain/xuper-front/config"
+	util_cert "github.com/xuperchain/xuper-front/util/cert"
+)
+
+const (
+	// defaultCrlRefreshInterval 未配置时CRL的刷新间隔
+	defaultCrlRefreshInterval = time.Hour
+	// crlFetchTimeout 拉取CRL和查询OCSP的超时时间, 握手时可能同步查询OCSP, 不宜过长
+	crlFetchTimeout = 5 * time.Second
+	// maxCrlSize CRL文件大小上限
+	maxCrlSize = 16 * 1024 * 1024
+	// crlPendingSize 等待后台加载的CRL来源的队列长度
+	crlPendingSize = 64
+)
+
+var (
+	ErrCrlIssuerUnknown = errors.New("crl is not signed by a trusted ca")
+	ErrCrlNotLoaded     = errors.New("crl distribution point is not loaded yet")
+	ErrCrlStale         = errors.New("crl is past its next update")
+
+	// crls 从CRL文件或分发点加载的撤销证书
+	crls = newCrlStore()
+
+	httpClient = &http.Client{
+		Timeout: crlFetchTimeout,
+	}
+)
+
+// crlEntry 一个CRL来源的内容, issuer为签发CRL的ca证书的RawSubject
+type crlEntry struct {
+	issuer     string
+	serials    map[string]struct{}
+	nextUpdate time.Time
+}
+
+// stale CRL是否已超过nextUpdate
+func (e *crlEntry) stale(now time.Time) bool {
+	return !e.nextUpdate.IsZero() && now.After(e.nextUpdate)
+}
+
+// crlStore 按来源保存CRL, 刷新时整体替换对应来源的内容
+// 握手时不拉取CRL, 未加载或过期的来源放入pending由后台加载
+type crlStore struct {
+	entries map[string]*crlEntry
+	// requested 已在pending中等待加载的来源
+	requested map[string]bool
+	pending   chan string
+	mutex     sync.RWMutex
+}
+
+func newCrlStore() *crlStore {
+	return &crlStore{
+		entries:   make(map[string]*crlEntry),
+		requested: make(map[string]bool),
+		pending:   make(chan string, crlPendingSize),
+	}
+}
+
+// load 拉取并解析source, 使用issuers校验CRL签名
+func (s *crlStore) load(source string, issuers []*x509.Certificate) error {
+	data, err := fetch(source)
+	if err != nil {
+		return err
+	}
+	crl, err := parseCRL(data)
+	if err != nil {
+		return err
+	}
+	issuer, err := verifyCRL(crl, issuers)
+	if err != nil {
+		return err
+	}
+	entry := &crlEntry{
+		issuer:     string(issuer.RawSubject),
+		serials:    make(map[string]struct{}, len(crl.RevokedCertificateEntries)),
+		nextUpdate: crl.NextUpdate,
+	}
+	for _, revoked := range crl.RevokedCertificateEntries {
+		entry.serials[revoked.SerialNumber.String()] = struct{}{}
+	}
+	s.mutex.Lock()
+	s.entries[source] = entry
+	s.mutex.Unlock()
+	return nil
+}
+
+// request 请求后台加载source, 已在等待加载或队列已满时忽略
+func (s *crlStore) request(source string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.requested[source] {
+		return
+	}
+	select {
+	case s.pending <- source:
+		s.requested[source] = true
+	default:
+	}
+}
+
+// loadPending 后台加载pending中的来源
+func (s *crlStore) loadPending() {
+	for source := range s.pending {
+		issuers, err := loadAllIssuers()
+		if err == nil {
+			err = s.load(source, issuers)
+		}
+		s.mutex.Lock()
+		delete(s.requested, source)
+		s.mutex.Unlock()
+		if err != nil {
+			log.Warn("CaServer.loadPending: load crl failed", "source", source, "err", err)
+			continue
+		}
+		notifyRevokeListUpdated()
+	}
+}
+
+// check 检查source是否已加载且未过期, 未加载或已过期时请求后台加载
+func (s *crlStore) check(source string) error {
+	s.mutex.RLock()
+	entry, ok := s.entries[source]
+	s.mutex.RUnlock()
+	if !ok {
+		s.request(source)
+		return ErrCrlNotLoaded
+	}
+	if entry.stale(time.Now()) {
+		s.request(source)
+		return ErrCrlStale
+	}
+	return nil
+}
+
+// staleSources issuer签发的已超过nextUpdate的来源
+func (s *crlStore) staleSources(issuer []byte) []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	now := time.Now()
+	var ret []string
+	for source, entry := range s.entries {
+		if entry.issuer == string(issuer) && entry.stale(now) {
+			ret = append(ret, source)
+		}
+	}
+	return ret
+}
+
+// revoked issuer签发的serialNum证书是否被撤销, 不同ca签发的证书序列号可能相同, 只查该ca签发的CRL
+func (s *crlStore) revoked(issuer []byte, serialNum string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for _, entry := range s.entries {
+		if entry.issuer != string(issuer) {
+			continue
+		}
+		if _, ok := entry.serials[serialNum]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *crlStore) sources() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	ret := make([]string, 0, len(s.entries))
+	for source := range s.entries {
+		ret = append(ret, source)
+	}
+	return ret
+}
+
+// serials issuer签发的CRL中的撤销证书序列号
+func (s *crlStore) serials(issuer []byte) []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var ret []string
+	for _, entry := range s.entries {
+		if entry.issuer != string(issuer) {
+			continue
+		}
+		for serialNum := range entry.serials {
+			ret = append(ret, serialNum)
+		}
+	}
+	return ret
+}
+
+// parseCRL 解析PEM或DER格式的CRL
+func parseCRL(data []byte) (*x509.RevocationList, error) {
+	if block, _ := pem.Decode(data); block != nil && block.Type == "X509 CRL" {
+		data = block.Bytes
+	}
+	return x509.ParseRevocationList(data)
+}
+
+// verifyCRL 校验CRL由issuers中的某个证书签发, 返回签发的证书
+func verifyCRL(crl *x509.RevocationList, issuers []*x509.Certificate) (*x509.Certificate, error) {
+	for _, issuer := range issuers {
+		if bytes.Equal(crl.RawIssuer, issuer.RawSubject) && crl.CheckSignatureFrom(issuer) == nil {
+			return issuer, nil
+		}
+	}
+	return nil, ErrCrlIssuerUnknown
+}
+
+// fetch 读取本地文件或通过http(s)下载
+func fetch(source string) ([]byte, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return ioutil.ReadFile(source)
+	}
+	resp, err := httpClient.Get(source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch %s: unexpected status %s", source, resp.Status)
+	}
+	return ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, maxCrlSize))
+}
+
+// loadIssuers 读取网络tlsPath下的ca证书, 用于校验CRL和OCSP响应
+func loadIssuers(net string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(config.GetNetConfig(net).TlsPath + util_cert.CACERT)
+	if err != nil {
+		return nil, err
+	}
+	return util_cert.ParseCerts(data)
+}
+
+// loadAllIssuers 读取所有网络的ca证书, CRL可以由其中任意一个签发
+func loadAllIssuers() ([]*x509.Certificate, error) {
+	var all []*x509.Certificate
+	for _, n := range config.GetNets() {
+		issuers, err := loadIssuers(n.Name)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, issuers...)
+	}
+	return all, nil
+}
+
+// issuerOf 从issuers中找出签发cert的证书
+func issuerOf(cert *x509.Certificate, issuers []*x509.Certificate) *x509.Certificate {
+	for _, issuer := range issuers {
+		if cert.CheckSignatureFrom(issuer) == nil {
+			return issuer
+		}
+	}
+	return nil
+}
+
+// refreshCrls 重新加载配置的CRL来源以及已知的CRL分发点
+func refreshCrls() {
+	issuers, err := loadAllIssuers()
+	if err != nil {
+		log.Error("CaServer.refreshCrls: load ca cert failed", "err", err)
+		return
+	}
+	sources := append([]string{}, config.GetCrl().Sources...)
+	for _, source := range crls.sources() {
+		if !contains(sources, source) {
+			sources = append(sources, source)
+		}
+	}
+	for _, source := range sources {
+		if err := crls.load(source, issuers); err != nil {
+			log.Warn("CaServer.refreshCrls: load crl failed, keep current", "source", source, "err", err)
+		}
+	}
+	notifyRevokeListUpdated()
+}
+
+// StartCrlRefresher 启动定时器刷新CRL以及后台加载CRL分发点, 配置了export时撤销列表每次更新后重新导出CRL
+func StartCrlRefresher() {
+	cfg := config.GetCrl()
+	if cfg.Export != "" {
+		OnRevokeListUpdated(exportCRL)
+		exportCRL()
+	}
+	if len(cfg.Sources) == 0 && !cfg.DistributionPoints {
+		return
+	}
+	interval := time.Duration(cfg.RefreshInterval) * time.Minute
+	if interval <= 0 {
+		interval = defaultCrlRefreshInterval
+	}
+	go crls.loadPending()
+	go func() {
+		for {
+			refreshCrls()
+			t := time.NewTimer(interval)
+			<-t.C
+		}
+	}()
+}
+
+// checkDistributionPoints 使用已加载的CRL判断cert是否被撤销, 未加载或过期的分发点由后台加载, 不阻塞握手
+func checkDistributionPoints(cert *x509.Certificate) (bool, error) {
+	var lastErr error
+	for _, source := range cert.CRLDistributionPoints {
+		if err := crls.check(source); err != nil {
+			lastErr = err
+		}
+	}
+	return crls.revoked(cert.RawIssuer, cert.SerialNumber.String()), lastErr
+}
+
+// checkStaleCrls 对端证书的ca的CRL过期时请求后台刷新, 配置rejectStale时返回ErrCrlStale
+func checkStaleCrls(cert *x509.Certificate) error {
+	sources := crls.staleSources(cert.RawIssuer)
+	for _, source := range sources {
+		crls.request(source)
+	}
+	if len(sources) > 0 && config.GetCrl().RejectStale {
+		return ErrCrlStale
+	}
+	return nil
+}
+
+// IsValidPeerCert 校验网络中的对端证书是否被撤销, 依次使用ca撤销列表、CRL分发点和OCSP
+// CRL分发点或OCSP不可用时按照revokeFailClosed决定是否放行, CRL过期时按照crl.rejectStale决定是否放行
+func IsValidPeerCert(net string, cert *x509.Certificate) bool {
+	serialNum := cert.SerialNumber.String()
+	if !IsValidCert(net, cert.RawIssuer, serialNum) {
+		return false
+	}
+	if err := checkStaleCrls(cert); err != nil {
+		log.Warn("CaServer.IsValidPeerCert: crl is stale, reject", "serialNum", serialNum)
+		return false
+	}
+	cfg := config.GetCrl()
+	if !cfg.DistributionPoints && !cfg.Ocsp {
+		return true
+	}
+	failClosed := config.GetCaConfig().RevokeFailClosed
+	if cfg.DistributionPoints && len(cert.CRLDistributionPoints) > 0 {
+		revoked, err := checkDistributionPoints(cert)
+		if revoked {
+			return false
+		}
+		if err != nil {
+			log.Warn("CaServer.IsValidPeerCert: crl distribution point unavailable", "serialNum", serialNum, "err", err)
+			if failClosed {
+				return false
+			}
+		}
+	}
+	if !cfg.Ocsp || len(cert.OCSPServer) == 0 {
+		return true
+	}
+	issuers, err := loadIssuers(net)
+	if err != nil {
+		log.Warn("CaServer.IsValidPeerCert: load ca cert failed", "err", err)
+		return !failClosed
+	}
+	issuer := issuerOf(cert, issuers)
+	if issuer == nil {
+		log.Warn("CaServer.IsValidPeerCert: issuer of cert not found", "serialNum", serialNum)
+		return !failClosed
+	}
+	revoked, err := ocspResponses.check(cert, issuer)
+	if err != nil {
+		log.Warn("CaServer.IsValidPeerCert: ocsp check failed", "serialNum", serialNum, "err", err)
+		return !failClosed
+	}
+	return !revoked
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package service
+
+import (
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"time"
+
+	"github.com/xuperchain/xuper-front/config"
+	"github.com/xuperchain/xuper-front/dao"
+	util_cert "github.com/xuperchain/xuper-front/util/cert"
+	util_file "github.com/xuperchain/xuper-front/util/file"
+)
+
+// defaultCrlValidity 导出CRL的默认有效期
+const defaultCrlValidity = 24 * time.Hour
+
+// ExportCRL 将网络当前的撤销列表导出为PEM格式的CRL, 使用本节点在该网络的证书和私钥签名
+func ExportCRL(net string, filename string) error {
+	keyPair, err := util_cert.LoadKeyPair(net)
+	if err != nil {
+		return err
+	}
+	issuer := keyPair.Leaf
+	revokeDao := dao.RevokeDao{
+		Log: log,
+	}
+	revokes, err := revokeDao.List(net)
+	if err != nil {
+		return err
+	}
+	validity := time.Duration(config.GetCrl().ExportValidity) * time.Hour
+	if validity <= 0 {
+		validity = defaultCrlValidity
+	}
+	now := time.Now()
+	// 只合并网络ca签发的CRL
+	revoked := buildRevokedCerts(revokes, crls.serials(issuer.RawIssuer), now)
+	der, err := issuer.CreateCRL(rand.Reader, keyPair.PrivateKey, revoked, now, now.Add(validity))
+	if err != nil {
+		return err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+	return util_file.WriteFilesAtomic(map[string][]byte{filename: data}, 0644)
+}
+
+// buildRevokedCerts 合并ca撤销列表和CRL中的证书, CRL中的证书撤销时间未知, 使用导出时间
+func buildRevokedCerts(revokes []dao.Revoke, crlSerials []string, now time.Time) []pkix.RevokedCertificate {
+	seen := make(map[string]bool, len(revokes)+len(crlSerials))
+	ret := make([]pkix.RevokedCertificate, 0, len(revokes)+len(crlSerials))
+	add := func(serialNum string, revokedAt time.Time) {
+		serial, ok := new(big.Int).SetString(serialNum, 10)
+		if !ok || seen[serialNum] {
+			return
+		}
+		seen[serialNum] = true
+		ret = append(ret, pkix.RevokedCertificate{
+			SerialNumber:   serial,
+			RevocationTime: revokedAt.UTC(),
+		})
+	}
+	for _, revoke := range revokes {
+		add(revoke.SerialNum, time.Unix(int64(revoke.CreateTime), 0))
+	}
+	for _, serialNum := range crlSerials {
+		add(serialNum, now)
+	}
+	return ret
+}
+
+// exportCRL 按配置导出CRL
+func exportCRL() {
+	filename := config.GetCrl().Export
+	if filename == "" {
+		return
+	}
+	if err := ExportCRL(config.GetNet(), filename); err != nil {
+		log.Error("CaServer.exportCRL: export crl failed", "file", filename, "err", err)
+	}
+}
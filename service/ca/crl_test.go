@@ -0,0 +1,150 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xuperchain/xuper-front/dao"
+)
+
+func newTestCa(t *testing.T, cn string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestCrlStoreLoad(t *testing.T) {
+	ca, key := newTestCa(t, "ca")
+	other, _ := newTestCa(t, "other")
+	revoked := []pkix.RevokedCertificate{
+		{SerialNumber: big.NewInt(100), RevocationTime: time.Now()},
+	}
+	der, err := ca.CreateCRL(rand.Reader, key, revoked, time.Now(), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "crl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pemFile := filepath.Join(dir, "crl.pem")
+	derFile := filepath.Join(dir, "crl.der")
+	ioutil.WriteFile(pemFile, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0644)
+	ioutil.WriteFile(derFile, der, 0644)
+
+	s := newCrlStore()
+	if err := s.load(pemFile, []*x509.Certificate{other}); err != ErrCrlIssuerUnknown {
+		t.Fatalf("crl signed by unknown ca should be rejected, err: %v", err)
+	}
+	if s.revoked(ca.RawSubject, "100") {
+		t.Fatal("rejected crl should not be loaded")
+	}
+	for _, file := range []string{pemFile, derFile} {
+		if err := s.load(file, []*x509.Certificate{other, ca}); err != nil {
+			t.Fatalf("load %s failed: %v", file, err)
+		}
+	}
+	if !s.revoked(ca.RawSubject, "100") || s.revoked(ca.RawSubject, "101") {
+		t.Fatal("unexpected revoked serials")
+	}
+	// 其他ca签发的相同序列号的证书不受影响
+	if s.revoked(other.RawSubject, "100") {
+		t.Fatal("crl should only apply to certs of its issuer")
+	}
+	// pem和der两个来源各有一条
+	if serials := s.serials(ca.RawSubject); len(serials) != 2 || len(s.serials(other.RawSubject)) != 0 {
+		t.Fatalf("unexpected serials %v", serials)
+	}
+	if err := s.check(pemFile); err != nil || len(s.pending) != 0 {
+		t.Fatalf("loaded crl within nextUpdate should not be reloaded: %v", err)
+	}
+}
+
+func TestCrlStoreCheck(t *testing.T) {
+	ca, key := newTestCa(t, "ca")
+	der, err := ca.CreateCRL(rand.Reader, key, nil, time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "crl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "stale.crl")
+	ioutil.WriteFile(file, der, 0644)
+
+	// 未加载的分发点不在握手时拉取, 放入后台加载队列, 重复请求只入队一次
+	s := newCrlStore()
+	if err := s.check(file); err != ErrCrlNotLoaded {
+		t.Fatalf("expect ErrCrlNotLoaded, got %v", err)
+	}
+	s.check(file)
+	if len(s.pending) != 1 {
+		t.Fatalf("source should be queued once, got %d", len(s.pending))
+	}
+	<-s.pending
+	delete(s.requested, file)
+
+	// 超过nextUpdate的CRL视为过期, 请求后台刷新
+	if err := s.load(file, []*x509.Certificate{ca}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.check(file); err != ErrCrlStale || len(s.pending) != 1 {
+		t.Fatalf("stale crl should be refreshed, err: %v", err)
+	}
+	if sources := s.staleSources(ca.RawSubject); len(sources) != 1 || sources[0] != file {
+		t.Fatalf("unexpected stale sources %v", sources)
+	}
+}
+
+func TestBuildRevokedCerts(t *testing.T) {
+	now := time.Now()
+	revokes := []dao.Revoke{
+		{SerialNum: "1", CreateTime: 1000},
+		{SerialNum: "not-a-number"},
+	}
+	ret := buildRevokedCerts(revokes, []string{"1", "2"}, now)
+	if len(ret) != 2 {
+		t.Fatalf("expect 2 revoked certs, got %d", len(ret))
+	}
+	if ret[0].SerialNumber.Int64() != 1 || ret[0].RevocationTime.Unix() != 1000 {
+		t.Fatalf("unexpected entry: %v", ret[0])
+	}
+	if ret[1].SerialNumber.Int64() != 2 || !ret[1].RevocationTime.Equal(now.UTC()) {
+		t.Fatalf("unexpected entry: %v", ret[1])
+	}
+}
@@ -0,0 +1,10 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package service
+
+// 供service_test中与本地ca联调的测试使用
+var (
+	SignWith        = signWith
+	AcceptedRevokes = acceptedRevokes
+)
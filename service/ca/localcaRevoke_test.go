@@ -0,0 +1,97 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package service_test
+
+import (
+	gocrypto "crypto"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/xuperchain/xuper-front/config"
+	xcrypto "github.com/xuperchain/xuper-front/crypto"
+	"github.com/xuperchain/xuper-front/dao"
+	"github.com/xuperchain/xuper-front/logs"
+	"github.com/xuperchain/xuper-front/pb"
+	serv_ca "github.com/xuperchain/xuper-front/service/ca"
+	serv_localca "github.com/xuperchain/xuper-front/service/localca"
+	"github.com/xuperchain/xuper-front/util/keystore"
+)
+
+// 本地ca保存的撤销记录能通过front同步时的签名校验
+func TestLocalCaRevokeRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localcarevoke")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := config.InstallFrontConfig("../../conf/front.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	logs.InitLog("ca_test", dir)
+	serv_ca.StartCaHandler()
+	dao.InitTables()
+
+	newAccount := func() (string, gocrypto.Signer) {
+		account, err := xcrypto.GetCryptoClient().CreateNewAccountWithMnemonic(1, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signer, err := keystore.ParsePrivateKey([]byte(account.JsonPrivateKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return account.Address, signer
+	}
+	sign := func(signer gocrypto.Signer, data string) *pb.Sign {
+		s, err := serv_ca.SignWith(signer, []byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	admin, adminSigner := newAccount()
+	node, nodeSigner := newAccount()
+	config.GetConfig().CaConfig.LocalCaPath = dir
+	config.GetConfig().CaConfig.NetAdmins = []string{admin}
+	if err := serv_localca.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer serv_ca.UseLocalCa(nil)
+
+	net := "revokenet" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	if err := serv_localca.EnrollNet(sign(adminSigner, admin+net), net, admin); err != nil {
+		t.Fatal(err)
+	}
+	if err := serv_localca.EnrollNode(sign(adminSigner, node+net), net, node, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := serv_localca.GetCurrentCert(sign(nodeSigner, node+net), net, node); err != nil {
+		t.Fatal(err)
+	}
+	// 与revokeNode相同的方式签名撤销请求
+	if err := serv_localca.RevokeNode(sign(adminSigner, node+net), net, node); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := serv_localca.GetRevokeList(sign(adminSigner, net), net, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) == 0 {
+		t.Fatal("revoked cert should be listed")
+	}
+	accepted, rejected := serv_ca.AcceptedRevokes(net, list, 0)
+	if len(rejected) != 0 || len(accepted) != len(list) {
+		t.Fatalf("revoke entries of the local ca should be accepted, rejected %v", rejected)
+	}
+
+	// 其他管理员签名的记录被拒绝
+	config.GetConfig().CaConfig.NetAdmins = []string{node}
+	if _, rejected := serv_ca.AcceptedRevokes(net, list, 0); len(rejected) != len(list) {
+		t.Fatalf("entries not signed by the configured admins should be rejected, got %v", rejected)
+	}
+}
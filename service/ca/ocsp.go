@@ -0,0 +1,126 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package service
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/xuperchain/xuper-front/config"
+)
+
+const (
+	// defaultOcspCacheTtl OCSP响应未给出nextUpdate时的缓存时间
+	defaultOcspCacheTtl = 5 * time.Minute
+	// maxOcspResponseSize OCSP响应大小上限
+	maxOcspResponseSize = 1024 * 1024
+)
+
+// ocspResponses OCSP查询结果的本地缓存, 避免每次握手都访问responder, 按签发者和序列号缓存
+var ocspResponses = &ocspCache{
+	items: make(map[string]*ocspItem),
+}
+
+type ocspItem struct {
+	revoked bool
+	expire  time.Time
+}
+
+type ocspCache struct {
+	items map[string]*ocspItem
+	mutex sync.Mutex
+}
+
+// check 查询cert的OCSP状态, 优先使用缓存
+func (c *ocspCache) check(cert *x509.Certificate, issuer *x509.Certificate) (bool, error) {
+	key := ocspKey(cert.RawIssuer, cert.SerialNumber.String())
+	now := time.Now()
+	c.mutex.Lock()
+	item, ok := c.items[key]
+	c.mutex.Unlock()
+	if ok && now.Before(item.expire) {
+		return item.revoked, nil
+	}
+
+	resp, err := queryOcsp(cert, issuer)
+	if err != nil {
+		return false, err
+	}
+	item = &ocspItem{
+		revoked: resp.Status == ocsp.Revoked,
+		expire:  resp.NextUpdate,
+	}
+	if item.expire.IsZero() {
+		ttl := time.Duration(config.GetCrl().OcspCacheTtl) * time.Second
+		if ttl <= 0 {
+			ttl = defaultOcspCacheTtl
+		}
+		item.expire = now.Add(ttl)
+	}
+	c.mutex.Lock()
+	// 顺带清理过期的缓存
+	for key, v := range c.items {
+		if !now.Before(v.expire) {
+			delete(c.items, key)
+		}
+	}
+	c.items[key] = item
+	c.mutex.Unlock()
+	return item.revoked, nil
+}
+
+// revoked 缓存中是否有issuer签发的该证书被撤销的OCSP响应
+func (c *ocspCache) revoked(issuer []byte, serialNum string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	item, ok := c.items[ocspKey(issuer, serialNum)]
+	return ok && item.revoked && time.Now().Before(item.expire)
+}
+
+// ocspKey 缓存的key, issuer为证书的RawIssuer
+func ocspKey(issuer []byte, serialNum string) string {
+	return string(issuer) + "/" + serialNum
+}
+
+// queryOcsp 依次向cert中的OCSP地址查询, 返回第一个有效的响应
+func queryOcsp(cert *x509.Certificate, issuer *x509.Certificate) (*ocsp.Response, error) {
+	req, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+	var lastErr error
+	for _, server := range cert.OCSPServer {
+		resp, err := postOcsp(server, req, cert, issuer)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return resp, nil
+	}
+	return nil, lastErr
+}
+
+func postOcsp(server string, req []byte, cert *x509.Certificate, issuer *x509.Certificate) (*ocsp.Response, error) {
+	httpResp, err := httpClient.Post(server, "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ocsp %s: unexpected status %s", server, httpResp.Status)
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, httpResp.Body, maxOcspResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	// 校验响应由issuer或其授权的responder签发
+	return ocsp.ParseResponseForCert(body, cert, issuer)
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/xuperchain/xuper-front/config"
+	util_cert "github.com/xuperchain/xuper-front/util/cert"
+	"github.com/xuperchain/xuper-front/util/keystore"
+)
+
+var ErrCertNotRenewed = errors.New("ca has not issued a new cert yet")
+
+// StartCertRenewer 启动定时器, 证书进入更新窗口后向ca获取新证书, 并热替换代理使用的tls证书
+func StartCertRenewer(net string) {
+	renewBefore := time.Duration(config.GetCaConfig().RenewBefore) * time.Hour
+	if renewBefore <= 0 {
+		log.Info("CaServer.StartCertRenewer: cert renew disabled")
+		return
+	}
+	interval := time.Duration(config.GetCaConfig().RenewCheckInterval) * time.Minute
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	go func() {
+		for {
+			if err := renewCertIfNeeded(net, renewBefore); err != nil {
+				log.Error("CaServer.StartCertRenewer: renew cert failed", "err", err, "net", net)
+			}
+			t := time.NewTimer(interval)
+			<-t.C
+		}
+	}()
+}
+
+// renewCertIfNeeded 证书在renewBefore内过期时进行更新
+func renewCertIfNeeded(net string, renewBefore time.Duration) error {
+	cert, err := util_cert.LoadCert(net)
+	if err != nil {
+		return err
+	}
+	if time.Until(cert.NotAfter) > renewBefore {
+		return nil
+	}
+	log.Info("CaServer.renewCertIfNeeded: cert is about to expire, renew it", "serial", cert.SerialNumber.String(),
+		"notAfter", cert.NotAfter, "net", net)
+	return RenewCert(net)
+}
+
+// RenewCert 向ca获取网络的新证书(本地ca模式下由本地签发), 原子地写入该网络的tlsPath并重新加载tls证书
+func RenewCert(net string) error {
+	current, err := util_cert.LoadCert(net)
+	if err != nil {
+		return err
+	}
+	newCert, nodeHdPriKey, err := fetchCurrentCert(net)
+	if err != nil {
+		return err
+	}
+	path := config.GetNetConfig(net).TlsPath
+	// 校验ca返回的证书, 避免写入不可用的证书
+	parsed, err := util_cert.ParseCert([]byte(newCert.Cert))
+	if err != nil {
+		return err
+	}
+	if parsed.SerialNumber.Cmp(current.SerialNumber) == 0 {
+		return ErrCertNotRenewed
+	}
+	if err := util_cert.VerifyKeyPair([]byte(newCert.Cert), []byte(newCert.PrivateKey)); err != nil {
+		return err
+	}
+
+	// 私钥通过keystore保存, 先于证书写入, 调用Reload前已建立的tls配置不受影响
+	// 写入前备份当前的私钥和证书, 任一步失败时恢复, 不会留下与证书不匹配的私钥
+	keys := []string{keystore.NetKey(keystore.KeyTls, net)}
+	if nodeHdPriKey != "" {
+		keys = append(keys, keystore.NetKey(keystore.KeyHd, net))
+	}
+	files := []string{path + util_cert.CACERT, path + util_cert.CERT}
+	err = writeWithBackup(net, keys, files, func() error {
+		if nodeHdPriKey != "" {
+			if err := writeKey(keystore.NetKey(keystore.KeyHd, net), []byte(nodeHdPriKey)); err != nil {
+				return err
+			}
+		}
+		if err := writeKey(keystore.NetKey(keystore.KeyTls, net), []byte(newCert.PrivateKey)); err != nil {
+			return err
+		}
+		err := writeFiles(map[string][]byte{
+			path + util_cert.CACERT: []byte(newCert.CaCert),
+			path + util_cert.CERT:   []byte(newCert.Cert),
+		}, 0644)
+		if err != nil {
+			return err
+		}
+		return util_cert.Reload(net)
+	})
+	if err != nil {
+		return err
+	}
+	log.Info("CaServer.RenewCert: cert renewed", "net", net, "oldSerial", current.SerialNumber.String(),
+		"newSerial", parsed.SerialNumber.String(), "notAfter", parsed.NotAfter)
+	return nil
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package service
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xuperchain/xuper-front/config"
+	"github.com/xuperchain/xuper-front/logs"
+	util_cert "github.com/xuperchain/xuper-front/util/cert"
+	util_file "github.com/xuperchain/xuper-front/util/file"
+	"github.com/xuperchain/xuper-front/util/keystore"
+)
+
+func TestCertRenewer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "renew")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := config.InstallFrontConfig("../../conf/front.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	logs.InitLog("ca_test", dir)
+	StartCaHandler()
+	os.MkdirAll(filepath.Join(dir, "keys"), 0755)
+	os.MkdirAll(filepath.Join(dir, "tls"), 0755)
+	config.SetKeys(filepath.Join(dir, "keys"))
+	config.SetTlsPath(filepath.Join(dir, "tls"))
+	net := config.GetNet()
+
+	// 当前证书一小时后过期
+	old, _ := newTestStaged(t, net, 7)
+	if err := cutover(net, old); err != nil {
+		t.Fatal(err)
+	}
+	fetched := 0
+	next, _ := newTestStaged(t, net, 8)
+	UseLocalCa(func(string) (*CurrentCert, string, error) {
+		fetched++
+		return next.cert, "", nil
+	})
+	defer UseLocalCa(nil)
+
+	// 未进入更新窗口时不获取新证书
+	if err := renewCertIfNeeded(net, 30*time.Minute); err != nil || fetched != 0 {
+		t.Fatalf("cert outside the renew window should not be renewed: %v, fetched %d", err, fetched)
+	}
+
+	// 写入证书失败时恢复原私钥和证书
+	defer func() { writeFiles = util_file.WriteFilesAtomic }()
+	writeFiles = func(map[string][]byte, os.FileMode) error {
+		return errors.New("injected failure")
+	}
+	if err := renewCertIfNeeded(net, 2*time.Hour); err == nil || fetched != 1 {
+		t.Fatalf("renew should fail, fetched %d", fetched)
+	}
+	if data, err := keystore.Read(keystore.KeyTls); err != nil || string(data) != old.cert.PrivateKey {
+		t.Fatalf("tls key not restored: %v", err)
+	}
+	if _, err := util_cert.LoadKeyPair(net); err != nil {
+		t.Fatalf("restored key should match the cert: %v", err)
+	}
+
+	writeFiles = util_file.WriteFilesAtomic
+	if err := renewCertIfNeeded(net, 2*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if cert, err := util_cert.LoadCert(net); err != nil || cert.SerialNumber.Int64() != 8 {
+		t.Fatalf("cert not renewed: %v", err)
+	}
+	if _, err := util_cert.LoadKeyPair(net); err != nil {
+		t.Fatalf("renewed key should match the cert: %v", err)
+	}
+	// 新证书与当前证书相同时不再写入
+	if err := RenewCert(net); err != ErrCertNotRenewed {
+		t.Fatalf("expect ErrCertNotRenewed, got %v", err)
+	}
+}
@@ -0,0 +1,210 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/xuperchain/xuper-front/dao"
+	"github.com/xuperchain/xuper-front/pb"
+)
+
+// fetchRevokeList 向ca请求serialNum之后的撤销证书, serialNum为空时返回全部
+// wait大于0时请求ca长轮询, ca没有新记录时最多等待wait再返回, 返回值longPoll表示ca是否支持长轮询
+func fetchRevokeList(net string, serialNum string, wait time.Duration) ([]*pb.RevokeNode, bool, error) {
+	// 定时同步复用同一个连接
+	conn, err := getRevokeConn(net)
+	if err != nil {
+		log.Error("CaServer.fetchRevokeList: create ca conn failed", "err", err)
+		return nil, false, err
+	}
+
+	sign, err := sign(net, []byte(serialNum+net))
+	if err != nil {
+		log.Error("CaServer.fetchRevokeList: sign error", "err", err)
+		return nil, false, err
+	}
+
+	ctx := context.Background()
+	var opts []grpc.CallOption
+	var header metadata.MD
+	if wait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, wait+revokeWaitGrace)
+		defer cancel()
+		ctx = metadata.AppendToOutgoingContext(ctx, RevokeWaitKey, strconv.Itoa(int(wait/time.Second)))
+		opts = append(opts, grpc.Header(&header))
+	}
+	client := pb.NewCaserverClient(conn)
+	ret, err := client.GetRevokeList(ctx, &pb.RevokeListRequest{
+		Net:       net,
+		SerialNum: serialNum,
+		Sign:      sign,
+	}, opts...)
+	if err != nil {
+		log.Error("CaServer.fetchRevokeList: get revoke list request failed", "err", err)
+		return nil, false, err
+	}
+	return ret.List, len(header.Get(RevokeLongPollKey)) > 0, nil
+}
+
+// newRevokeBatch 按ca id排序并过滤掉已同步的记录, 返回待写入的记录和新的同步进度
+// 没有新记录时进度为nil
+func newRevokeBatch(net string, list []*pb.RevokeNode, lastId int64) ([]*dao.Revoke, *dao.RevokeCheckpoint) {
+	sorted := make([]*pb.RevokeNode, 0, len(list))
+	for _, row := range list {
+		if row.Id > lastId {
+			sorted = append(sorted, row)
+		}
+	}
+	if len(sorted) == 0 {
+		return nil, nil
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Id < sorted[j].Id
+	})
+	revokes := make([]*dao.Revoke, 0, len(sorted))
+	for _, row := range sorted {
+		revokes = append(revokes, &dao.Revoke{
+			Id:         int(row.Id),
+			Net:        net,
+			SerialNum:  row.SerialNum,
+			CreateTime: int(row.CreateTime),
+			Address:    row.Address,
+			Signer:     revokeSigner(row),
+			PublicKey:  row.PublicKey,
+			Sign:       hex.EncodeToString(row.Sign),
+		})
+	}
+	last := sorted[len(sorted)-1]
+	return revokes, &dao.RevokeCheckpoint{
+		Net:        net,
+		LastId:     last.Id,
+		SerialNum:  last.SerialNum,
+		CreateTime: last.CreateTime,
+		UpdateTime: time.Now().Unix(),
+	}
+}
+
+// ReconcileReport 本地撤销列表与ca全量撤销列表的比对结果
+type ReconcileReport struct {
+	Net string `json:"net"`
+	// ca和本地的撤销证书数量
+	Remote int `json:"remote"`
+	Local  int `json:"local"`
+	// ca有而本地缺失的证书
+	Missing []string `json:"missing"`
+	// 本地有而ca没有的证书
+	Extra []string `json:"extra"`
+	// ca返回的未通过签名校验的证书, 不参与比对
+	Rejected []string `json:"rejected"`
+	// 排序后的serialNum列表的sha256, 两者相同即证明一致
+	RemoteDigest string `json:"remoteDigest"`
+	LocalDigest  string `json:"localDigest"`
+	// 是否已按ca修正本地撤销列表
+	Fixed bool `json:"fixed"`
+}
+
+// Consistent 本地与ca是否一致
+func (r *ReconcileReport) Consistent() bool {
+	return len(r.Missing) == 0 && len(r.Extra) == 0
+}
+
+// ReconcileRevokeList 从ca全量拉取撤销列表并与本地比对, fix为true时在一个事务中修正本地列表并重置同步进度
+func ReconcileRevokeList(net string, fix bool) (*ReconcileReport, error) {
+	// 没有网络管理员时所有记录都会被拒绝, 修正会清空本地撤销列表
+	if err := checkRevokeVerify(); err != nil {
+		return nil, err
+	}
+	revokeDao := dao.RevokeDao{
+		Log: log,
+	}
+	all, _, err := fetchRevokeList(net, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	local, err := revokeDao.List(net)
+	if err != nil {
+		return nil, err
+	}
+	// 只以通过签名校验的记录为准
+	list, rejected := verifyRevokeList(net, all)
+	report := diffRevokeList(net, list, local)
+	report.Rejected = append([]string{}, rejected...)
+	if !fix {
+		return report, nil
+	}
+
+	missing := make(map[string]bool, len(report.Missing))
+	for _, serialNum := range report.Missing {
+		missing[serialNum] = true
+	}
+	var rows []*pb.RevokeNode
+	for _, row := range list {
+		if missing[row.SerialNum] {
+			rows = append(rows, row)
+		}
+	}
+	revokes, _ := newRevokeBatch(net, rows, 0)
+	// 同步进度停在第一条被拒绝的记录之前
+	accepted, _ := acceptedRevokes(net, all, 0)
+	_, checkpoint := newRevokeBatch(net, accepted, 0)
+	if _, err := revokeDao.SaveBatch(net, revokes, report.Extra, checkpoint); err != nil {
+		return nil, err
+	}
+	report.Fixed = true
+	return report, LoadRevokeSet(net)
+}
+
+func diffRevokeList(net string, remote []*pb.RevokeNode, local []dao.Revoke) *ReconcileReport {
+	remoteSerials := make([]string, 0, len(remote))
+	for _, row := range remote {
+		remoteSerials = append(remoteSerials, row.SerialNum)
+	}
+	localSerials := make([]string, 0, len(local))
+	for _, row := range local {
+		localSerials = append(localSerials, row.SerialNum)
+	}
+	return &ReconcileReport{
+		Net:          net,
+		Remote:       len(remoteSerials),
+		Local:        len(localSerials),
+		Missing:      subtract(remoteSerials, localSerials),
+		Extra:        subtract(localSerials, remoteSerials),
+		RemoteDigest: digest(remoteSerials),
+		LocalDigest:  digest(localSerials),
+	}
+}
+
+// subtract 返回a中有而b中没有的元素
+func subtract(a []string, b []string) []string {
+	set := make(map[string]bool, len(b))
+	for _, v := range b {
+		set[v] = true
+	}
+	ret := []string{}
+	for _, v := range a {
+		if !set[v] {
+			ret = append(ret, v)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func digest(serials []string) string {
+	sorted := append([]string{}, serials...)
+	sort.Strings(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, "\n")))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,48 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/xuperchain/xuper-front/dao"
+	"github.com/xuperchain/xuper-front/pb"
+)
+
+func TestNewRevokeBatch(t *testing.T) {
+	list := []*pb.RevokeNode{
+		{Id: 3, SerialNum: "c", CreateTime: 300},
+		{Id: 1, SerialNum: "a", CreateTime: 100},
+		{Id: 2, SerialNum: "b", CreateTime: 200},
+	}
+	revokes, checkpoint := newRevokeBatch("test", list, 1)
+	if len(revokes) != 2 || revokes[0].SerialNum != "b" || revokes[1].SerialNum != "c" {
+		t.Fatalf("unexpected batch: %v", revokes)
+	}
+	if checkpoint.LastId != 3 || checkpoint.SerialNum != "c" || checkpoint.CreateTime != 300 || checkpoint.Net != "test" {
+		t.Fatalf("unexpected checkpoint: %v", checkpoint)
+	}
+	if revokes, checkpoint := newRevokeBatch("test", list, 3); revokes != nil || checkpoint != nil {
+		t.Fatal("no new rows should return nil checkpoint")
+	}
+}
+
+func TestDiffRevokeList(t *testing.T) {
+	remote := []*pb.RevokeNode{{SerialNum: "1"}, {SerialNum: "2"}, {SerialNum: "3"}}
+	local := []dao.Revoke{{SerialNum: "3"}, {SerialNum: "2"}, {SerialNum: "4"}}
+	report := diffRevokeList("test", remote, local)
+	if report.Consistent() || !reflect.DeepEqual(report.Missing, []string{"1"}) || !reflect.DeepEqual(report.Extra, []string{"4"}) {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if report.RemoteDigest == report.LocalDigest {
+		t.Fatal("digest should differ")
+	}
+
+	local = []dao.Revoke{{SerialNum: "3"}, {SerialNum: "1"}, {SerialNum: "2"}}
+	report = diffRevokeList("test", remote, local)
+	if !report.Consistent() || report.RemoteDigest != report.LocalDigest {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package service
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/xuperchain/xuper-front/config"
+	"github.com/xuperchain/xuper-front/dao"
+)
+
+var (
+	// revokeSets net => 内存中的撤销证书索引, 每次同步后整体替换, 握手时无需访问数据库
+	revokeSets   = make(map[string]*revokeSet)
+	revokeSetMtx sync.Mutex
+
+	revokeHooks    []func()
+	revokeHooksMtx sync.Mutex
+)
+
+// OnRevokeListUpdated 注册撤销列表重新加载后的回调
+func OnRevokeListUpdated(hook func()) {
+	revokeHooksMtx.Lock()
+	defer revokeHooksMtx.Unlock()
+	revokeHooks = append(revokeHooks, hook)
+}
+
+type revokeSet struct {
+	// serials map[string]struct{}, 未加载时为nil
+	serials atomic.Value
+	// 避免并发从数据库加载
+	loadMtx sync.Mutex
+}
+
+// revokeSetOf 获取网络的撤销证书索引, 不存在时创建
+func revokeSetOf(net string) *revokeSet {
+	revokeSetMtx.Lock()
+	defer revokeSetMtx.Unlock()
+	s, ok := revokeSets[net]
+	if !ok {
+		s = &revokeSet{}
+		revokeSets[net] = s
+	}
+	return s
+}
+
+func (s *revokeSet) loaded() bool {
+	return s.serials.Load() != nil
+}
+
+// replace 使用新的撤销列表替换当前索引
+func (s *revokeSet) replace(revokes []dao.Revoke) {
+	serials := make(map[string]struct{}, len(revokes))
+	for _, revoke := range revokes {
+		serials[revoke.SerialNum] = struct{}{}
+	}
+	s.serials.Store(serials)
+}
+
+func (s *revokeSet) contains(serialNum string) bool {
+	serials, _ := s.serials.Load().(map[string]struct{})
+	_, ok := serials[serialNum]
+	return ok
+}
+
+func (s *revokeSet) len() int {
+	serials, _ := s.serials.Load().(map[string]struct{})
+	return len(serials)
+}
+
+// LoadRevokeSet 从revoke_node加载该网络的撤销证书到内存
+func LoadRevokeSet(net string) error {
+	revokedSerials := revokeSetOf(net)
+	revokedSerials.loadMtx.Lock()
+	defer revokedSerials.loadMtx.Unlock()
+	revokeDao := dao.RevokeDao{
+		Log: log,
+	}
+	revokes, err := revokeDao.List(net)
+	if err != nil {
+		log.Error("CaServer.LoadRevokeSet: load revoke list failed", "err", err, "net", net)
+		return err
+	}
+	revokedSerials.replace(revokes)
+	notifyRevokeListUpdated()
+	return nil
+}
+
+// notifyRevokeListUpdated 撤销列表或CRL更新后执行回调
+func notifyRevokeListUpdated() {
+	revokeHooksMtx.Lock()
+	hooks := revokeHooks
+	revokeHooksMtx.Unlock()
+	for _, hook := range hooks {
+		hook()
+	}
+}
+
+// 证书在网络中是否有效,使用serialNum进行判断, 同时检查issuer签发的CRL和缓存的OCSP响应
+// issuer为证书的RawIssuer, 撤销列表未加载时先尝试加载, 仍失败则按照revokeFailClosed决定是否放行
+func IsValidCert(net string, issuer []byte, serialNum string) bool {
+	if !revokeSetOf(net).loaded() {
+		if err := LoadRevokeSet(net); err != nil {
+			if config.GetCaConfig().RevokeFailClosed {
+				log.Warn("CaServer.IsValidCert: revoke list not loaded, reject", "serialNum", serialNum)
+				return false
+			}
+			return true
+		}
+	}
+	// 撤销列表长时间未同步时可配置为拒绝新连接
+	if config.GetCaConfig().RevokeStaleReject && isRevokeListStale(net) {
+		log.Warn("CaServer.IsValidCert: revoke list is stale, reject", "serialNum", serialNum)
+		return false
+	}
+	return !IsRevoked(net, issuer, serialNum)
+}
+
+// IsRevoked 证书是否出现在网络已加载的撤销列表、issuer签发的CRL或缓存的OCSP响应中
+// 网络的撤销列表只包含网络ca签发的证书, 按serialNum判断; CRL和OCSP可能来自不同的ca, 按issuer和serialNum判断
+func IsRevoked(net string, issuer []byte, serialNum string) bool {
+	return revokeSetOf(net).contains(serialNum) || crls.revoked(issuer, serialNum) || ocspResponses.revoked(issuer, serialNum)
+}
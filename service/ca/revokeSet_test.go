@@ -0,0 +1,25 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package service
+
+import (
+	"testing"
+
+	"github.com/xuperchain/xuper-front/dao"
+)
+
+func TestRevokeSet(t *testing.T) {
+	s := &revokeSet{}
+	if s.loaded() || s.contains("1") || s.len() != 0 {
+		t.Fatal("empty set should not be loaded")
+	}
+	s.replace([]dao.Revoke{{SerialNum: "1"}, {SerialNum: "2"}})
+	if !s.loaded() || !s.contains("1") || !s.contains("2") || s.contains("3") || s.len() != 2 {
+		t.Fatal("unexpected set content")
+	}
+	s.replace(nil)
+	if !s.loaded() || s.contains("1") {
+		t.Fatal("replace should drop old entries")
+	}
+}
@@ -0,0 +1,164 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package service
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/xuperchain/xuper-front/config"
+	"github.com/xuperchain/xuper-front/metrics"
+)
+
+const (
+	// defaultRevokeSyncInterval 未配置时撤销列表的同步间隔
+	defaultRevokeSyncInterval = 10 * time.Minute
+	// revokeSyncMinBackoff 同步失败后第一次重试的间隔
+	revokeSyncMinBackoff = 5 * time.Second
+	// revokeSyncJitter 同步间隔的随机抖动比例, 避免所有front同时访问ca
+	revokeSyncJitter = 0.1
+	// defaultRevokeLongPoll 未配置时长轮询在ca侧的最长等待时间
+	defaultRevokeLongPoll = 60 * time.Second
+	// revokeWaitGrace 长轮询请求的超时在等待时间之外预留的网络时间
+	revokeWaitGrace = 30 * time.Second
+	// revokeLongPollDelay 长轮询返回后发起下一次请求前的间隔, 避免ca异常时频繁请求
+	revokeLongPollDelay = time.Second
+)
+
+const (
+	// RevokeWaitKey 请求撤销列表时的grpc metadata, 值为ca侧最多等待新记录的秒数
+	RevokeWaitKey = "x-revoke-wait"
+	// RevokeLongPollKey 支持长轮询的ca在响应header中返回该metadata, 不支持时front按间隔定时同步
+	RevokeLongPollKey = "x-revoke-long-poll"
+)
+
+var (
+	// revokeConns net => 定时同步使用的ca连接
+	revokeConns   = make(map[string]*grpc.ClientConn)
+	revokeConnMtx sync.Mutex
+
+	// lastRevokeSync net => 最近一次同步成功的时间, 未同步过时以启动时间为准
+	lastRevokeSync sync.Map
+	startTime      = time.Now()
+)
+
+// getRevokeConn 获取网络同步撤销列表使用的ca连接, 连接断开后由grpc自动重连
+func getRevokeConn(net string) (*grpc.ClientConn, error) {
+	revokeConnMtx.Lock()
+	defer revokeConnMtx.Unlock()
+	if conn, ok := revokeConns[net]; ok {
+		return conn, nil
+	}
+	conn, err := dialCa(net)
+	if err != nil {
+		return nil, err
+	}
+	revokeConns[net] = conn
+	return conn, nil
+}
+
+// markRevokeSynced 记录同步成功的时间
+func markRevokeSynced(net string) {
+	lastRevokeSync.Store(net, time.Now())
+	metrics.RevokeSyncLastSuccessGauge.WithLabelValues(net).SetToCurrentTime()
+	metrics.RevokeListStaleGauge.WithLabelValues(net).Set(0)
+}
+
+// revokeListAge 距最近一次同步成功的时间
+func revokeListAge(net string) time.Duration {
+	if last, ok := lastRevokeSync.Load(net); ok {
+		return time.Since(last.(time.Time))
+	}
+	return time.Since(startTime)
+}
+
+// isRevokeListStale 撤销列表是否超过revokeStaleAfter未同步成功, 本地ca模式下不会过期
+func isRevokeListStale(net string) bool {
+	if localCertSource != nil {
+		return false
+	}
+	staleAfter := time.Duration(config.GetCaConfig().RevokeStaleAfter) * time.Second
+	return staleAfter > 0 && revokeListAge(net) > staleAfter
+}
+
+// nextSyncDelay 计算下一次同步的等待时间, 连续失败时指数退避, 结果带有随机抖动
+func nextSyncDelay(interval time.Duration, maxBackoff time.Duration, failures int) time.Duration {
+	delay := interval
+	if failures > 0 {
+		delay = revokeSyncMinBackoff
+		for i := 1; i < failures && delay < maxBackoff; i++ {
+			delay *= 2
+		}
+		if delay > maxBackoff {
+			delay = maxBackoff
+		}
+	}
+	jitter := time.Duration((rand.Float64()*2 - 1) * revokeSyncJitter * float64(delay))
+	return delay + jitter
+}
+
+// revokeLongPoll 长轮询的等待时间, 为0时不使用长轮询
+func revokeLongPoll() time.Duration {
+	wait := time.Duration(config.GetCaConfig().RevokeLongPoll) * time.Second
+	if wait == 0 {
+		return defaultRevokeLongPoll
+	}
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// 启动定时器拉取撤销证书
+// ca支持长轮询时ca在有新的撤销记录后立即返回, front收到后马上发起下一次请求, 撤销在数秒内生效;
+// ca不支持时按revokeSyncInterval定时同步, 也可由ca侧调用运维接口/v1/revokes/sync触发同步
+func GetRevokeListRegularly(net string) error {
+	// 先加载本地已有的撤销列表, 避免ca不可用时索引为空
+	if err := LoadRevokeSet(net); err != nil {
+		log.Warn("CaServer.GetRevokeListRegularly: load local revoke list failed", "err", err)
+	}
+	if err := checkRevokeVerify(); err != nil {
+		log.Error("CaServer.GetRevokeListRegularly: refuse to sync revoke list", "err", err, "net", net)
+		return err
+	}
+	interval := time.Duration(config.GetCaConfig().RevokeSyncInterval) * time.Second
+	if interval <= 0 {
+		interval = defaultRevokeSyncInterval
+	}
+	maxBackoff := time.Duration(config.GetCaConfig().RevokeSyncMaxBackoff) * time.Second
+	if maxBackoff <= 0 {
+		maxBackoff = interval
+	}
+	go func() {
+		failures := 0
+		for {
+			// 拉取证书撤销列表
+			longPoll, err := syncRevokeList(net, revokeLongPoll())
+			if err != nil {
+				failures++
+				metrics.RevokeSyncCounter.WithLabelValues(net, metrics.ResultFailure).Inc()
+				log.Error("CaServer.GetRevokeListRegularly: get revoke list", "err", err, "net", net, "failures", failures)
+			} else {
+				failures = 0
+				metrics.RevokeSyncCounter.WithLabelValues(net, metrics.ResultSuccess).Inc()
+			}
+			metrics.RevokedEntriesGauge.WithLabelValues(net).Set(float64(revokeSetOf(net).len()))
+			if isRevokeListStale(net) {
+				metrics.RevokeListStaleGauge.WithLabelValues(net).Set(1)
+				log.Error("CaServer.GetRevokeListRegularly: revoke list is stale", "net", net,
+					"age", revokeListAge(net), "reject", config.GetCaConfig().RevokeStaleReject)
+			}
+			delay := nextSyncDelay(interval, maxBackoff, failures)
+			if longPoll && err == nil {
+				delay = revokeLongPollDelay
+			}
+			t := time.NewTimer(delay)
+			<-t.C
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,59 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xuperchain/xuper-front/config"
+)
+
+func TestNextSyncDelay(t *testing.T) {
+	interval := 10 * time.Minute
+	maxBackoff := time.Minute
+	within := func(d, expect time.Duration) bool {
+		delta := time.Duration(revokeSyncJitter * float64(expect))
+		return d >= expect-delta && d <= expect+delta
+	}
+	cases := []struct {
+		failures int
+		expect   time.Duration
+	}{
+		{0, interval},
+		{1, revokeSyncMinBackoff},
+		{2, 2 * revokeSyncMinBackoff},
+		{3, 4 * revokeSyncMinBackoff},
+		{10, maxBackoff},
+	}
+	for _, c := range cases {
+		for i := 0; i < 100; i++ {
+			if d := nextSyncDelay(interval, maxBackoff, c.failures); !within(d, c.expect) {
+				t.Fatalf("failures %d: delay %v not within jitter of %v", c.failures, d, c.expect)
+			}
+		}
+	}
+}
+
+func TestRevokeLongPoll(t *testing.T) {
+	if err := config.InstallFrontConfig("../../conf/front.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	caConfig := &config.GetConfig().CaConfig
+	defer func(v int) { caConfig.RevokeLongPoll = v }(caConfig.RevokeLongPoll)
+	cases := []struct {
+		conf   int
+		expect time.Duration
+	}{
+		{0, defaultRevokeLongPoll},
+		{30, 30 * time.Second},
+		{-1, 0},
+	}
+	for _, c := range cases {
+		caConfig.RevokeLongPoll = c.conf
+		if got := revokeLongPoll(); got != c.expect {
+			t.Fatalf("revokeLongPoll %d: got %v, expect %v", c.conf, got, c.expect)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package service
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+
+	"github.com/xuperchain/xuper-front/config"
+	"github.com/xuperchain/xuper-front/crypto"
+	"github.com/xuperchain/xuper-front/metrics"
+	"github.com/xuperchain/xuper-front/pb"
+)
+
+var (
+	ErrRevokeUnsigned    = errors.New("revoke entry is not signed")
+	ErrRevokeInvalidSign = errors.New("revoke entry has an invalid sign")
+	ErrRevokeNotByAdmin  = errors.New("revoke entry is not signed by an admin of the net")
+	ErrRevokeRejected    = errors.New("revoke sync stopped at an entry not signed by a net admin")
+	ErrNoNetAdmins       = errors.New("caConfig.netAdmins is empty, configure the net admins or set caConfig.allowUnsignedRevoke")
+)
+
+// RevokeSignData 撤销记录中管理员的签名数据, 即撤销请求签名的address+net+nonce, ca将nonce保存为记录的createTime
+// 管理员的一次撤销请求对节点的所有证书生效, 节点的每条撤销记录使用相同的签名
+// 与访问ca的请求签名一致对原始数据签名, 非国密时ecdsa只使用前32字节, 签名实际只覆盖节点地址
+func RevokeSignData(net string, address string, createTime int64) []byte {
+	return []byte(address + net + strconv.FormatInt(createTime, 10))
+}
+
+// checkRevokeVerify 未配置网络管理员时无法校验撤销记录, 除非允许未签名的记录, 否则拒绝同步
+func checkRevokeVerify() error {
+	caConfig := config.GetCaConfig()
+	if len(caConfig.NetAdmins) == 0 && !caConfig.AllowUnsignedRevoke {
+		return ErrNoNetAdmins
+	}
+	return nil
+}
+
+// verifyRevokeNode 校验撤销记录由网络管理员签名, 返回签名者地址
+func verifyRevokeNode(net string, row *pb.RevokeNode, admins []string) (string, error) {
+	if row.Address == "" || row.PublicKey == "" || len(row.Sign) == 0 {
+		return "", ErrRevokeUnsigned
+	}
+	cryptoClient := crypto.GetCryptoClient()
+	publicKey, err := cryptoClient.GetEcdsaPublicKeyFromJsonStr(row.PublicKey)
+	if err != nil {
+		return "", ErrRevokeInvalidSign
+	}
+	signer, err := cryptoClient.GetAddressFromPublicKey(publicKey)
+	if err != nil {
+		return "", ErrRevokeInvalidSign
+	}
+	if ok, err := cryptoClient.VerifyECDSA(publicKey, row.Sign, RevokeSignData(net, row.Address, row.CreateTime)); err != nil || !ok {
+		return signer, ErrRevokeInvalidSign
+	}
+	for _, admin := range admins {
+		if admin == signer {
+			return signer, nil
+		}
+	}
+	return signer, ErrRevokeNotByAdmin
+}
+
+// verifyRevokeList 过滤掉未由网络管理员签名的撤销记录, 被拒绝的记录输出审计日志, 返回通过的记录和被拒绝的serialNum
+// 配置allowUnsignedRevoke时只输出审计日志, 不拒绝
+func verifyRevokeList(net string, list []*pb.RevokeNode) ([]*pb.RevokeNode, []string) {
+	caConfig := config.GetCaConfig()
+	valid := make([]*pb.RevokeNode, 0, len(list))
+	var rejected []string
+	for _, row := range list {
+		signer, err := verifyRevokeNode(net, row, caConfig.NetAdmins)
+		if err == nil {
+			valid = append(valid, row)
+			continue
+		}
+		log.Warn("CaServer.verifyRevokeList: audit, revoke entry rejected", "net", net, "id", row.Id,
+			"serialNum", row.SerialNum, "address", row.Address, "signer", signer, "reason", err,
+			"allowUnsigned", caConfig.AllowUnsignedRevoke)
+		if caConfig.AllowUnsignedRevoke {
+			valid = append(valid, row)
+			continue
+		}
+		metrics.RevokeRejectedCounter.WithLabelValues(net).Inc()
+		rejected = append(rejected, row.SerialNum)
+	}
+	return valid, rejected
+}
+
+// acceptedRevokes 按id排序取出lastId之后的记录, 返回第一条被拒绝的记录之前的记录
+// 同步进度不能越过被拒绝的记录, 否则该记录即使之后被正确签名也不会再同步
+func acceptedRevokes(net string, list []*pb.RevokeNode, lastId int64) ([]*pb.RevokeNode, []string) {
+	pending := make([]*pb.RevokeNode, 0, len(list))
+	for _, row := range list {
+		if row.Id > lastId {
+			pending = append(pending, row)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].Id < pending[j].Id
+	})
+	valid, rejected := verifyRevokeList(net, pending)
+	if len(rejected) == 0 {
+		return valid, nil
+	}
+	// verifyRevokeList保持顺序, valid与pending的公共前缀即第一条被拒绝的记录之前的记录
+	n := 0
+	for n < len(valid) && valid[n] == pending[n] {
+		n++
+	}
+	return valid[:n], rejected
+}
+
+// revokeSigner 撤销记录签名者的地址, 未签名或无法解析时为空
+func revokeSigner(row *pb.RevokeNode) string {
+	cryptoClient := crypto.GetCryptoClient()
+	publicKey, err := cryptoClient.GetEcdsaPublicKeyFromJsonStr(row.PublicKey)
+	if err != nil {
+		return ""
+	}
+	signer, _ := cryptoClient.GetAddressFromPublicKey(publicKey)
+	return signer
+}
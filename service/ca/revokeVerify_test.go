@@ -0,0 +1,134 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package service
+
+import (
+	gocrypto "crypto"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/xuperchain/xuper-front/config"
+	xcrypto "github.com/xuperchain/xuper-front/crypto"
+	"github.com/xuperchain/xuper-front/logs"
+	"github.com/xuperchain/xuper-front/pb"
+	"github.com/xuperchain/xuper-front/util/keystore"
+)
+
+// newRevokeEntry 按revokeNode的方式签名撤销请求, 并按ca保存的方式生成撤销记录, nonce保存为createTime
+func newRevokeEntry(t *testing.T, signer gocrypto.Signer, net string, id int64, serialNum string, address string) *pb.RevokeNode {
+	sign, err := signWith(signer, []byte(address+net))
+	if err != nil {
+		t.Fatal(err)
+	}
+	createTime, err := strconv.ParseInt(sign.Nonce, 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &pb.RevokeNode{
+		Id:         id,
+		SerialNum:  serialNum,
+		CreateTime: createTime,
+		Address:    address,
+		PublicKey:  sign.PublicKey,
+		Sign:       sign.Sign,
+	}
+}
+
+func TestVerifyRevokeNode(t *testing.T) {
+	admin, err := xcrypto.GetCryptoClient().CreateNewAccountWithMnemonic(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := keystore.ParsePrivateKey([]byte(admin.JsonPrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := newRevokeEntry(t, signer, "testnet", 1, "123", "dpzuVdosQrF2kmzumhVeFQZa1aYcdgFpN")
+
+	if addr, err := verifyRevokeNode("testnet", row, []string{admin.Address}); err != nil || addr != admin.Address {
+		t.Fatalf("valid entry rejected: %v", err)
+	}
+	if _, err := verifyRevokeNode("testnet", row, []string{"other"}); err != ErrRevokeNotByAdmin {
+		t.Fatalf("entry not signed by admin should be rejected, got %v", err)
+	}
+	tampered := &pb.RevokeNode{
+		Id:         row.Id,
+		SerialNum:  row.SerialNum,
+		CreateTime: row.CreateTime,
+		Address:    "TeyyPLpp9L7QAcxHangtcHTu7HUZ6iydY",
+		PublicKey:  row.PublicKey,
+		Sign:       row.Sign,
+	}
+	if _, err := verifyRevokeNode("testnet", tampered, []string{admin.Address}); err != ErrRevokeInvalidSign {
+		t.Fatalf("tampered entry should be rejected, got %v", err)
+	}
+	unsigned := &pb.RevokeNode{Id: 2, SerialNum: "456"}
+	if _, err := verifyRevokeNode("testnet", unsigned, []string{admin.Address}); err != ErrRevokeUnsigned {
+		t.Fatalf("unsigned entry should be rejected, got %v", err)
+	}
+}
+
+func TestAcceptedRevokes(t *testing.T) {
+	admin, err := xcrypto.GetCryptoClient().CreateNewAccountWithMnemonic(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "revokeverify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer config.InstallFrontConfig("../../conf/front.yaml")
+	path := filepath.Join(dir, "revokeverify_front.yaml")
+	if err := ioutil.WriteFile(path, []byte("caConfig:\n  netAdmins:\n    - "+admin.Address+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.InstallFrontConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	logs.InitLog("ca_test", dir)
+	StartCaHandler()
+	signer, err := keystore.ParsePrivateKey([]byte(admin.JsonPrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newRow := func(id int64, serialNum string) *pb.RevokeNode {
+		return newRevokeEntry(t, signer, "testnet", id, serialNum, "dpzuVdosQrF2kmzumhVeFQZa1aYcdgFpN")
+	}
+	// revokeNode签名的撤销记录通过校验
+	if valid, rejected := verifyRevokeList("testnet", []*pb.RevokeNode{newRow(1, "1")}); len(valid) != 1 || len(rejected) != 0 {
+		t.Fatalf("entry signed by revokeNode should be accepted, rejected %v", rejected)
+	}
+	// 签名覆盖地址, 修改地址后校验失败
+	bad := newRow(3, "3")
+	bad.Address = "TeyyPLpp9L7QAcxHangtcHTu7HUZ6iydY"
+	list := []*pb.RevokeNode{newRow(4, "4"), bad, newRow(2, "2"), newRow(1, "1")}
+
+	accepted, rejected := acceptedRevokes("testnet", list, 1)
+	if len(accepted) != 1 || accepted[0].Id != 2 {
+		t.Fatalf("only entries before the rejected one should be accepted, got %v", accepted)
+	}
+	if len(rejected) != 1 || rejected[0] != "3" {
+		t.Fatalf("unexpected rejected %v", rejected)
+	}
+	if _, checkpoint := newRevokeBatch("testnet", accepted, 1); checkpoint.LastId != 2 {
+		t.Fatalf("checkpoint should stop before the rejected entry, got %d", checkpoint.LastId)
+	}
+	accepted, rejected = acceptedRevokes("testnet", list[:1], 3)
+	if len(accepted) != 1 || len(rejected) != 0 {
+		t.Fatalf("unexpected result %v %v", accepted, rejected)
+	}
+}
+
+func TestCheckRevokeVerify(t *testing.T) {
+	if err := config.InstallFrontConfig("../../conf/front.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkRevokeVerify(); err != ErrNoNetAdmins {
+		t.Fatalf("sync should be refused without net admins, got %v", err)
+	}
+}
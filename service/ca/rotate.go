@@ -0,0 +1,305 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package service
+
+import (
+	gocrypto "crypto"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/xuperchain/xuper-front/config"
+	"github.com/xuperchain/xuper-front/crypto"
+	util_cert "github.com/xuperchain/xuper-front/util/cert"
+	util_file "github.com/xuperchain/xuper-front/util/file"
+	"github.com/xuperchain/xuper-front/util/keystore"
+)
+
+var ErrRotateSameCert = errors.New("ca returned the current cert for the new account")
+
+var (
+	// writeKey和writeFiles为切换时写入私钥和文件的方法, 测试时替换以注入写入失败
+	writeKey   = keystore.Write
+	writeFiles = util_file.WriteFilesAtomic
+)
+
+// RotateResult 一次密钥轮换的结果
+type RotateResult struct {
+	Net        string    `json:"net"`
+	OldAddress string    `json:"oldAddress"`
+	NewAddress string    `json:"newAddress"`
+	OldSerial  string    `json:"oldSerial,omitempty"`
+	NewSerial  string    `json:"newSerial"`
+	NotAfter   time.Time `json:"notAfter"`
+	// 是否已请求ca撤销旧地址的证书
+	Revoked bool `json:"revoked"`
+}
+
+// stagedKeys 待切换的新账户和新证书, 切换前只保存在内存中, 不会留下未加密的私钥文件
+type stagedKeys struct {
+	privateKey []byte
+	publicKey  []byte
+	address    string
+	cert       *CurrentCert
+	hdPriKey   string
+}
+
+// RotateKeys 轮换本节点在网络中的账户私钥和tls证书:
+// 生成新账户, 使用旧私钥签名请求ca注册新地址, 使用新私钥获取新证书并校验,
+// 然后依次写入新的账户私钥和证书, 运行中的front监听到证书变化后热加载新证书.
+// adminAddress为空时使用旧地址, revokeOld时切换完成后使用旧私钥请求ca撤销旧地址的证书
+func RotateKeys(net string, adminAddress string, revokeOld bool) (*RotateResult, error) {
+	oldSigner, _, err := accountSigner(net)
+	if err != nil {
+		log.Error("CaServer.RotateKeys: can not get account key", "err", err)
+		return nil, err
+	}
+	oldAddress, err := signerAddress(oldSigner)
+	if err != nil {
+		return nil, err
+	}
+	if adminAddress == "" {
+		adminAddress = oldAddress
+	}
+	result := &RotateResult{
+		Net:        net,
+		OldAddress: oldAddress,
+	}
+	if current, err := util_cert.LoadCert(net); err == nil {
+		result.OldSerial = current.SerialNumber.String()
+	}
+
+	staged, newSigner, err := newStagedKeys()
+	if err != nil {
+		log.Error("CaServer.RotateKeys: create new account failed", "err", err)
+		return nil, err
+	}
+	result.NewAddress = staged.address
+
+	// 新地址由旧私钥签名注册, 新证书由新私钥签名获取
+	if err := addNode(staged.address, net, adminAddress, oldSigner); err != nil {
+		log.Error("CaServer.RotateKeys: enroll new address failed", "err", err, "address", staged.address)
+		return nil, err
+	}
+	staged.cert, staged.hdPriKey, err = getCurrentCert(net, newSigner)
+	if err != nil {
+		log.Error("CaServer.RotateKeys: get new cert failed", "err", err, "address", staged.address)
+		return nil, err
+	}
+	parsed, err := util_cert.ParseCert([]byte(staged.cert.Cert))
+	if err != nil {
+		return nil, err
+	}
+	if parsed.SerialNumber.String() == result.OldSerial {
+		return nil, ErrRotateSameCert
+	}
+	if err := util_cert.VerifyKeyPair([]byte(staged.cert.Cert), []byte(staged.cert.PrivateKey)); err != nil {
+		return nil, err
+	}
+	result.NewSerial = parsed.SerialNumber.String()
+	result.NotAfter = parsed.NotAfter
+
+	if err := cutover(net, staged); err != nil {
+		log.Error("CaServer.RotateKeys: cut over to new keys failed", "err", err, "net", net)
+		return nil, err
+	}
+	log.Info("CaServer.RotateKeys: keys rotated", "net", net, "oldAddress", oldAddress, "newAddress", staged.address,
+		"oldSerial", result.OldSerial, "newSerial", result.NewSerial)
+
+	if !revokeOld {
+		return result, nil
+	}
+	// 切换完成后再撤销, 避免新证书生效前旧证书已不可用
+	if err := revokeNode(oldAddress, net, oldSigner); err != nil {
+		log.Error("CaServer.RotateKeys: revoke old address failed", "err", err, "address", oldAddress)
+		return result, err
+	}
+	result.Revoked = true
+	return result, nil
+}
+
+// newStagedKeys 生成新的账户
+func newStagedKeys() (*stagedKeys, gocrypto.Signer, error) {
+	account, err := crypto.GetCryptoClient().CreateNewAccountWithMnemonic(1, 1)
+	if err != nil {
+		return nil, nil, err
+	}
+	signer, err := keystore.ParsePrivateKey([]byte(account.JsonPrivateKey))
+	if err != nil {
+		return nil, nil, err
+	}
+	return &stagedKeys{
+		privateKey: []byte(account.JsonPrivateKey),
+		publicKey:  []byte(account.JsonPublicKey),
+		address:    account.Address,
+	}, signer, nil
+}
+
+// cutover 写入新的账户和证书. 写入前将当前的私钥和文件备份为.bak, 任一步失败时回滚到当前的账户和证书.
+// 私钥先于证书写入, 证书文件最后原子替换,
+// 证书监听在私钥与证书匹配之前加载失败时保留当前证书, 不会使用不匹配的证书和私钥
+func cutover(net string, staged *stagedKeys) error {
+	netConfig := config.GetNetConfig(net)
+	keys := []string{keystore.NetKey(keystore.KeyAccount, net), keystore.NetKey(keystore.KeyTls, net)}
+	if staged.hdPriKey != "" {
+		keys = append(keys, keystore.NetKey(keystore.KeyHd, net))
+	}
+	files := []string{
+		netConfig.Keys + "public.key",
+		netConfig.Keys + "address",
+		netConfig.TlsPath + util_cert.CACERT,
+		netConfig.TlsPath + util_cert.CERT,
+	}
+	return writeWithBackup(net, keys, files, func() error {
+		return writeStaged(net, staged)
+	})
+}
+
+// writeWithBackup 备份keys和files后执行write, write失败时恢复备份并重新加载证书
+// .bak备份只在回滚失败时保留, 供手动恢复; 写入并加载成功或回滚成功后删除
+func writeWithBackup(net string, keys []string, files []string, write func() error) error {
+	backup, err := backupKeys(keys, files)
+	if err != nil {
+		return err
+	}
+	if err := write(); err != nil {
+		if rollbackErr := backup.restore(); rollbackErr != nil {
+			log.Error("CaServer.writeWithBackup: rollback failed, restore the .bak keys and files manually", "err", rollbackErr, "net", net)
+			return err
+		}
+		if reloadErr := util_cert.Reload(net); reloadErr != nil {
+			log.Warn("CaServer.writeWithBackup: reload the restored cert failed", "err", reloadErr, "net", net)
+		}
+		backup.remove(net)
+		return err
+	}
+	backup.remove(net)
+	return nil
+}
+
+// writeStaged 依次写入新的账户私钥、地址、hd私钥、tls私钥和证书, 并重新加载证书
+func writeStaged(net string, staged *stagedKeys) error {
+	netConfig := config.GetNetConfig(net)
+	if err := writeKey(keystore.NetKey(keystore.KeyAccount, net), staged.privateKey); err != nil {
+		return err
+	}
+	err := writeFiles(map[string][]byte{
+		netConfig.Keys + "public.key": staged.publicKey,
+		netConfig.Keys + "address":    []byte(staged.address),
+	}, 0644)
+	if err != nil {
+		return err
+	}
+	if staged.hdPriKey != "" {
+		if err := writeKey(keystore.NetKey(keystore.KeyHd, net), []byte(staged.hdPriKey)); err != nil {
+			return err
+		}
+	}
+	if err := writeKey(keystore.NetKey(keystore.KeyTls, net), []byte(staged.cert.PrivateKey)); err != nil {
+		return err
+	}
+	err = writeFiles(map[string][]byte{
+		netConfig.TlsPath + util_cert.CACERT: []byte(staged.cert.CaCert),
+		netConfig.TlsPath + util_cert.CERT:   []byte(staged.cert.Cert),
+	}, 0644)
+	if err != nil {
+		return err
+	}
+	// 同一进程内的代理立即使用新证书, 其他进程由证书监听热加载
+	return util_cert.Reload(net)
+}
+
+// keyBackup 切换前的私钥和文件, 原来不存在的为nil
+type keyBackup struct {
+	keys  map[string][]byte
+	files map[string][]byte
+}
+
+// backupKeys 读取当前的私钥和文件并写入.bak备份, 无法读取私钥(如socket)时拒绝切换
+func backupKeys(keys []string, files []string) (*keyBackup, error) {
+	backup := &keyBackup{
+		keys:  make(map[string][]byte, len(keys)),
+		files: make(map[string][]byte, len(files)),
+	}
+	for _, name := range keys {
+		data, err := keystore.Read(name)
+		if os.IsNotExist(err) {
+			backup.keys[name] = nil
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("back up key %s failed, refuse to rotate: %v", name, err)
+		}
+		if err := keystore.Write(name+keystore.BackupSuffix, data); err != nil {
+			return nil, fmt.Errorf("back up key %s failed, refuse to rotate: %v", name, err)
+		}
+		backup.keys[name] = data
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if os.IsNotExist(err) {
+			backup.files[file] = nil
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := util_file.WriteFilesAtomic(map[string][]byte{file + keystore.BackupSuffix: data}, 0644); err != nil {
+			return nil, err
+		}
+		backup.files[file] = data
+	}
+	return backup, nil
+}
+
+// restore 恢复切换前的私钥和文件, 原来不存在的删除
+func (b *keyBackup) restore() error {
+	files := make(map[string][]byte)
+	for file, data := range b.files {
+		if data == nil {
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		files[file] = data
+	}
+	if err := util_file.WriteFilesAtomic(files, 0644); err != nil {
+		return err
+	}
+	for name, data := range b.keys {
+		var err error
+		if data == nil {
+			err = keystore.Remove(name)
+		} else {
+			err = keystore.Write(name, data)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove 删除.bak备份, 删除失败不影响切换结果
+func (b *keyBackup) remove(net string) {
+	for name, data := range b.keys {
+		if data == nil {
+			continue
+		}
+		if err := keystore.Remove(name + keystore.BackupSuffix); err != nil && !os.IsNotExist(err) {
+			log.Warn("CaServer.writeWithBackup: remove key backup failed", "err", err, "key", name, "net", net)
+		}
+	}
+	for file, data := range b.files {
+		if data == nil {
+			continue
+		}
+		if err := os.Remove(file + keystore.BackupSuffix); err != nil && !os.IsNotExist(err) {
+			log.Warn("CaServer.writeWithBackup: remove file backup failed", "err", err, "file", file, "net", net)
+		}
+	}
+}
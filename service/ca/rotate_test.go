@@ -0,0 +1,219 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package service
+
+import (
+	gocrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xuperchain/xuper-front/config"
+	"github.com/xuperchain/xuper-front/logs"
+	util_cert "github.com/xuperchain/xuper-front/util/cert"
+	util_file "github.com/xuperchain/xuper-front/util/file"
+	"github.com/xuperchain/xuper-front/util/keystore"
+)
+
+func TestRotateCutover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := config.InstallFrontConfig("../../conf/front.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Join(dir, "keys"), 0755)
+	os.MkdirAll(filepath.Join(dir, "tls"), 0755)
+	config.SetKeys(filepath.Join(dir, "keys"))
+	config.SetTlsPath(filepath.Join(dir, "tls"))
+	net := config.GetNet()
+
+	staged, signer := newTestStaged(t, net, 7)
+	if address, err := signerAddress(signer); err != nil || address != staged.address {
+		t.Fatalf("signer does not match the new account: %s, %v", address, err)
+	}
+
+	if err := cutover(net, staged); err != nil {
+		t.Fatal(err)
+	}
+	// 切换后账户私钥、地址和证书均为新的
+	if address, err := AccountAddress(net); err != nil || address != staged.address {
+		t.Fatalf("account key not rotated: %s, %v", address, err)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "keys", "address")); string(data) != staged.address {
+		t.Fatalf("unexpected address file %q", data)
+	}
+	cert, err := util_cert.LoadCert(net)
+	if err != nil || cert.SerialNumber.Int64() != 7 {
+		t.Fatalf("cert not rotated: %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "tls", util_cert.PRIVATEKEY)); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("tls private key should be written with 0600: %v", err)
+	}
+
+	// 再次切换成功后不保留.bak备份
+	next, _ := newTestStaged(t, net, 8)
+	if err := cutover(net, next); err != nil {
+		t.Fatal(err)
+	}
+	assertNoBackups(t, dir)
+}
+
+// assertNoBackups 私钥和文件的.bak备份均已删除
+func assertNoBackups(t *testing.T, dir string) {
+	for _, name := range []string{keystore.KeyAccount, keystore.KeyTls, keystore.KeyHd} {
+		if _, err := keystore.Read(name + keystore.BackupSuffix); !os.IsNotExist(err) {
+			t.Errorf("key backup %s should be removed, got %v", name, err)
+		}
+	}
+	backups, _ := filepath.Glob(filepath.Join(dir, "*", "*"+keystore.BackupSuffix))
+	if len(backups) != 0 {
+		t.Errorf("file backups should be removed: %v", backups)
+	}
+}
+
+// newTestStaged 生成新账户以及测试ca签发的证书
+func newTestStaged(t *testing.T, net string, serial int64) (*stagedKeys, gocrypto.Signer) {
+	staged, signer, err := newStagedKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, caKey := newTestCa(t, "ca")
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: net, SerialNumber: staged.address},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	staged.cert = &CurrentCert{
+		Cert:       string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})),
+		CaCert:     string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})),
+	}
+	return staged, signer
+}
+
+func TestRotateCutoverRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := config.InstallFrontConfig("../../conf/front.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	logs.InitLog("ca_test", dir)
+	StartCaHandler()
+	os.MkdirAll(filepath.Join(dir, "keys"), 0755)
+	os.MkdirAll(filepath.Join(dir, "tls"), 0755)
+	config.SetKeys(filepath.Join(dir, "keys"))
+	config.SetTlsPath(filepath.Join(dir, "tls"))
+	net := config.GetNet()
+
+	old, _ := newTestStaged(t, net, 7)
+	if err := cutover(net, old); err != nil {
+		t.Fatal(err)
+	}
+	oldTlsKey, err := keystore.Read(keystore.KeyTls)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 私钥已写入, 写入证书时失败
+	defer func() { writeFiles = util_file.WriteFilesAtomic }()
+	writes := 0
+	writeFiles = func(files map[string][]byte, perm os.FileMode) error {
+		if writes++; writes == 2 {
+			return errors.New("injected failure")
+		}
+		return util_file.WriteFilesAtomic(files, perm)
+	}
+	staged, _ := newTestStaged(t, net, 8)
+	staged.hdPriKey = "hd"
+	if err := cutover(net, staged); err == nil {
+		t.Fatal("cutover should fail")
+	}
+
+	// 回滚到切换前的账户、地址、私钥和证书, 原来不存在的hd私钥被删除
+	if address, err := AccountAddress(net); err != nil || address != old.address {
+		t.Fatalf("account key not rolled back: %s, %v", address, err)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "keys", "address")); string(data) != old.address {
+		t.Fatalf("address file not rolled back: %q", data)
+	}
+	if data, err := keystore.Read(keystore.KeyTls); err != nil || string(data) != string(oldTlsKey) {
+		t.Fatalf("tls key not rolled back: %v", err)
+	}
+	if _, err := keystore.Read(keystore.KeyHd); !os.IsNotExist(err) {
+		t.Fatalf("hd key should be removed, got %v", err)
+	}
+	if cert, err := util_cert.LoadCert(net); err != nil || cert.SerialNumber.Int64() != 7 {
+		t.Fatalf("cert not rolled back: %v", err)
+	}
+	// 回滚成功后不保留.bak备份
+	assertNoBackups(t, dir)
+}
+
+func TestWriteWithBackupRollbackFailed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := config.InstallFrontConfig("../../conf/front.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	logs.InitLog("ca_test", dir)
+	StartCaHandler()
+	os.MkdirAll(filepath.Join(dir, "keys"), 0755)
+	os.MkdirAll(filepath.Join(dir, "tls"), 0755)
+	config.SetKeys(filepath.Join(dir, "keys"))
+	config.SetTlsPath(filepath.Join(dir, "tls"))
+	net := config.GetNet()
+
+	old, _ := newTestStaged(t, net, 7)
+	if err := cutover(net, old); err != nil {
+		t.Fatal(err)
+	}
+	// 写入失败且tls目录被破坏, 证书无法恢复, 回滚失败
+	tlsDir := filepath.Join(dir, "tls")
+	keys := []string{keystore.KeyAccount}
+	files := []string{filepath.Join(tlsDir, util_cert.CERT)}
+	err = writeWithBackup(net, keys, files, func() error {
+		os.RemoveAll(tlsDir)
+		ioutil.WriteFile(tlsDir, []byte("not a dir"), 0644)
+		return errors.New("injected failure")
+	})
+	if err == nil {
+		t.Fatal("write should fail")
+	}
+	// 回滚失败时保留.bak备份供手动恢复
+	if data, err := keystore.Read(keystore.KeyAccount + keystore.BackupSuffix); err != nil || string(data) != string(old.privateKey) {
+		t.Fatalf("account key backup should be kept: %v", err)
+	}
+}
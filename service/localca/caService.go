@@ -0,0 +1,502 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package localca
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/xuperchain/xuper-front/config"
+	"github.com/xuperchain/xuper-front/crypto"
+	"github.com/xuperchain/xuper-front/dao"
+	"github.com/xuperchain/xuper-front/pb"
+	serv_ca "github.com/xuperchain/xuper-front/service/ca"
+	"github.com/xuperchain/xuper-front/util/keystore"
+)
+
+const (
+	// nonceWindow 签名中nonce(unix时间戳)与本地时间的最大偏差
+	nonceWindow = 10 * time.Minute
+	// maxRevokeWait 长轮询请求撤销列表时的最长等待时间
+	maxRevokeWait = 5 * time.Minute
+)
+
+var (
+	ErrInvalidSign      = errors.New("invalid sign")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrNetNotEnrolled   = errors.New("net is not enrolled")
+	ErrNodeNotEnrolled  = errors.New("node is not enrolled")
+
+	// issueMtx 避免同一节点并发请求时重复签发
+	issueMtx sync.Mutex
+
+	// revokeNotify net => 有新的撤销记录时关闭的channel, 唤醒长轮询的请求
+	revokeNotify    = make(map[string]chan struct{})
+	revokeNotifyMtx sync.Mutex
+)
+
+func localCaDao() *dao.LocalCaDao {
+	return &dao.LocalCaDao{
+		Log: log,
+	}
+}
+
+// verifySign 校验请求的签名, 签名的数据为data+nonce, 与front访问远程ca的签名方式一致
+func verifySign(sign *pb.Sign, data string) error {
+	if sign == nil {
+		return ErrInvalidSign
+	}
+	return verifySignedData(sign, []byte(data+sign.Nonce))
+}
+
+// verifySignedData 校验nonce和签名者, signed为签名的完整数据
+func verifySignedData(sign *pb.Sign, signed []byte) error {
+	if sign == nil {
+		return ErrInvalidSign
+	}
+	nonce, err := strconv.ParseInt(sign.Nonce, 10, 64)
+	if err != nil {
+		return ErrInvalidSign
+	}
+	if d := time.Since(time.Unix(nonce, 0)); d > nonceWindow || d < -nonceWindow {
+		return ErrInvalidSign
+	}
+	cryptoClient := crypto.GetCryptoClient()
+	publicKey, err := cryptoClient.GetEcdsaPublicKeyFromJsonStr(sign.PublicKey)
+	if err != nil {
+		return ErrInvalidSign
+	}
+	if ok, _ := cryptoClient.VerifyAddressUsingPublicKey(sign.Address, publicKey); !ok {
+		return ErrInvalidSign
+	}
+	if ok, err := cryptoClient.VerifyECDSA(publicKey, sign.Sign, signed); err != nil || !ok {
+		return ErrInvalidSign
+	}
+	return nil
+}
+
+// getAdmin 查询网络管理员, 网络不存在时返回ErrNetNotEnrolled
+func getAdmin(net string) (string, error) {
+	caNet, err := localCaDao().GetNet(net)
+	if err != nil {
+		return "", err
+	}
+	if caNet == nil {
+		return "", ErrNetNotEnrolled
+	}
+	return caNet.AdminAddress, nil
+}
+
+// EnrollNet 注册网络, 只能由管理员本人注册, 管理员须为caConfig.netAdmins中的地址或本节点的账户
+func EnrollNet(sign *pb.Sign, net string, address string) error {
+	if err := verifySign(sign, address+net); err != nil {
+		return err
+	}
+	if sign.Address != address || !canEnrollNet(address) {
+		log.Warn("LocalCa.EnrollNet: enroll net denied", "net", net, "address", address)
+		return ErrPermissionDenied
+	}
+	return enrollNet(net, address)
+}
+
+// canEnrollNet 地址是否可以注册网络成为管理员
+func canEnrollNet(address string) bool {
+	for _, admin := range config.GetCaConfig().NetAdmins {
+		if admin == address {
+			return true
+		}
+	}
+	for _, n := range config.GetNets() {
+		if self, err := serv_ca.AccountAddress(n.Name); err == nil && self == address {
+			return true
+		}
+	}
+	return false
+}
+
+func enrollNet(net string, address string) error {
+	caNet, err := localCaDao().GetNet(net)
+	if err != nil {
+		return err
+	}
+	if caNet != nil {
+		if caNet.AdminAddress != address {
+			return ErrPermissionDenied
+		}
+		return nil
+	}
+	// 网络的hd根私钥, 节点的hd私钥由其派生
+	cryptoClient := crypto.GetCryptoClient()
+	account, err := cryptoClient.CreateNewAccountWithMnemonic(1, 1)
+	if err != nil {
+		return err
+	}
+	masterKey, err := cryptoClient.GenerateMasterKeyByMnemonic(account.Mnemonic, 1)
+	if err != nil {
+		return err
+	}
+	if err := writeKey(keystore.LocalCaKey("hd", net), []byte(masterKey)); err != nil {
+		return err
+	}
+	err = localCaDao().InsertNet(&dao.CaNet{
+		Net:          net,
+		AdminAddress: address,
+		CreateTime:   time.Now().Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	log.Info("LocalCa.enrollNet: net enrolled", "net", net, "admin", address)
+	return nil
+}
+
+// EnrollNode 网络管理员注册节点, 证书在节点首次获取时签发
+func EnrollNode(sign *pb.Sign, net string, address string, adminAddress string) error {
+	if err := verifySign(sign, address+net); err != nil {
+		return err
+	}
+	admin, err := getAdmin(net)
+	if err != nil {
+		return err
+	}
+	if sign.Address != admin || (adminAddress != "" && adminAddress != admin) {
+		return ErrPermissionDenied
+	}
+	return enrollNode(net, address, admin)
+}
+
+func enrollNode(net string, address string, admin string) error {
+	node, err := localCaDao().GetNode(net, address)
+	if err != nil {
+		return err
+	}
+	if node != nil {
+		return nil
+	}
+	_, err = localCaDao().InsertNode(&dao.CaNode{
+		Net:          net,
+		Address:      address,
+		AdminAddress: admin,
+		CreateTime:   time.Now().Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	log.Info("LocalCa.enrollNode: node enrolled", "net", net, "address", address)
+	return nil
+}
+
+// GetCurrentCert 节点获取自己的证书
+func GetCurrentCert(sign *pb.Sign, net string, address string) (*serv_ca.CurrentCert, string, error) {
+	if err := verifySign(sign, address+net); err != nil {
+		return nil, "", err
+	}
+	if sign.Address != address {
+		return nil, "", ErrPermissionDenied
+	}
+	return currentCert(net, address)
+}
+
+// currentCert 返回节点的当前证书, 证书不存在、进入更新窗口或私钥与证书不匹配时重新签发
+// 节点私钥保存在keystore中, hd私钥每次由网络的hd根私钥派生, 都不写入数据库
+func currentCert(net string, address string) (*serv_ca.CurrentCert, string, error) {
+	if root == nil {
+		return nil, "", ErrNotInitialized
+	}
+	issueMtx.Lock()
+	defer issueMtx.Unlock()
+
+	node, err := localCaDao().GetNode(net, address)
+	if err != nil {
+		return nil, "", err
+	}
+	if node == nil {
+		return nil, "", ErrNodeNotEnrolled
+	}
+	if err := migrateNodeKeys(node); err != nil {
+		return nil, "", err
+	}
+	var keyPem []byte
+	if node.Cert != "" {
+		keyPem, err = keystore.Read(nodeKey(node))
+		if err == nil && !keyMatchesCert([]byte(node.Cert), keyPem) {
+			// 上次签发后未能更新数据库, 私钥与证书不匹配
+			keyPem = nil
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return nil, "", err
+		}
+	}
+	renewBefore := time.Duration(config.GetCaConfig().RenewBefore) * time.Hour
+	if keyPem == nil || time.Until(time.Unix(node.NotAfter, 0)) <= renewBefore {
+		if keyPem, err = reissue(node); err != nil {
+			log.Error("LocalCa.currentCert: issue cert failed", "err", err, "net", net, "address", address)
+			return nil, "", err
+		}
+	}
+	hdKey, err := nodeHdKey(node)
+	if err != nil {
+		return nil, "", err
+	}
+	return &serv_ca.CurrentCert{
+		Cert:       node.Cert,
+		PrivateKey: string(keyPem),
+		CaCert:     string(root.pem),
+	}, hdKey, nil
+}
+
+// reissue 为节点签发新证书, 私钥写入keystore后更新数据库中的证书
+func reissue(node *dao.CaNode) ([]byte, error) {
+	validity := time.Duration(config.GetCaConfig().LocalCaValidity) * 24 * time.Hour
+	issued, err := root.issue(node.Net, node.Address, validity)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeKey(nodeKey(node), []byte(issued.PrivateKey)); err != nil {
+		return nil, err
+	}
+	node.SerialNum = issued.SerialNum
+	node.Cert = issued.Cert
+	node.NotAfter = issued.NotAfter.Unix()
+	if err := localCaDao().UpdateNodeCert(node); err != nil {
+		return nil, err
+	}
+	log.Info("LocalCa.reissue: cert issued", "net", node.Net, "address", node.Address, "serial", issued.SerialNum,
+		"notAfter", issued.NotAfter)
+	return []byte(issued.PrivateKey), nil
+}
+
+// nodeKey 节点私钥在keystore中的名称
+func nodeKey(node *dao.CaNode) string {
+	return keystore.LocalCaKey("node", node.Net, node.Address)
+}
+
+// nodeHdKey 由网络的hd根私钥派生节点的hd私钥
+func nodeHdKey(node *dao.CaNode) (string, error) {
+	caNet, err := localCaDao().GetNet(node.Net)
+	if err != nil {
+		return "", err
+	}
+	if caNet == nil {
+		return "", ErrNetNotEnrolled
+	}
+	masterKey, err := hdMasterKey(caNet)
+	if err != nil {
+		return "", err
+	}
+	return crypto.GetCryptoClient().GenerateChildKey(masterKey, uint32(node.Id))
+}
+
+// hdMasterKey 从keystore读取网络的hd根私钥, 旧版本保存在数据库中的明文私钥先迁移到keystore
+func hdMasterKey(caNet *dao.CaNet) (string, error) {
+	name := keystore.LocalCaKey("hd", caNet.Net)
+	if caNet.HdMasterKey != "" {
+		if err := writeKey(name, []byte(caNet.HdMasterKey)); err != nil {
+			return "", err
+		}
+		if err := localCaDao().ClearNetKey(caNet.Net); err != nil {
+			return "", err
+		}
+		log.Info("LocalCa.hdMasterKey: hd master key moved to keystore", "net", caNet.Net)
+		return caNet.HdMasterKey, nil
+	}
+	data, err := keystore.Read(name)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// migrateNodeKeys 旧版本保存在数据库中的明文私钥迁移到keystore, hd私钥可由根私钥派生, 直接清空
+func migrateNodeKeys(node *dao.CaNode) error {
+	if node.PrivateKey == "" && node.HdPrivateKey == "" {
+		return nil
+	}
+	if node.PrivateKey != "" {
+		if err := writeKey(nodeKey(node), []byte(node.PrivateKey)); err != nil {
+			return err
+		}
+	}
+	if err := localCaDao().ClearNodeKeys(node.Id); err != nil {
+		return err
+	}
+	node.PrivateKey, node.HdPrivateKey = "", ""
+	log.Info("LocalCa.migrateNodeKeys: node keys moved to keystore", "net", node.Net, "address", node.Address)
+	return nil
+}
+
+// writeKey 将本地ca生成的私钥写入keystore, file/encrypted时存放在localCaPath/keys/下
+func writeKey(name string, data []byte) error {
+	if err := os.MkdirAll(config.GetLocalCaPath()+"keys", 0700); err != nil {
+		return err
+	}
+	return keystore.Write(name, data)
+}
+
+// revokeChanged 返回网络下次有新撤销记录时关闭的channel
+func revokeChanged(net string) <-chan struct{} {
+	revokeNotifyMtx.Lock()
+	defer revokeNotifyMtx.Unlock()
+	ch, ok := revokeNotify[net]
+	if !ok {
+		ch = make(chan struct{})
+		revokeNotify[net] = ch
+	}
+	return ch
+}
+
+// notifyRevoke 唤醒等待网络撤销记录的长轮询请求
+func notifyRevoke(net string) {
+	revokeNotifyMtx.Lock()
+	defer revokeNotifyMtx.Unlock()
+	if ch, ok := revokeNotify[net]; ok {
+		close(ch)
+		delete(revokeNotify, net)
+	}
+}
+
+// GetRevokeList 网络中的节点获取serialNum之后撤销的证书
+func GetRevokeList(sign *pb.Sign, net string, serialNum string) ([]*pb.RevokeNode, error) {
+	return WaitRevokeList(context.Background(), sign, net, serialNum, 0)
+}
+
+// WaitRevokeList 长轮询获取serialNum之后撤销的证书, 没有新记录时最多等待wait, 期间有节点被撤销时立即返回
+func WaitRevokeList(ctx context.Context, sign *pb.Sign, net string, serialNum string, wait time.Duration) ([]*pb.RevokeNode, error) {
+	if err := verifySign(sign, serialNum+net); err != nil {
+		return nil, err
+	}
+	admin, err := getAdmin(net)
+	if err != nil {
+		return nil, err
+	}
+	if sign.Address != admin {
+		node, err := localCaDao().GetNode(net, sign.Address)
+		if err != nil {
+			return nil, err
+		}
+		if node == nil {
+			return nil, ErrPermissionDenied
+		}
+	}
+	if wait > maxRevokeWait {
+		wait = maxRevokeWait
+	}
+	// 先取channel再查询, 避免查询之后写入的撤销记录无法唤醒
+	changed := revokeChanged(net)
+	list, err := listRevokes(net, serialNum)
+	if err != nil || len(list) > 0 || wait <= 0 {
+		return list, err
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-changed:
+		return listRevokes(net, serialNum)
+	case <-t.C:
+		return list, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// listRevokes 查询serialNum之后撤销的证书
+func listRevokes(net string, serialNum string) ([]*pb.RevokeNode, error) {
+	revokeDao := dao.RevokeDao{
+		Log: log,
+	}
+	revokes, err := revokeDao.ListAfter(net, serialNum)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*pb.RevokeNode, 0, len(revokes))
+	for _, revoke := range revokes {
+		sig, _ := hex.DecodeString(revoke.Sign)
+		list = append(list, &pb.RevokeNode{
+			Id:         int64(revoke.Id),
+			SerialNum:  revoke.SerialNum,
+			CreateTime: int64(revoke.CreateTime),
+			Address:    revoke.Address,
+			PublicKey:  revoke.PublicKey,
+			Sign:       sig,
+		})
+	}
+	return list, nil
+}
+
+// RevokeNode 网络管理员撤销节点, 节点所有未过期的证书写入撤销列表
+func RevokeNode(sign *pb.Sign, net string, address string) error {
+	// 撤销请求的签名保存到撤销记录中, 按撤销记录的签名数据校验, 保证其他front同步时能够通过校验
+	if sign == nil {
+		return ErrInvalidSign
+	}
+	nonce, err := strconv.ParseInt(sign.Nonce, 10, 64)
+	if err != nil {
+		return ErrInvalidSign
+	}
+	if err := verifySignedData(sign, serv_ca.RevokeSignData(net, address, nonce)); err != nil {
+		return err
+	}
+	admin, err := getAdmin(net)
+	if err != nil {
+		return err
+	}
+	if sign.Address != admin {
+		return ErrPermissionDenied
+	}
+	node, err := localCaDao().GetNode(net, address)
+	if err != nil {
+		return err
+	}
+	if node == nil {
+		return ErrNodeNotEnrolled
+	}
+	// 保存管理员的签名, 其他front同步撤销列表时校验, nonce作为记录的createTime
+	serials, err := localCaDao().RevokeNode(&dao.Revoke{
+		Net:        net,
+		Address:    address,
+		CreateTime: int(nonce),
+		Signer:     sign.Address,
+		PublicKey:  sign.PublicKey,
+		Sign:       hex.EncodeToString(sign.Sign),
+	})
+	if err != nil {
+		return err
+	}
+	log.Info("LocalCa.RevokeNode: node revoked", "net", net, "address", address, "serials", serials)
+	notifyRevoke(net)
+	// 本节点所在网络的撤销立即生效, 关闭已撤销节点的连接
+	for _, n := range config.GetNets() {
+		if n.Name == net {
+			return serv_ca.LoadRevokeSet(net)
+		}
+	}
+	return nil
+}
+
+// useLocalCa 本节点的证书由本地ca签发, 本节点不存在时注册网络和节点
+func useLocalCa() {
+	serv_ca.UseLocalCa(func(net string) (*serv_ca.CurrentCert, string, error) {
+		address, err := serv_ca.AccountAddress(net)
+		if err != nil {
+			return nil, "", err
+		}
+		admin, err := getAdmin(net)
+		if err == ErrNetNotEnrolled {
+			admin = address
+			err = enrollNet(net, address)
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if err := enrollNode(net, address, admin); err != nil {
+			return nil, "", err
+		}
+		return currentCert(net, address)
+	})
+}
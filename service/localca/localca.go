@@ -0,0 +1,332 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package localca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/xuperchain/crypto/gm/gmsm/sm2"
+
+	"github.com/xuperchain/xuper-front/config"
+	xcrypto "github.com/xuperchain/xuper-front/crypto"
+	logs "github.com/xuperchain/xuper-front/logs"
+	util_cert "github.com/xuperchain/xuper-front/util/cert"
+	util_file "github.com/xuperchain/xuper-front/util/file"
+	"github.com/xuperchain/xuper-front/util/keystore"
+)
+
+const (
+	// ROOTCERT 本地ca根证书的文件名, 根私钥通过keystore保存
+	ROOTCERT = "ca.pem"
+
+	rootValidity = 10 * 365 * 24 * time.Hour
+	// 证书生效时间提前, 容忍节点间的时钟偏差
+	clockSkew = 5 * time.Minute
+)
+
+var (
+	ErrNotInitialized  = errors.New("local ca is not initialized")
+	ErrRootKeyMismatch = errors.New("local ca root key does not match root cert")
+	ErrRootKeyType     = errors.New("local ca root key is not a sm2 key, cryptoType gm requires a sm2 root, move localCaPath away to generate a new root")
+
+	log  *logs.LogFitter
+	root *rootCa
+)
+
+// rootCa 本地ca的根证书和签名器
+type rootCa struct {
+	cert   *x509.Certificate
+	pem    []byte
+	signer crypto.Signer
+}
+
+// issuedCert 本地ca签发的节点证书, 与远程ca的GetCurrentCert返回格式一致
+type issuedCert struct {
+	SerialNum  string
+	Cert       string
+	PrivateKey string
+	NotAfter   time.Time
+}
+
+// Init 加载本地ca的根证书和根私钥, 不存在时生成, 并由本地ca为本节点签发证书
+func Init() error {
+	log, _ = logs.NewLogger("LocalCa")
+	ca, err := loadOrCreateRoot()
+	if err != nil {
+		log.Error("LocalCa.Init: load root failed", "err", err)
+		return err
+	}
+	root = ca
+	useLocalCa()
+	log.Info("LocalCa.Init: local ca started", "subject", ca.cert.Subject.String(), "notAfter", ca.cert.NotAfter)
+	return nil
+}
+
+// loadOrCreateRoot 根证书存在时加载, 否则生成新的根私钥和自签名根证书
+func loadOrCreateRoot() (*rootCa, error) {
+	path := config.GetLocalCaPath()
+	certFile := path + ROOTCERT
+	if util_file.Exist(certFile) {
+		return loadRoot(certFile)
+	}
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, err
+	}
+
+	keyPem, pub, err := generateKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := keystore.Write(keystore.KeyLocalCa, keyPem); err != nil {
+		return nil, err
+	}
+	signer, err := rootSigner()
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:   "xuper-front local ca",
+			Organization: []string{config.GetNet()},
+		},
+		NotBefore:             now.Add(-clockSkew),
+		NotAfter:              now.Add(rootValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		SubjectKeyId:          subjectKeyId(pub),
+	}
+	der, err := createCertificate(template, template, signer.Public(), signer)
+	if err != nil {
+		return nil, err
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := util_file.WriteFilesAtomic(map[string][]byte{certFile: certPem}, 0644); err != nil {
+		return nil, err
+	}
+	cert, err := util_cert.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("LocalCa.loadOrCreateRoot: root generated", "path", path)
+	return &rootCa{
+		cert:   cert,
+		pem:    certPem,
+		signer: signer,
+	}, nil
+}
+
+func loadRoot(certFile string) (*rootCa, error) {
+	certPem, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := util_cert.ParseCert(certPem)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := rootSigner()
+	if err != nil {
+		return nil, err
+	}
+	if !samePublicKey(cert.PublicKey, signer.Public()) {
+		return nil, ErrRootKeyMismatch
+	}
+	return &rootCa{
+		cert:   cert,
+		pem:    certPem,
+		signer: signer,
+	}, nil
+}
+
+// issue 为节点签发证书, 与远程ca一致: CN和DNSNames为网络名(tls的ServerName), Subject.SerialNumber为节点地址
+func (ca *rootCa) issue(net string, address string, validity time.Duration) (*issuedCert, error) {
+	keyPem, pub, err := generateKey()
+	if err != nil {
+		return nil, err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	notAfter := now.Add(validity)
+	// 节点证书不能晚于根证书过期
+	if notAfter.After(ca.cert.NotAfter) {
+		notAfter = ca.cert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:   net,
+			SerialNumber: address,
+		},
+		NotBefore:    now.Add(-clockSkew),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{net},
+		SubjectKeyId: subjectKeyId(pub),
+	}
+	der, err := createCertificate(template, ca.cert, pub, ca.signer)
+	if err != nil {
+		return nil, err
+	}
+	return &issuedCert{
+		SerialNum:  serial.String(),
+		Cert:       string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		PrivateKey: string(keyPem),
+		NotAfter:   notAfter,
+	}, nil
+}
+
+// generateKey 按cryptoType生成私钥, 国密时为SM2, 否则为ECDSA P-256, 返回PEM格式的私钥和公钥
+func generateKey() ([]byte, crypto.PublicKey, error) {
+	if xcrypto.IsGm() {
+		key, err := sm2.GenerateKey()
+		if err != nil {
+			return nil, nil, err
+		}
+		der, err := sm2.MarshalSm2UnecryptedPrivateKey(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), &key.PublicKey, nil
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPem, err := marshalPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return keyPem, &key.PublicKey, nil
+}
+
+// rootSigner 根私钥的签名器, 国密时使用SM2/SM3签发证书需要SM2私钥原文, 不支持socket类型的keystore
+func rootSigner() (crypto.Signer, error) {
+	if !xcrypto.IsGm() {
+		return keystore.Signer(keystore.KeyLocalCa)
+	}
+	data, err := keystore.Read(keystore.KeyLocalCa)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrRootKeyType
+	}
+	key, err := sm2.ParsePKCS8UnecryptedPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, ErrRootKeyType
+	}
+	return key, nil
+}
+
+// createCertificate 使用根私钥签发证书, 国密时使用SM2/SM3签名
+func createCertificate(template, parent *x509.Certificate, pub crypto.PublicKey, signer crypto.Signer) ([]byte, error) {
+	if !xcrypto.IsGm() {
+		return x509.CreateCertificate(rand.Reader, template, parent, pub, signer)
+	}
+	gmTemplate := toSm2Certificate(template)
+	gmParent := gmTemplate
+	if parent != template {
+		var err error
+		if gmParent, err = sm2.ParseCertificate(parent.Raw); err != nil {
+			return nil, err
+		}
+	}
+	return sm2.CreateCertificate(rand.Reader, gmTemplate, gmParent, pub, signer)
+}
+
+// toSm2Certificate 将证书模板转换为国密证书模板, 只转换本地ca签发证书使用的字段
+func toSm2Certificate(c *x509.Certificate) *sm2.Certificate {
+	cert := &sm2.Certificate{
+		SerialNumber:          c.SerialNumber,
+		Subject:               c.Subject,
+		NotBefore:             c.NotBefore,
+		NotAfter:              c.NotAfter,
+		KeyUsage:              sm2.KeyUsage(c.KeyUsage),
+		BasicConstraintsValid: c.BasicConstraintsValid,
+		IsCA:                  c.IsCA,
+		SubjectKeyId:          c.SubjectKeyId,
+		DNSNames:              c.DNSNames,
+	}
+	for _, usage := range c.ExtKeyUsage {
+		cert.ExtKeyUsage = append(cert.ExtKeyUsage, sm2.ExtKeyUsage(usage))
+	}
+	return cert
+}
+
+func marshalPrivateKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+// newSerial 128位随机证书序列号
+func newSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func subjectKeyId(pub crypto.PublicKey) []byte {
+	key := ecdsaPublicKey(pub)
+	id := sha1.Sum(elliptic.Marshal(key.Curve, key.X, key.Y))
+	return id[:]
+}
+
+// ecdsaPublicKey 将SM2公钥转换为ecdsa.PublicKey, 本地ca只使用ECDSA和SM2私钥, 其他类型返回nil
+func ecdsaPublicKey(pub crypto.PublicKey) *ecdsa.PublicKey {
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		return pub
+	case *sm2.PublicKey:
+		return &ecdsa.PublicKey{Curve: pub.Curve, X: pub.X, Y: pub.Y}
+	}
+	return nil
+}
+
+// samePublicKey 比较两个公钥, x509无法序列化SM2公钥, 按曲线和坐标比较
+func samePublicKey(a, b crypto.PublicKey) bool {
+	ka, kb := ecdsaPublicKey(a), ecdsaPublicKey(b)
+	if ka == nil || kb == nil {
+		return false
+	}
+	return ka.Curve.Params().Name == kb.Curve.Params().Name && ka.X.Cmp(kb.X) == 0 && ka.Y.Cmp(kb.Y) == 0
+}
+
+// keyMatchesCert 私钥与证书的公钥是否一致, tls无法加载SM2证书, 不使用tls.X509KeyPair
+func keyMatchesCert(certPem []byte, keyPem []byte) bool {
+	cert, err := util_cert.ParseCert(certPem)
+	if err != nil {
+		return false
+	}
+	signer, err := keystore.ParsePrivateKey(keyPem)
+	if err != nil {
+		return false
+	}
+	return samePublicKey(cert.PublicKey, signer.Public())
+}
@@ -0,0 +1,302 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package localca
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/xuperchain/crypto/gm/gmsm/sm2"
+
+	"github.com/xuperchain/xuper-front/config"
+	xcrypto "github.com/xuperchain/xuper-front/crypto"
+	"github.com/xuperchain/xuper-front/dao"
+	logs "github.com/xuperchain/xuper-front/logs"
+	"github.com/xuperchain/xuper-front/pb"
+	util_cert "github.com/xuperchain/xuper-front/util/cert"
+	"github.com/xuperchain/xuper-front/util/keystore"
+)
+
+func TestIssueCert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := config.InstallFrontConfig("../../conf/front.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	config.GetConfig().CaConfig.LocalCaPath = dir
+	logs.InitLog("localca_test", dir)
+	log, _ = logs.NewLogger("LocalCa")
+
+	ca, err := loadOrCreateRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ca.cert.IsCA {
+		t.Fatal("root should be a ca")
+	}
+	// 再次启动时加载已有的根证书
+	loaded, err := loadOrCreateRoot()
+	if err != nil || loaded.cert.SerialNumber.Cmp(ca.cert.SerialNumber) != 0 {
+		t.Fatalf("reload root failed: %v", err)
+	}
+
+	issued, err := ca.issue("testnet", "dpzuVdosQrF2kmzumhVeFQZa1aYcdgFpN", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := util_cert.VerifyKeyPair([]byte(issued.Cert), []byte(issued.PrivateKey)); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := util_cert.ParseCert([]byte(issued.Cert))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Subject.SerialNumber != "dpzuVdosQrF2kmzumhVeFQZa1aYcdgFpN" || cert.SerialNumber.String() != issued.SerialNum {
+		t.Fatalf("unexpected subject %v", cert.Subject)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	_, err = cert.Verify(x509.VerifyOptions{
+		DNSName:   "testnet",
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifySign(t *testing.T) {
+	if err := config.InstallFrontConfig("../../conf/front.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	cryptoClient := xcrypto.GetCryptoClient()
+	account, err := cryptoClient.CreateNewAccountWithMnemonic(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := keystore.ParsePrivateKey([]byte(account.JsonPrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newSign := func(data string, nonce int64) *pb.Sign {
+		s := strconv.FormatInt(nonce, 10)
+		sig, err := signer.Sign(rand.Reader, []byte(data+s), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &pb.Sign{
+			Address:   account.Address,
+			PublicKey: account.JsonPublicKey,
+			Sign:      sig,
+			Nonce:     s,
+		}
+	}
+
+	now := time.Now().Unix()
+	if err := verifySign(newSign("addrnet", now), "addrnet"); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifySign(newSign("addrnet", now), "othernet"); err != ErrInvalidSign {
+		t.Fatal("sign of other data should be rejected")
+	}
+	if err := verifySign(newSign("addrnet", now-3600), "addrnet"); err != ErrInvalidSign {
+		t.Fatal("expired nonce should be rejected")
+	}
+	sign := newSign("addrnet", now)
+	sign.Address = "dpzuVdosQrF2kmzumhVeFQZa1aYcdgFpN"
+	if err := verifySign(sign, "addrnet"); err != ErrInvalidSign {
+		t.Fatal("address not matching public key should be rejected")
+	}
+}
+
+func TestRevokeNotify(t *testing.T) {
+	changed := revokeChanged("notifynet")
+	other := revokeChanged("othernet")
+	if revokeChanged("notifynet") != changed {
+		t.Fatal("waiters of the same net should share the channel")
+	}
+	notifyRevoke("notifynet")
+	select {
+	case <-changed:
+	default:
+		t.Fatal("waiters should be woken up after revoke")
+	}
+	select {
+	case <-other:
+		t.Fatal("waiters of other nets should not be woken up")
+	default:
+	}
+	// 唤醒后的请求等待下一次撤销
+	select {
+	case <-revokeChanged("notifynet"):
+		t.Fatal("new waiters should wait for the next revoke")
+	default:
+	}
+	notifyRevoke("emptynet")
+}
+
+func TestCanEnrollNet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := config.InstallFrontConfig("../../conf/front.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	config.SetKeys(dir)
+	cryptoClient := xcrypto.GetCryptoClient()
+	self, err := cryptoClient.CreateNewAccountWithMnemonic(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keystore.Write(keystore.KeyAccount, []byte(self.JsonPrivateKey)); err != nil {
+		t.Fatal(err)
+	}
+	other, err := cryptoClient.CreateNewAccountWithMnemonic(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !canEnrollNet(self.Address) {
+		t.Fatal("account of this node should be able to enroll nets")
+	}
+	if canEnrollNet(other.Address) {
+		t.Fatal("address not in netAdmins should not enroll nets")
+	}
+	config.GetConfig().CaConfig.NetAdmins = []string{other.Address}
+	if !canEnrollNet(other.Address) {
+		t.Fatal("address in netAdmins should be able to enroll nets")
+	}
+}
+
+func TestNodeKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := config.InstallFrontConfig("../../conf/front.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	config.GetConfig().CaConfig.LocalCaPath = dir
+	logs.InitLog("localca_test", dir)
+	log, _ = logs.NewLogger("LocalCa")
+	dao.InitTables()
+	if root, err = loadOrCreateRoot(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { root = nil }()
+
+	net := "keynet" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	address := "dpzuVdosQrF2kmzumhVeFQZa1aYcdgFpN"
+	if err := enrollNet(net, address); err != nil {
+		t.Fatal(err)
+	}
+	if err := enrollNode(net, address, address); err != nil {
+		t.Fatal(err)
+	}
+	cert, hdKey, err := currentCert(net, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := util_cert.VerifyKeyPair([]byte(cert.Cert), []byte(cert.PrivateKey)); err != nil {
+		t.Fatal(err)
+	}
+	// 私钥不写入数据库
+	caNet, _ := localCaDao().GetNet(net)
+	node, _ := localCaDao().GetNode(net, address)
+	if caNet.HdMasterKey != "" || node.PrivateKey != "" || node.HdPrivateKey != "" {
+		t.Fatal("private keys should not be stored in the db")
+	}
+	info, err := os.Stat(filepath.Join(dir, "keys", "node_"+net+"_"+address+".key"))
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("node key should be stored in the keystore: %v", err)
+	}
+
+	// 再次获取时返回相同的证书和hd私钥
+	again, againHd, err := currentCert(net, address)
+	if err != nil || again.Cert != cert.Cert || again.PrivateKey != cert.PrivateKey || againHd != hdKey {
+		t.Fatalf("current cert should be kept: %v", err)
+	}
+
+	// 旧版本保存在数据库中的私钥迁移到keystore
+	node.PrivateKey, node.HdPrivateKey = "legacy key", hdKey
+	if err := migrateNodeKeys(node); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := keystore.Read(nodeKey(node)); err != nil || string(data) != "legacy key" {
+		t.Fatalf("legacy key should be moved to the keystore: %v", err)
+	}
+	// 私钥与证书不匹配时重新签发
+	reissued, _, err := currentCert(net, address)
+	if err != nil || reissued.Cert == cert.Cert {
+		t.Fatalf("cert should be reissued when the key does not match: %v", err)
+	}
+	if err := util_cert.VerifyKeyPair([]byte(reissued.Cert), []byte(reissued.PrivateKey)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIssueGmCert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := config.InstallFrontConfig("../../conf/front.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	config.GetConfig().CaConfig.LocalCaPath = dir
+	config.SetCryptoType(xcrypto.CryptoTypeGm)
+	defer config.SetCryptoType("")
+	logs.InitLog("localca_test", dir)
+	log, _ = logs.NewLogger("LocalCa")
+
+	ca, err := loadOrCreateRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pub, ok := ca.cert.PublicKey.(*ecdsa.PublicKey); !ok || !xcrypto.IsSm2Key(pub) {
+		t.Fatal("root key should be a sm2 key")
+	}
+	if _, err := loadOrCreateRoot(); err != nil {
+		t.Fatalf("reload sm2 root failed: %v", err)
+	}
+
+	issued, err := ca.issue("testnet", "dpzuVdosQrF2kmzumhVeFQZa1aYcdgFpN", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !keyMatchesCert([]byte(issued.Cert), []byte(issued.PrivateKey)) {
+		t.Fatal("issued key should match the cert")
+	}
+	block, _ := pem.Decode([]byte(issued.Cert))
+	cert, err := sm2.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.SignatureAlgorithm != sm2.SM2WithSM3 || cert.Subject.SerialNumber != "dpzuVdosQrF2kmzumhVeFQZa1aYcdgFpN" {
+		t.Fatalf("unexpected cert %v %v", cert.SignatureAlgorithm, cert.Subject)
+	}
+	parent, err := sm2.ParseCertificate(ca.cert.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.CheckSignatureFrom(parent); err != nil {
+		t.Fatalf("cert should be signed by the sm2 root: %v", err)
+	}
+}
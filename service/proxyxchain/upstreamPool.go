@@ -0,0 +1,316 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package proxyxchain
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	logs "github.com/xuperchain/xuper-front/logs"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+const (
+	// PolicyPrimaryBackup 总是使用列表中第一个健康的节点
+	PolicyPrimaryBackup = "primary-backup"
+	// PolicyRoundRobin 在健康的节点间轮询
+	PolicyRoundRobin = "round-robin"
+	// PolicyLeastInflight 选择在途请求最少的健康节点
+	PolicyLeastInflight = "least-inflight"
+
+	// defaultHealthCheckInterval 默认探活间隔
+	defaultHealthCheckInterval = 5 * time.Second
+	// reconnectCloseDelay 重建连接后延迟关闭旧连接的时间
+	reconnectCloseDelay = 30 * time.Second
+	// failureEjectDuration 转发出错被摘除的节点至少摘除这么久, 期间探活成功也不恢复,
+	// 避免tcp可连但stream不可用的节点被立即加回
+	failureEjectDuration = 30 * time.Second
+)
+
+var (
+	ErrNoUpstream        = errors.New("no upstream configured")
+	ErrNoHealthyUpstream = errors.New("no healthy upstream")
+	ErrUnknownPolicy     = errors.New("unknown upstream policy")
+)
+
+// dialFunc 建立到某个xchain节点的连接
+type dialFunc func(host string) (*grpc.ClientConn, error)
+
+// probeFunc 对某个xchain节点做一次主动探活
+type probeFunc func(host string) error
+
+// upstream 代理后端的一个xchain节点
+type upstream struct {
+	host     string
+	conn     *grpc.ClientConn
+	healthy  bool
+	reason   string
+	inflight int64
+	// ejectedUntil 转发出错被摘除时, 在该时间之前不会被探活恢复
+	ejectedUntil time.Time
+}
+
+func (u *upstream) acquire() {
+	atomic.AddInt64(&u.inflight, 1)
+}
+
+func (u *upstream) release() {
+	atomic.AddInt64(&u.inflight, -1)
+}
+
+// UpstreamState 节点在池中的状态, 用于展示
+type UpstreamState struct {
+	Host      string
+	Healthy   bool
+	Reason    string
+	Inflight  int64
+	ConnState string
+}
+
+// upstreamPool 维护xchain节点列表, 负责探活和故障切换
+type upstreamPool struct {
+	upstreams []*upstream
+	policy    string
+	next      uint64
+	dial      dialFunc
+	probe     probeFunc
+	mutex     sync.RWMutex
+	close     chan struct{}
+	log       logs.Logger
+}
+
+func newUpstreamPool(hosts []string, policy string, dial dialFunc, probe probeFunc, log logs.Logger) (*upstreamPool, error) {
+	if len(hosts) == 0 {
+		return nil, ErrNoUpstream
+	}
+	if policy == "" {
+		policy = PolicyPrimaryBackup
+	}
+	if policy != PolicyPrimaryBackup && policy != PolicyRoundRobin && policy != PolicyLeastInflight {
+		return nil, ErrUnknownPolicy
+	}
+	pool := &upstreamPool{
+		policy: policy,
+		dial:   dial,
+		probe:  probe,
+		close:  make(chan struct{}),
+		log:    log,
+	}
+	for _, host := range hosts {
+		u := &upstream{
+			host: host,
+		}
+		conn, err := dial(host)
+		if err != nil {
+			u.reason = "dial failed: " + err.Error()
+			log.Warn("UpstreamPool: eject upstream", "host", host, "reason", u.reason)
+		} else {
+			u.conn = conn
+			u.healthy = true
+		}
+		pool.upstreams = append(pool.upstreams, u)
+	}
+	return pool, nil
+}
+
+// pick 按照策略选择一个健康的节点, 同时返回该节点当前的连接
+func (p *upstreamPool) pick() (*upstream, *grpc.ClientConn, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	var healthy []*upstream
+	for _, u := range p.upstreams {
+		if u.healthy && u.conn != nil {
+			healthy = append(healthy, u)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil, nil, ErrNoHealthyUpstream
+	}
+	picked := healthy[0]
+	switch p.policy {
+	case PolicyRoundRobin:
+		n := atomic.AddUint64(&p.next, 1)
+		picked = healthy[(n-1)%uint64(len(healthy))]
+	case PolicyLeastInflight:
+		for _, u := range healthy[1:] {
+			if atomic.LoadInt64(&u.inflight) < atomic.LoadInt64(&picked.inflight) {
+				picked = u
+			}
+		}
+	}
+	return picked, picked.conn, nil
+}
+
+// eject 将节点标记为不可用, 等待探活恢复
+func (p *upstreamPool) eject(u *upstream, reason string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !u.healthy {
+		u.reason = reason
+		return
+	}
+	u.healthy = false
+	u.reason = reason
+	p.log.Warn("UpstreamPool: eject upstream", "host", u.host, "reason", reason)
+	p.logLive()
+}
+
+// markDown 转发时stream出错, 摘除节点并在failureEjectDuration内不被探活恢复
+func (p *upstreamPool) markDown(u *upstream, reason string) {
+	p.mutex.Lock()
+	u.ejectedUntil = time.Now().Add(failureEjectDuration)
+	p.mutex.Unlock()
+	p.eject(u, reason)
+}
+
+// checkAll 对所有节点探活, 失败的节点摘除, 恢复的节点重新加入
+func (p *upstreamPool) checkAll() {
+	for _, u := range p.upstreams {
+		p.check(u)
+	}
+}
+
+func (p *upstreamPool) check(u *upstream) {
+	if err := p.probe(u.host); err != nil {
+		p.eject(u, "probe failed: "+err.Error())
+		return
+	}
+	p.mutex.RLock()
+	conn := u.conn
+	p.mutex.RUnlock()
+
+	// 连接不可用时需要重建
+	if conn == nil || conn.GetState() == connectivity.TransientFailure || conn.GetState() == connectivity.Shutdown {
+		state := "nil"
+		if conn != nil {
+			state = conn.GetState().String()
+		}
+		p.eject(u, "conn state "+state)
+		newConn, err := p.dial(u.host)
+		if err != nil {
+			p.eject(u, "dial failed: "+err.Error())
+			return
+		}
+		p.mutex.Lock()
+		u.conn = newConn
+		p.mutex.Unlock()
+		if conn != nil {
+			conn.Close()
+		}
+		p.log.Info("UpstreamPool: connection re-build.", "host", u.host)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !u.healthy {
+		if time.Now().Before(u.ejectedUntil) {
+			return
+		}
+		u.healthy = true
+		p.log.Info("UpstreamPool: upstream recovered", "host", u.host, "last_reason", u.reason)
+		u.reason = ""
+		p.logLive()
+	}
+}
+
+// logLive 输出当前池状态, 调用方需持有锁
+func (p *upstreamPool) logLive() {
+	var live, ejected []string
+	for _, u := range p.upstreams {
+		if u.healthy {
+			live = append(live, u.host)
+		} else {
+			ejected = append(ejected, u.host+"("+u.reason+")")
+		}
+	}
+	p.log.Info("UpstreamPool: state", "policy", p.policy, "live", live, "ejected", ejected)
+}
+
+// healthCheck 定时探活, 直到池被关闭
+func (p *upstreamPool) healthCheck(interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.close:
+				return
+			case <-ticker.C:
+				p.checkAll()
+			}
+		}
+	}()
+}
+
+// reconnectAll 重建所有节点的连接, 用于证书更新后使用新证书握手, 旧连接延迟关闭以便在途请求完成
+func (p *upstreamPool) reconnectAll() {
+	for _, u := range p.upstreams {
+		conn, err := p.dial(u.host)
+		if err != nil {
+			p.eject(u, "dial failed: "+err.Error())
+			continue
+		}
+		p.mutex.Lock()
+		old := u.conn
+		u.conn = conn
+		p.mutex.Unlock()
+		if old != nil {
+			time.AfterFunc(reconnectCloseDelay, func() {
+				old.Close()
+			})
+		}
+		p.log.Info("UpstreamPool: connection re-build.", "host", u.host)
+	}
+}
+
+// states 返回所有节点的当前状态
+func (p *upstreamPool) states() []UpstreamState {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	var ret []UpstreamState
+	for _, u := range p.upstreams {
+		state := UpstreamState{
+			Host:     u.host,
+			Healthy:  u.healthy,
+			Reason:   u.reason,
+			Inflight: atomic.LoadInt64(&u.inflight),
+		}
+		if u.conn != nil {
+			state.ConnState = u.conn.GetState().String()
+		}
+		ret = append(ret, state)
+	}
+	return ret
+}
+
+func (p *upstreamPool) closeAll() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	select {
+	case <-p.close:
+	default:
+		close(p.close)
+	}
+	for _, u := range p.upstreams {
+		if u.conn != nil {
+			u.conn.Close()
+		}
+	}
+}
+
+// tcpProbe 通过建立tcp连接判断节点是否存活
+func tcpProbe(host string) error {
+	conn, err := net.DialTimeout("tcp", host, TimeoutDuration)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
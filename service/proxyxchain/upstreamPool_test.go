@@ -0,0 +1,147 @@
+package proxyxchain
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Error(msg string, ctx ...interface{}) {}
+func (nopLogger) Warn(msg string, ctx ...interface{})  {}
+func (nopLogger) Info(msg string, ctx ...interface{})  {}
+func (nopLogger) Trace(msg string, ctx ...interface{}) {}
+
+func testDial(host string) (*grpc.ClientConn, error) {
+	return grpc.Dial(host, grpc.WithInsecure())
+}
+
+func newTestPool(t *testing.T, policy string, probe probeFunc) *upstreamPool {
+	pool, err := newUpstreamPool([]string{"127.0.0.1:1", "127.0.0.1:2", "127.0.0.1:3"}, policy, testDial, probe, nopLogger{})
+	if err != nil {
+		t.Fatalf("newUpstreamPool error: %v", err)
+	}
+	return pool
+}
+
+func TestPrimaryBackup(t *testing.T) {
+	pool := newTestPool(t, PolicyPrimaryBackup, func(host string) error { return nil })
+	defer pool.closeAll()
+	u, _, err := pool.pick()
+	if err != nil || u.host != "127.0.0.1:1" {
+		t.Errorf("pick primary error, host = %v, err = %v", u, err)
+	}
+	pool.eject(u, "test")
+	u, _, err = pool.pick()
+	if err != nil || u.host != "127.0.0.1:2" {
+		t.Errorf("pick backup error, host = %v, err = %v", u, err)
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	pool := newTestPool(t, PolicyRoundRobin, func(host string) error { return nil })
+	defer pool.closeAll()
+	seen := make(map[string]int)
+	for i := 0; i < 6; i++ {
+		u, _, err := pool.pick()
+		if err != nil {
+			t.Fatalf("pick error: %v", err)
+		}
+		seen[u.host]++
+	}
+	for host, n := range seen {
+		if n != 2 {
+			t.Errorf("round robin not balanced, host = %s, n = %d", host, n)
+		}
+	}
+}
+
+func TestLeastInflight(t *testing.T) {
+	pool := newTestPool(t, PolicyLeastInflight, func(host string) error { return nil })
+	defer pool.closeAll()
+	pool.upstreams[0].acquire()
+	pool.upstreams[1].acquire()
+	u, _, err := pool.pick()
+	if err != nil || u.host != "127.0.0.1:3" {
+		t.Errorf("pick least inflight error, host = %v, err = %v", u, err)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	down := map[string]bool{"127.0.0.1:1": true}
+	pool := newTestPool(t, PolicyPrimaryBackup, func(host string) error {
+		if down[host] {
+			return errors.New("refused")
+		}
+		return nil
+	})
+	defer pool.closeAll()
+	pool.checkAll()
+	if pool.upstreams[0].healthy {
+		t.Errorf("probe failed upstream should be ejected")
+	}
+	delete(down, "127.0.0.1:1")
+	pool.checkAll()
+	if !pool.upstreams[0].healthy {
+		t.Errorf("recovered upstream should be healthy")
+	}
+	if _, err := newUpstreamPool(nil, "", testDial, nil, nopLogger{}); err != ErrNoUpstream {
+		t.Errorf("empty hosts should fail, err = %v", err)
+	}
+}
+
+func TestMarkDownOnStreamError(t *testing.T) {
+	pool := newTestPool(t, PolicyPrimaryBackup, func(host string) error { return nil })
+	defer pool.closeAll()
+	cli := &XchainP2pProxy{pool: pool, log: nopLogger{}}
+	u := pool.upstreams[0]
+
+	// 调用方取消和正常结束不算节点故障
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cli.streamFailed(ctx, u, "send", errors.New("canceled"))
+	cli.streamFailed(context.Background(), u, "recv", io.EOF)
+	if !u.healthy {
+		t.Fatalf("upstream should stay healthy")
+	}
+	// 节点返回的业务错误不摘除节点
+	cli.streamFailed(context.Background(), u, "recv", status.Error(codes.InvalidArgument, "invalid message"))
+	cli.streamFailed(context.Background(), u, "recv", status.Error(codes.PermissionDenied, "auth failed"))
+	if !u.healthy {
+		t.Fatalf("upstream should stay healthy on application errors")
+	}
+	cli.streamFailed(context.Background(), u, "recv", status.Error(codes.Unavailable, "connection refused"))
+	if u.healthy {
+		t.Fatalf("upstream should be ejected on Unavailable")
+	}
+	u.ejectedUntil = time.Now().Add(-time.Second)
+	pool.checkAll()
+	if !u.healthy {
+		t.Fatalf("upstream should recover after cooldown")
+	}
+
+	cli.streamFailed(context.Background(), u, "recv", errors.New("transport is closing"))
+	if u.healthy {
+		t.Fatalf("upstream should be ejected on stream error")
+	}
+	// tcp探活成功也不会立即恢复
+	pool.checkAll()
+	if u.healthy {
+		t.Fatalf("upstream ejected by stream error should not recover before cooldown")
+	}
+	if picked, _, _ := pool.pick(); picked == u {
+		t.Fatalf("ejected upstream should not be picked")
+	}
+	u.ejectedUntil = time.Now().Add(-time.Second)
+	pool.checkAll()
+	if !u.healthy {
+		t.Fatalf("upstream should recover after cooldown")
+	}
+}
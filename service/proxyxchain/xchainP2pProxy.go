@@ -14,6 +14,8 @@ import (
 	util_cert "github.com/xuperchain/xuper-front/util/cert"
 	p2p "github.com/xuperchain/xupercore/protos"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 const (
@@ -24,147 +26,191 @@ const (
 var TimeoutDuration = 3 * time.Second
 
 type XchainP2pProxy struct {
-	conn *grpc.ClientConn
+	pool *upstreamPool
 	log  logs.Logger
 }
 
 var (
-	xchainProxy *XchainP2pProxy
-	proxyMtx    sync.Mutex
+	// xchainProxies net => 代理该网络xchain节点的客户端
+	xchainProxies = make(map[string]*XchainP2pProxy)
+	proxyMtx      sync.Mutex
 )
 
+// GetXchainP2pProxy 获取默认网络的xchain节点代理
 func GetXchainP2pProxy() *XchainP2pProxy {
+	return GetNetP2pProxy(config.GetNet())
+}
+
+// GetNetP2pProxy 获取网络的xchain节点代理, 首次使用时按该网络的hosts建立连接池
+func GetNetP2pProxy(net string) *XchainP2pProxy {
 	proxyMtx.Lock()
 	defer proxyMtx.Unlock()
-	if xchainProxy == nil {
-		//初始化
-		var conn *grpc.ClientConn
-		var err error
-		log, err := logs.NewLogger("XchainP2pProxy")
-		if err != nil {
-			return nil
-		}
-		if config.GetCaConfig().CaSwitch {
-			creds, err := util_cert.GenCreds()
-			if err != nil {
-				log.Error("XchainP2pProxy.GetXchainP2pProxy: InitCA GenCreds failed", "err", err)
-				return nil
-			}
-			conn, err = grpc.Dial(config.GetXchainServer().Host, grpc.WithTransportCredentials(creds), grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(maxMessageSize), grpc.MaxCallSendMsgSize(maxMessageSize)))
-			if err != nil {
-				log.Error("XchainP2pProxy.GetXchainP2pProxy: InitCA Dial failed", "err", err)
-				return nil
-			}
-		} else {
-			conn, err = grpc.Dial(config.GetXchainServer().Host, grpc.WithInsecure(), grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(maxMessageSize), grpc.MaxCallSendMsgSize(maxMessageSize)))
-			if err != nil {
-				log.Error("XchainP2pProxy.GetXchainP2pProxy: Init Dial failed", "err", err)
-				return nil
-			}
-		}
-
-		xchainProxy = &XchainP2pProxy{
-			conn: conn,
-			log:  log,
-		}
+	if xchainProxy, ok := xchainProxies[net]; ok {
 		return xchainProxy
 	}
+	//初始化
+	log, err := logs.NewLogger("XchainP2pProxy")
+	if err != nil {
+		return nil
+	}
+	// xchainProxy的连接由连接池维护, 池内定时探活并重建失效的连接
+	pool, err := newUpstreamPool(config.GetNetConfig(net).Hosts, config.GetXchainServer().UpstreamPolicy, dialer(net), tcpProbe, log)
+	if err != nil {
+		log.Error("XchainP2pProxy.GetNetP2pProxy: init upstream pool failed", "err", err, "net", net)
+		return nil
+	}
+	pool.healthCheck(time.Duration(config.GetXchainServer().HealthCheckInterval) * time.Second)
+	if config.GetCaConfig().CaSwitch {
+		// 证书更新后重建连接, 使节点看到新证书
+		util_cert.OnReload(net, pool.reconnectAll)
+	}
+	xchainProxy := &XchainP2pProxy{
+		pool: pool,
+		log:  log,
+	}
+	xchainProxies[net] = xchainProxy
+	return xchainProxy
+}
+
+// LookupNetP2pProxy 获取网络已建立的xchain节点代理, 未建立时返回nil, 不会新建连接池
+func LookupNetP2pProxy(net string) *XchainP2pProxy {
+	proxyMtx.Lock()
+	defer proxyMtx.Unlock()
+	return xchainProxies[net]
+}
 
-	// xchainProxy.conn需要一直维持一个链接
-	connState := xchainProxy.conn.GetState().String()
-	if connState == "TRANSIENT_FAILURE" || connState == "SHUTDOWN" || connState == "Invalid-State" {
-		//重置
-		var conn *grpc.ClientConn
-		var err error
+// dialer 返回建立到网络xchain节点连接的函数, 内部判断caSwitch, 使用本节点在该网络的证书
+func dialer(net string) dialFunc {
+	return func(host string) (*grpc.ClientConn, error) {
 		if config.GetCaConfig().CaSwitch {
-			creds, err := util_cert.GenCreds()
-			if err != nil {
-				xchainProxy.log.Error("XchainP2pProxy.GetXchainP2pProxy: CA failed", "err", err)
-				return nil
-			}
-			conn, err = grpc.Dial(config.GetXchainServer().Host, grpc.WithTransportCredentials(creds), grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(maxMessageSize), grpc.MaxCallSendMsgSize(maxMessageSize)))
-			if err != nil {
-				xchainProxy.log.Error("XchainP2pProxy.GetXchainP2pProxy: CA Dail failed", "err", err)
-				return nil
-			}
-		} else {
-			conn, err = grpc.Dial(config.GetXchainServer().Host, grpc.WithInsecure(), grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(maxMessageSize), grpc.MaxCallSendMsgSize(maxMessageSize)))
+			creds, err := util_cert.GenCreds(net)
 			if err != nil {
-				xchainProxy.log.Error("XchainP2pProxy.GetXchainP2pProxy: Dial failed", "err", err)
-				return nil
+				return nil, err
 			}
+			return grpc.Dial(host, grpc.WithTransportCredentials(creds), grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(maxMessageSize), grpc.MaxCallSendMsgSize(maxMessageSize)))
 		}
-		xchainProxy.Defer()
-		xchainProxy.conn = conn
-		xchainProxy.log.Info("XchainP2pProxy: connection re-build.")
+		return grpc.Dial(host, grpc.WithInsecure(), grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(maxMessageSize), grpc.MaxCallSendMsgSize(maxMessageSize)))
 	}
-
-	return xchainProxy
 }
 
-// @todo 复用一个conn 会出问题, 后面再排查下原因吧
 func (cli *XchainP2pProxy) Defer() {
 	cli.log.Info("XchainP2pProxy: close connection.")
-	cli.conn.Close()
+	cli.pool.closeAll()
+}
+
+// Upstreams 返回连接池中所有节点的状态
+func (cli *XchainP2pProxy) Upstreams() []UpstreamState {
+	return cli.pool.states()
 }
 
-func (cli *XchainP2pProxy) newClient() (p2p.P2PServiceClient, error) {
-	client := p2p.NewP2PServiceClient(cli.conn)
-	return client, nil
+// openStream 在一个健康的节点上打开stream, 失败时摘除该节点并切换到下一个
+func (cli *XchainP2pProxy) openStream(ctx context.Context) (p2p.P2PService_SendP2PMessageClient, *upstream, error) {
+	for i := 0; i < len(cli.pool.upstreams); i++ {
+		u, conn, err := cli.pool.pick()
+		if err != nil {
+			return nil, nil, err
+		}
+		stream, err := p2p.NewP2PServiceClient(conn).SendP2PMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, nil, err
+			}
+			cli.pool.eject(u, "open stream failed: "+err.Error())
+			continue
+		}
+		u.acquire()
+		return stream, u, nil
+	}
+	return nil, nil, ErrNoHealthyUpstream
 }
 
 // SendMessage send message to a peer
 func (cli *XchainP2pProxy) SendMessage(ctx context.Context, msg *p2p.XuperMessage) error {
-	client, err := cli.newClient()
-	if err != nil {
-		cli.log.Error("XchainP2pProxy.SendMessage: newClient error", "err", err)
-		return err
-	}
-	stream, err := client.SendP2PMessage(ctx)
+	stream, u, err := cli.openStream(ctx)
 	if err != nil {
 		cli.log.Error("XchainP2pProxy.SendMessage: SendP2PMessage error", "err", err)
 		return err
 	}
+	defer u.release()
 	defer stream.CloseSend()
 	err = stream.Send(msg)
 	if err != nil {
 		cli.log.Error("XchainP2pProxy.SendMessage: Send error", "err", err)
+		cli.streamFailed(ctx, u, "send", err)
 		return err
 	}
-	if err == io.EOF {
-		cli.log.Error("XchainP2pProxy.SendMessage: Send EOF.")
-		return nil
-	}
 	// wait for server
-	stream.Recv()
-	return err
+	if _, err := stream.Recv(); err != nil && err != io.EOF {
+		cli.streamFailed(ctx, u, "recv", err)
+	}
+	return nil
+}
+
+// streamFailed 节点上的stream出现传输层错误时摘除该节点, 调用方取消和节点返回的业务错误不算节点故障
+func (cli *XchainP2pProxy) streamFailed(ctx context.Context, u *upstream, stage string, err error) {
+	if ctx.Err() != nil || err == io.EOF || !isTransportError(err) {
+		return
+	}
+	cli.pool.markDown(u, stage+" failed: "+err.Error())
+}
+
+// isTransportError Unavailable或者非grpc status的连接错误才是节点故障
+func isTransportError(err error) bool {
+	st, ok := status.FromError(err)
+	return !ok || st.Code() == codes.Unavailable
 }
 
 // SendMessageWithResponse send message to a peer with responce
 func (cli *XchainP2pProxy) SendMessageWithResponse(ctx context.Context, msg *p2p.XuperMessage) (*p2p.XuperMessage, error) {
-	// front proxy作为一个客户端向它直连的xchain host请求消息，并期待xchain host的返回
-	client, err := cli.newClient()
+	var resp *p2p.XuperMessage
+	err := cli.SendMessageWithResponses(ctx, msg, func(ret *p2p.XuperMessage) error {
+		if resp == nil {
+			resp = ret
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	stream, err := client.SendP2PMessage(ctx)
+	if resp == nil {
+		return nil, io.EOF
+	}
+	return resp, nil
+}
+
+// SendMessageWithResponses send message to a peer, and relay every responce of the peer until it closes the stream
+func (cli *XchainP2pProxy) SendMessageWithResponses(ctx context.Context, msg *p2p.XuperMessage, reply func(*p2p.XuperMessage) error) error {
+	// front proxy作为一个客户端向它直连的xchain host请求消息，并期待xchain host的返回
+	stream, u, err := cli.openStream(ctx)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer stream.CloseSend()
+	defer u.release()
 
 	err = stream.Send(msg)
 	if err != nil {
 		cli.log.Error("SendMessageWithResponse error", "log_id", msg.GetHeader().GetLogid(), "error", err)
-		return nil, err
+		stream.CloseSend()
+		cli.streamFailed(ctx, u, "send", err)
+		return err
 	}
+	// 节点只处理stream上的第一条消息, 发送完即可关闭写端
+	stream.CloseSend()
 
-	resp, err := stream.Recv()
-	if err != nil {
-		cli.log.Error("SendMessageWithResponse Recv error", "log_id", resp.GetHeader().GetLogid(), "error", err.Error(), "from", msg.GetHeader().From,
-			"type", msg.Header.Type)
-		return nil, err
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			cli.log.Error("SendMessageWithResponse Recv error", "log_id", msg.GetHeader().GetLogid(), "error", err.Error(), "from", msg.GetHeader().From,
+				"type", msg.Header.Type)
+			cli.streamFailed(ctx, u, "recv", err)
+			return err
+		}
+		if err := reply(resp); err != nil {
+			cli.log.Error("SendMessageWithResponse reply error", "log_id", resp.GetHeader().GetLogid(), "error", err.Error())
+			return err
+		}
 	}
-
-	return resp, err
 }
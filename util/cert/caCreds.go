@@ -0,0 +1,111 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package cert
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"strings"
+
+	"google.golang.org/grpc/credentials"
+
+	"github.com/xuperchain/xuper-front/config"
+)
+
+var ErrFingerprintMismatch = errors.New("ca server cert does not match the pinned fingerprint")
+
+// GenCaCreds 生成访问ca的tls凭证, 按配置校验ca服务端证书的证书链和指纹,
+// 开启mtls且本节点在该网络的证书已获取时出示本节点证书
+func GenCaCreds(net string, cfg config.CaTls) (credentials.TransportCredentials, error) {
+	tlsConfig := &tls.Config{
+		ServerName: cfg.ServerName,
+	}
+	if cfg.RootCert != "" {
+		data, err := ioutil.ReadFile(cfg.RootCert)
+		if err != nil {
+			return nil, err
+		}
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(data) {
+			return nil, errors.New("no certificate found in " + cfg.RootCert)
+		}
+		tlsConfig.RootCAs = certPool
+	}
+	if cfg.Fingerprint != "" {
+		fingerprint, err := parseFingerprint(cfg.Fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		// 只固定指纹时ca证书可以是自签名的, 由指纹代替证书链校验
+		tlsConfig.InsecureSkipVerify = cfg.RootCert == ""
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyFingerprint(rawCerts, fingerprint)
+		}
+	}
+	if cfg.Mtls {
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return getCaClientCertificate(net)
+		}
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// getCaClientCertificate 出示本节点证书, 尚未获取证书时不出示, 以便首次从ca获取证书
+func getCaClientCertificate(net string) (*tls.Certificate, error) {
+	p := providerOf(net)
+	if !p.loaded() {
+		Reload(net)
+	}
+	if certificate, err := p.GetCertificate(nil); err == nil {
+		return certificate, nil
+	}
+	return &tls.Certificate{}, nil
+}
+
+// parseFingerprint 解析十六进制的sha256指纹, 忽略冒号和大小写
+func parseFingerprint(fingerprint string) ([]byte, error) {
+	data, err := hex.DecodeString(strings.Replace(fingerprint, ":", "", -1))
+	if err != nil || len(data) != sha256.Size {
+		return nil, errors.New("invalid sha256 fingerprint " + fingerprint)
+	}
+	return data, nil
+}
+
+// verifyFingerprint 校验服务端证书的sha256指纹
+func verifyFingerprint(rawCerts [][]byte, fingerprint []byte) error {
+	if len(rawCerts) == 0 {
+		return ErrFingerprintMismatch
+	}
+	sum := sha256.Sum256(rawCerts[0])
+	if !bytes.Equal(sum[:], fingerprint) {
+		return ErrFingerprintMismatch
+	}
+	return nil
+}
+
+// GenCaServerCreds 本地ca服务的tls凭证, 使用本节点在默认网络的证书, 客户端可不出示证书以便首次获取证书
+func GenCaServerCreds() (credentials.TransportCredentials, error) {
+	provider := providerOf(config.GetNet())
+	if !provider.loaded() {
+		if err := Reload(provider.net); err != nil {
+			return nil, err
+		}
+	}
+	return credentials.NewTLS(&tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			tlsConfig, err := provider.tlsConfig("")
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+			tlsConfig.NextProtos = []string{"h2"}
+			return tlsConfig, nil
+		},
+	}), nil
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package cert
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xuperchain/xuper-front/config"
+)
+
+// startTlsServer 使用自签名证书的tls服务, 返回listener和证书
+func startTlsServer(t *testing.T) (net.Listener, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "caserver"},
+		DNSNames:     []string{"caserver"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+	return lis, der
+}
+
+func handshake(t *testing.T, addr string, cfg config.CaTls) error {
+	creds, err := GenCaCreds("", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawConn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rawConn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, _, err = creds.ClientHandshake(ctx, "caserver", rawConn)
+	return err
+}
+
+func TestCaCredsFingerprint(t *testing.T) {
+	lis, der := startTlsServer(t)
+	defer lis.Close()
+	addr := lis.Addr().String()
+	sum := sha256.Sum256(der)
+
+	// 自签名证书未固定指纹时校验失败
+	if err := handshake(t, addr, config.CaTls{Enable: true}); err == nil {
+		t.Fatal("self-signed ca cert should be rejected")
+	}
+	if err := handshake(t, addr, config.CaTls{Enable: true, Fingerprint: hex.EncodeToString(sum[:])}); err != nil {
+		t.Fatalf("pinned ca cert should be accepted: %v", err)
+	}
+	sum[0] ^= 0xff
+	if err := handshake(t, addr, config.CaTls{Enable: true, Fingerprint: hex.EncodeToString(sum[:])}); err == nil {
+		t.Fatal("fingerprint mismatch should be rejected")
+	}
+	if _, err := GenCaCreds("", config.CaTls{Enable: true, Fingerprint: "zz"}); err == nil {
+		t.Fatal("invalid fingerprint should fail")
+	}
+}
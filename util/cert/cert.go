@@ -4,13 +4,17 @@
 package cert
 
 import (
+	"bytes"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/pem"
+	"errors"
 	"io/ioutil"
 
 	"google.golang.org/grpc/credentials"
 
 	"github.com/xuperchain/xuper-front/config"
+	"github.com/xuperchain/xuper-front/util/keystore"
 )
 
 const CACERT = "cacert.pem"
@@ -18,35 +22,146 @@ const CERT = "cert.pem"
 const PRIVATEKEY = "private.key"
 const NODEHDPRIKEY = "hd_private.key"
 
-func GenCreds() (credentials.TransportCredentials, error) {
-	crt, err := ioutil.ReadFile(config.GetConfig().XchainServer.TlsPath + "/" + CACERT)
+// LoadCert 读取并解析网络tlsPath下的节点证书
+func LoadCert(net string) (*x509.Certificate, error) {
+	return ParseCertFile(config.GetNetConfig(net).TlsPath + CERT)
+}
+
+// ParseCertFile 解析PEM格式的证书文件, 返回其中第一个证书
+func ParseCertFile(filename string) (*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
-	certPool := x509.NewCertPool()
-	ok := certPool.AppendCertsFromPEM(crt)
-	if !ok {
-		return nil, err
+	return ParseCert(data)
+}
+
+// ParseCert 解析PEM格式的证书, 返回其中第一个证书
+func ParseCert(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no certificate found")
 	}
-	certificate, err := tls.LoadX509KeyPair(config.GetConfig().XchainServer.TlsPath+"/"+CERT,
-		config.GetConfig().XchainServer.TlsPath+"/"+PRIVATEKEY)
+	return ParseCertificate(block.Bytes)
+}
 
-	//cn := config.GetNet() + ".server.com"
-	cn := config.GetNet()
+// ParseCerts 解析PEM格式的证书链, 返回其中所有证书
+func ParseCerts(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate found")
+	}
+	return certs, nil
+}
 
-	var tlsCOnfig *tls.Config
+// VerifyKeyPair 校验证书与私钥是否匹配
+func VerifyKeyPair(certPEM, keyPEM []byte) error {
+	_, err := tls.X509KeyPair(certPEM, keyPEM)
+	return err
+}
 
-	tlsCOnfig = &tls.Config{
-		ServerName:   cn,
-		Certificates: []tls.Certificate{certificate},
-		RootCAs:      certPool,
-		ClientCAs:    certPool,
-		ClientAuth:   tls.RequireAndVerifyClientCert,
+// GenCreds 生成网络的grpc tls凭证, 证书通过GetCertificate/GetClientCertificate回调从provider获取,
+// 调用Reload后新建立的连接即使用新证书
+func GenCreds(net string) (credentials.TransportCredentials, error) {
+	if !providerOf(net).loaded() {
+		if err := Reload(net); err != nil {
+			return nil, err
+		}
 	}
+	return &reloadableCreds{
+		net: net,
+	}, nil
+}
 
-	creds := credentials.NewTLS(
-		tlsCOnfig,
-	)
+// GenServerCreds 生成代理服务端的tls凭证, 握手时根据SNI选择网络的证书
+func GenServerCreds() (credentials.TransportCredentials, error) {
+	for _, n := range config.GetNets() {
+		if providerOf(n.Name).loaded() {
+			continue
+		}
+		if err := Reload(n.Name); err != nil {
+			return nil, err
+		}
+	}
+	return &serverCreds{}, nil
+}
 
-	return creds, nil
+// Reload 重新读取网络tlsPath下的证书和私钥, 失败时保留当前证书
+func Reload(net string) error {
+	crt, err := ioutil.ReadFile(config.GetNetConfig(net).TlsPath + CACERT)
+	if err != nil {
+		return err
+	}
+	certPool := x509.NewCertPool()
+	ok := certPool.AppendCertsFromPEM(crt)
+	if !ok {
+		return errors.New("no certificate found in " + CACERT)
+	}
+	certificate, err := LoadKeyPair(net)
+	if err != nil {
+		return err
+	}
+	providerOf(net).store(&certificate, crt, certPool)
+	return nil
+}
+
+// ReloadAll 重新加载所有网络的证书, 返回第一个错误
+func ReloadAll() error {
+	var firstErr error
+	for _, n := range config.GetNets() {
+		if err := Reload(n.Name); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// LoadKeyPair 读取网络tlsPath下的证书, 私钥通过keystore获取
+func LoadKeyPair(net string) (tls.Certificate, error) {
+	var certificate tls.Certificate
+	data, err := ioutil.ReadFile(config.GetNetConfig(net).TlsPath + CERT)
+	if err != nil {
+		return certificate, err
+	}
+	certs, err := ParseCerts(data)
+	if err != nil {
+		return certificate, err
+	}
+	signer, err := keystore.Signer(keystore.NetKey(keystore.KeyTls, net))
+	if err != nil {
+		return certificate, err
+	}
+	// 校验私钥与证书匹配
+	certPub, err := x509.MarshalPKIXPublicKey(certs[0].PublicKey)
+	if err != nil {
+		return certificate, err
+	}
+	keyPub, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return certificate, err
+	}
+	if !bytes.Equal(certPub, keyPub) {
+		return certificate, errors.New("private key does not match the cert")
+	}
+	for _, cert := range certs {
+		certificate.Certificate = append(certificate.Certificate, cert.Raw)
+	}
+	certificate.PrivateKey = signer
+	certificate.Leaf = certs[0]
+	return certificate, nil
 }
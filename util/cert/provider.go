@@ -0,0 +1,274 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package cert
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc/credentials"
+
+	"github.com/xuperchain/xuper-front/config"
+)
+
+var (
+	ErrCredsNotLoaded = errors.New("tls credentials not loaded")
+
+	// providers net => 该网络的证书provider
+	providers   = make(map[string]*credsProvider)
+	providerMtx sync.Mutex
+)
+
+// providerOf 获取某个网络的证书provider, 不存在时创建
+func providerOf(net string) *credsProvider {
+	providerMtx.Lock()
+	defer providerMtx.Unlock()
+	p, ok := providers[net]
+	if !ok {
+		p = &credsProvider{
+			net: net,
+		}
+		providers[net] = p
+	}
+	return p
+}
+
+// lookupProvider 根据SNI查找已加载证书的网络
+func lookupProvider(serverName string) (*credsProvider, bool) {
+	if serverName == "" {
+		return nil, false
+	}
+	for _, n := range config.GetNets() {
+		if n.Name == serverName {
+			p := providerOf(n.Name)
+			return p, p.loaded()
+		}
+	}
+	return nil, false
+}
+
+// credsProvider 持有一个网络当前生效的证书和根证书, 证书更新时整体替换
+type credsProvider struct {
+	net         string
+	certificate atomic.Value
+	certPool    atomic.Value
+	// caPem 根证书原文, 用于合并所有网络的根证书
+	caPem atomic.Value
+	hooks []func()
+	mutex sync.Mutex
+}
+
+func (p *credsProvider) loaded() bool {
+	return p.certificate.Load() != nil
+}
+
+func (p *credsProvider) store(certificate *tls.Certificate, caPem []byte, certPool *x509.CertPool) {
+	p.caPem.Store(caPem)
+	p.certPool.Store(certPool)
+	p.certificate.Store(certificate)
+	p.mutex.Lock()
+	hooks := p.hooks
+	p.mutex.Unlock()
+	for _, hook := range hooks {
+		hook()
+	}
+}
+
+func (p *credsProvider) pool() *x509.CertPool {
+	certPool, _ := p.certPool.Load().(*x509.CertPool)
+	return certPool
+}
+
+// GetCertificate 服务端握手时返回当前证书
+func (p *credsProvider) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if certificate, ok := p.certificate.Load().(*tls.Certificate); ok {
+		return certificate, nil
+	}
+	return nil, ErrCredsNotLoaded
+}
+
+// GetClientCertificate 客户端握手时返回当前证书
+func (p *credsProvider) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return p.GetCertificate(nil)
+}
+
+// tlsConfig 生成一次握手使用的tls配置, 根证书取当前加载的cacert
+func (p *credsProvider) tlsConfig(serverName string) (*tls.Config, error) {
+	certPool := p.pool()
+	if certPool == nil {
+		return nil, ErrCredsNotLoaded
+	}
+	if serverName == "" {
+		//cn := config.GetNet() + ".server.com"
+		serverName = p.net
+	}
+	return &tls.Config{
+		ServerName:           serverName,
+		GetCertificate:       p.GetCertificate,
+		GetClientCertificate: p.GetClientCertificate,
+		RootCAs:              certPool,
+		ClientCAs:            certPool,
+		ClientAuth:           tls.RequireAndVerifyClientCert,
+	}, nil
+}
+
+// OnReload 注册某个网络的证书重新加载后的回调
+func OnReload(net string, hook func()) {
+	p := providerOf(net)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.hooks = append(p.hooks, hook)
+}
+
+// allCertPools 合并所有网络的根证书, 用于接受任意网络签发的客户端证书
+func allCertPools() *x509.CertPool {
+	certPool := x509.NewCertPool()
+	for _, n := range config.GetNets() {
+		if caPem, ok := providerOf(n.Name).caPem.Load().([]byte); ok {
+			certPool.AppendCertsFromPEM(caPem)
+		}
+	}
+	return certPool
+}
+
+// serverConfigForClient 根据SNI选择网络的证书, 并只接受该网络签发的客户端证书
+// SNI不是已知网络时使用默认网络的证书, 接受任意网络签发的客户端证书, 由PeerNet按签发者判断网络
+func serverConfigForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	p, ok := lookupProvider(hello.ServerName)
+	clientCAs := allCertPools()
+	if ok {
+		clientCAs = p.pool()
+	} else {
+		p = providerOf(config.GetNet())
+	}
+	certificate, err := p.GetCertificate(hello)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{*certificate},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		NextProtos:   []string{"h2"},
+	}, nil
+}
+
+// PeerNet 判断tls对端所属的网络, 优先使用握手的SNI, 其次使用对端证书的签发者
+func PeerNet(state tls.ConnectionState) string {
+	if p, ok := lookupProvider(state.ServerName); ok {
+		return p.net
+	}
+	if len(state.PeerCertificates) > 0 {
+		intermediates := x509.NewCertPool()
+		for _, cert := range state.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		for _, n := range config.GetNets() {
+			certPool := providerOf(n.Name).pool()
+			if certPool == nil {
+				continue
+			}
+			_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+				Roots:         certPool,
+				Intermediates: intermediates,
+				KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+			})
+			if err == nil {
+				return n.Name
+			}
+		}
+	}
+	return config.GetNet()
+}
+
+////////////// reloadableCreds ///////////////
+
+// reloadableCreds 每次握手时使用所属网络provider当前的证书, 已建立的连接不受影响
+type reloadableCreds struct {
+	net        string
+	serverName string
+}
+
+func (c *reloadableCreds) tlsCreds() (credentials.TransportCredentials, error) {
+	tlsConfig, err := providerOf(c.net).tlsConfig(c.serverName)
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+func (c *reloadableCreds) ClientHandshake(ctx context.Context, authority string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	creds, err := c.tlsCreds()
+	if err != nil {
+		return nil, nil, err
+	}
+	return creds.ClientHandshake(ctx, authority, rawConn)
+}
+
+func (c *reloadableCreds) ServerHandshake(rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	creds, err := c.tlsCreds()
+	if err != nil {
+		return nil, nil, err
+	}
+	return creds.ServerHandshake(rawConn)
+}
+
+func (c *reloadableCreds) Info() credentials.ProtocolInfo {
+	serverName := c.serverName
+	if serverName == "" {
+		serverName = c.net
+	}
+	return credentials.ProtocolInfo{
+		SecurityProtocol: "tls",
+		SecurityVersion:  "1.2",
+		ServerName:       serverName,
+	}
+}
+
+func (c *reloadableCreds) Clone() credentials.TransportCredentials {
+	return &reloadableCreds{
+		net:        c.net,
+		serverName: c.serverName,
+	}
+}
+
+func (c *reloadableCreds) OverrideServerName(serverNameOverride string) error {
+	c.serverName = serverNameOverride
+	return nil
+}
+
+////////////// serverCreds ///////////////
+
+// serverCreds 代理服务端的凭证, 每次握手根据SNI选择网络的证书
+type serverCreds struct{}
+
+func (c *serverCreds) ClientHandshake(context.Context, string, net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, errors.New("server credentials can not be used by client")
+}
+
+func (c *serverCreds) ServerHandshake(rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return credentials.NewTLS(&tls.Config{
+		GetConfigForClient: serverConfigForClient,
+	}).ServerHandshake(rawConn)
+}
+
+func (c *serverCreds) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{
+		SecurityProtocol: "tls",
+		SecurityVersion:  "1.2",
+	}
+}
+
+func (c *serverCreds) Clone() credentials.TransportCredentials {
+	return &serverCreds{}
+}
+
+func (c *serverCreds) OverrideServerName(string) error {
+	return nil
+}
@@ -0,0 +1,258 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package cert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+
+	"github.com/xuperchain/xuper-front/config"
+)
+
+// testNet 一个网络的ca和本节点证书
+type testNet struct {
+	name    string
+	caPool  *x509.CertPool
+	keyPair tls.Certificate
+}
+
+func newCertificate(t *testing.T, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// writeTestNet 为网络生成ca和节点证书, 写入dir
+func writeTestNet(t *testing.T, name string, dir string) *testNet {
+	ca, caKey := newCertificate(t, name+"-ca", nil, nil)
+	cert, key := newCertificate(t, name, ca, caKey)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	os.MkdirAll(dir, 0755)
+	ioutil.WriteFile(filepath.Join(dir, CACERT), caPem, 0644)
+	ioutil.WriteFile(filepath.Join(dir, CERT), certPem, 0644)
+	ioutil.WriteFile(filepath.Join(dir, PRIVATEKEY), keyPem, 0600)
+	keyPair, err := tls.X509KeyPair(certPem, keyPem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caPool := x509.NewCertPool()
+	caPool.AddCert(ca)
+	return &testNet{
+		name:    name,
+		caPool:  caPool,
+		keyPair: keyPair,
+	}
+}
+
+// serverHandshake 使用代理服务端凭证完成一次握手, 返回对端所属的网络
+func serverHandshake(lis net.Listener) <-chan string {
+	ret := make(chan string, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			ret <- ""
+			return
+		}
+		defer conn.Close()
+		creds, err := GenServerCreds()
+		if err != nil {
+			ret <- ""
+			return
+		}
+		_, authInfo, err := creds.ServerHandshake(conn)
+		if err != nil {
+			ret <- ""
+			return
+		}
+		ret <- PeerNet(authInfo.(credentials.TLSInfo).State)
+	}()
+	return ret
+}
+
+func TestMultiNetServerCreds(t *testing.T) {
+	dir, err := ioutil.TempDir("", "multinet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := fmt.Sprintf(`nets:
+  - name: net1
+    tlsPath: %s/net1
+  - name: net2
+    tlsPath: %s/net2
+`, dir, dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "front.yaml"), []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.InstallFrontConfig(filepath.Join(dir, "front.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	net1 := writeTestNet(t, "net1", filepath.Join(dir, "net1"))
+	net2 := writeTestNet(t, "net2", filepath.Join(dir, "net2"))
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	dial := func(serverName string, client *testNet, roots *x509.CertPool) (string, error) {
+		peerNet := serverHandshake(lis)
+		conn, err := tls.Dial("tcp", lis.Addr().String(), &tls.Config{
+			ServerName:         serverName,
+			RootCAs:            roots,
+			InsecureSkipVerify: roots == nil,
+			Certificates:       []tls.Certificate{client.keyPair},
+		})
+		if err != nil {
+			<-peerNet
+			return "", err
+		}
+		// 读取一次以等待服务端完成对客户端证书的校验
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		conn.Read(make([]byte, 1))
+		conn.Close()
+		return <-peerNet, nil
+	}
+
+	// 按SNI选择网络的证书, 客户端以该网络的根证书校验
+	for _, n := range []*testNet{net1, net2} {
+		if got, err := dial(n.name, n, n.caPool); err != nil || got != n.name {
+			t.Fatalf("sni %s: got net %q, err %v", n.name, got, err)
+		}
+	}
+	// SNI指定的网络只接受该网络签发的客户端证书
+	if got, _ := dial("net2", net1, net2.caPool); got != "" {
+		t.Fatalf("client cert of net1 should be rejected by net2, got %q", got)
+	}
+	// 没有SNI时按客户端证书的签发者判断网络
+	if got, err := dial("", net2, nil); err != nil || got != "net2" {
+		t.Fatalf("issuer of net2: got net %q, err %v", got, err)
+	}
+}
+
+// installReloadNet 使用只有一个网络的配置, 网络的tlsPath为dir/name
+func installReloadNet(t *testing.T, dir string, name string) string {
+	conf := fmt.Sprintf("nets:\n  - name: %s\n    tlsPath: %s/%s/\n", name, dir, name)
+	// viper会累积配置目录, 文件名需唯一
+	path := filepath.Join(dir, name+"_front.yaml")
+	if err := ioutil.WriteFile(path, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.InstallFrontConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, name)
+}
+
+// currentSerial provider当前证书的序列号
+func currentSerial(t *testing.T, p *credsProvider) string {
+	certificate, err := p.GetClientCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.SerialNumber.String()
+}
+
+func TestProviderReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tlsPath := installReloadNet(t, dir, "reloadnet")
+	defer config.InstallFrontConfig("../../conf/front.yaml")
+
+	first := writeTestNet(t, "reloadnet", tlsPath)
+	if err := Reload("reloadnet"); err != nil {
+		t.Fatal(err)
+	}
+	p := providerOf("reloadnet")
+	if got, err := p.GetCertificate(nil); err != nil || string(got.Certificate[0]) != string(first.keyPair.Certificate[0]) {
+		t.Fatalf("provider should serve the loaded cert: %v", err)
+	}
+	reloaded := make(chan struct{}, 1)
+	// 回调注册后不会移除, 多次运行时不能阻塞
+	OnReload("reloadnet", func() {
+		select {
+		case reloaded <- struct{}{}:
+		default:
+		}
+	})
+
+	// 文件替换后重新加载, 握手回调返回新证书并执行回调
+	second := writeTestNet(t, "reloadnet", tlsPath)
+	if err := Reload("reloadnet"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-reloaded:
+	default:
+		t.Fatal("reload hook should be called")
+	}
+	if got, _ := p.GetCertificate(nil); string(got.Certificate[0]) != string(second.keyPair.Certificate[0]) {
+		t.Fatal("provider should serve the reloaded cert")
+	}
+	serial := currentSerial(t, p)
+
+	// 私钥与证书不匹配时加载失败, 保留当前证书
+	writeTestNet(t, "othernet", filepath.Join(dir, "other"))
+	data, _ := ioutil.ReadFile(filepath.Join(dir, "other", CERT))
+	ioutil.WriteFile(filepath.Join(tlsPath, CERT), data, 0644)
+	if err := Reload("reloadnet"); err == nil {
+		t.Fatal("mismatched key pair should not be loaded")
+	}
+	if got := currentSerial(t, p); got != serial {
+		t.Fatalf("current cert should be kept, got %s", got)
+	}
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package cert
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+
+	"github.com/xuperchain/crypto/gm/gmsm/sm2"
+
+	"github.com/xuperchain/xuper-front/crypto"
+)
+
+// ParseCertificate 解析DER格式的证书, 国密时标准库无法解析的SM2证书使用国密解析
+func ParseCertificate(der []byte) (*x509.Certificate, error) {
+	cert, err := x509.ParseCertificate(der)
+	if err == nil || !crypto.IsGm() {
+		return cert, err
+	}
+	gmCert, gmErr := sm2.ParseCertificate(der)
+	if gmErr != nil {
+		return nil, err
+	}
+	return fromSm2Certificate(gmCert), nil
+}
+
+// fromSm2Certificate 将SM2证书转换为x509.Certificate, 供撤销检查和读取地址使用
+// 签名算法等标准库不支持的字段不转换, 转换后的证书不能用x509校验签名
+func fromSm2Certificate(c *sm2.Certificate) *x509.Certificate {
+	cert := &x509.Certificate{
+		Raw:                     c.Raw,
+		RawTBSCertificate:       c.RawTBSCertificate,
+		RawSubjectPublicKeyInfo: c.RawSubjectPublicKeyInfo,
+		RawSubject:              c.RawSubject,
+		RawIssuer:               c.RawIssuer,
+		Signature:               c.Signature,
+		PublicKey:               c.PublicKey,
+		Version:                 c.Version,
+		SerialNumber:            c.SerialNumber,
+		Issuer:                  c.Issuer,
+		Subject:                 c.Subject,
+		NotBefore:               c.NotBefore,
+		NotAfter:                c.NotAfter,
+		KeyUsage:                x509.KeyUsage(c.KeyUsage),
+		Extensions:              c.Extensions,
+		BasicConstraintsValid:   c.BasicConstraintsValid,
+		IsCA:                    c.IsCA,
+		MaxPathLen:              c.MaxPathLen,
+		MaxPathLenZero:          c.MaxPathLenZero,
+		SubjectKeyId:            c.SubjectKeyId,
+		AuthorityKeyId:          c.AuthorityKeyId,
+		OCSPServer:              c.OCSPServer,
+		IssuingCertificateURL:   c.IssuingCertificateURL,
+		DNSNames:                c.DNSNames,
+		EmailAddresses:          c.EmailAddresses,
+		IPAddresses:             c.IPAddresses,
+		CRLDistributionPoints:   c.CRLDistributionPoints,
+	}
+	for _, usage := range c.ExtKeyUsage {
+		cert.ExtKeyUsage = append(cert.ExtKeyUsage, x509.ExtKeyUsage(usage))
+	}
+	if pub, ok := c.PublicKey.(*sm2.PublicKey); ok {
+		cert.PublicKey = &ecdsa.PublicKey{Curve: pub.Curve, X: pub.X, Y: pub.Y}
+	}
+	return cert
+}
@@ -0,0 +1,58 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package cert
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/xuperchain/crypto/gm/gmsm/sm2"
+
+	"github.com/xuperchain/xuper-front/config"
+	"github.com/xuperchain/xuper-front/crypto"
+)
+
+func TestParseSm2Certificate(t *testing.T) {
+	if err := config.InstallFrontConfig("../../conf/front.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	key, err := sm2.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &sm2.Certificate{
+		SerialNumber:       big.NewInt(42),
+		Subject:            pkix.Name{CommonName: "node", SerialNumber: "dpzuVdosQrF2kmzumhVeFQZa1aYcdgFpN"},
+		NotBefore:          time.Now().Add(-time.Hour),
+		NotAfter:           time.Now().Add(time.Hour),
+		SignatureAlgorithm: sm2.SM2WithSM3,
+	}
+	der, err := sm2.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ecdsa下SM2证书无法解析
+	if _, err := ParseCertificate(der); err == nil {
+		t.Fatal("sm2 cert should not be parsed without gm")
+	}
+
+	config.SetCryptoType(crypto.CryptoTypeGm)
+	defer config.SetCryptoType("")
+	cert, err := ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.SerialNumber.String() != "42" || cert.Subject.SerialNumber != template.Subject.SerialNumber {
+		t.Fatalf("unexpected cert %v %s", cert.SerialNumber, cert.Subject.SerialNumber)
+	}
+	pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok || !crypto.IsSm2Key(pub) {
+		t.Fatalf("unexpected public key %T", cert.PublicKey)
+	}
+}
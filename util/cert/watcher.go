@@ -0,0 +1,92 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package cert
+
+import (
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/xuperchain/xuper-front/config"
+)
+
+// reloadDelay 文件变化后等待一段时间再加载, 避免多个文件未写完时加载
+const reloadDelay = time.Second
+
+// StartWatcher 监听每个网络tlsPath下证书文件的变化以及SIGHUP信号, 触发时重新加载证书
+// onReload 用于输出加载结果, SIGHUP时重新加载所有网络
+func StartWatcher(onReload func(net string, err error)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	// dir => 证书存放在该目录的网络
+	dirs := make(map[string][]string)
+	for _, n := range config.GetNets() {
+		dir := filepath.Clean(n.TlsPath)
+		if _, ok := dirs[dir]; !ok {
+			// 监听目录而非文件, rename方式替换的文件同样可以感知
+			if err := watcher.Add(dir); err != nil {
+				watcher.Close()
+				return err
+			}
+		}
+		dirs[dir] = append(dirs[dir], n.Name)
+	}
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+
+	go func() {
+		defer watcher.Close()
+		// 每个目录单独延迟加载
+		pending := make(map[string]bool)
+		var timer <-chan time.Time
+		reload := func(nets []string) {
+			for _, net := range nets {
+				onReload(net, Reload(net))
+			}
+		}
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !isCertFile(event.Name) || event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+					continue
+				}
+				pending[filepath.Dir(event.Name)] = true
+				timer = time.After(reloadDelay)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				onReload("", err)
+			case <-sighup:
+				for _, nets := range dirs {
+					reload(nets)
+				}
+			case <-timer:
+				timer = nil
+				for dir := range pending {
+					reload(dirs[dir])
+					delete(pending, dir)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func isCertFile(name string) bool {
+	switch filepath.Base(name) {
+	case CACERT, CERT, PRIVATEKEY:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package cert
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/xuperchain/xuper-front/config"
+)
+
+func TestWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tlsPath := installReloadNet(t, dir, "watchnet")
+	defer config.InstallFrontConfig("../../conf/front.yaml")
+	writeTestNet(t, "watchnet", tlsPath)
+	if err := Reload("watchnet"); err != nil {
+		t.Fatal(err)
+	}
+	p := providerOf("watchnet")
+	reloads := make(chan error, 8)
+	if err := StartWatcher(func(net string, err error) {
+		if net != "watchnet" {
+			return
+		}
+		// 多次运行时旧的watcher仍会回调, 不能阻塞
+		select {
+		case reloads <- err:
+		default:
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	wait := func() {
+		select {
+		case err := <-reloads:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("cert should be reloaded")
+		}
+	}
+
+	// 证书文件变化后重新加载
+	before := currentSerial(t, p)
+	writeTestNet(t, "watchnet", tlsPath)
+	wait()
+	// 写入多个文件可能触发多次加载, 以最终证书为准
+	time.Sleep(2 * reloadDelay)
+	for len(reloads) > 0 {
+		<-reloads
+	}
+	changed := currentSerial(t, p)
+	if changed == before {
+		t.Fatal("cert should be reloaded after files changed")
+	}
+
+	// SIGHUP时重新加载, 文件未变化时证书不变
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	wait()
+	if got := currentSerial(t, p); got != changed {
+		t.Fatalf("unexpected cert after SIGHUP %s", got)
+	}
+}
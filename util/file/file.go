@@ -7,6 +7,7 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/xuperchain/xuper-front/config"
@@ -25,6 +26,44 @@ func WriteFileUsingFilename(filename string, content []byte) error {
 	return err
 }
 
+// WriteFilesAtomic 先将所有文件写入临时文件, 全部写入成功后再依次rename, 避免读到写了一半的文件
+func WriteFilesAtomic(files map[string][]byte, perm os.FileMode) error {
+	tmps := make(map[string]string, len(files))
+	defer func() {
+		for _, tmp := range tmps {
+			os.Remove(tmp)
+		}
+	}()
+	for filename, content := range files {
+		tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp")
+		if err != nil {
+			return err
+		}
+		tmps[filename] = tmp.Name()
+		if _, err := tmp.Write(content); err != nil {
+			tmp.Close()
+			return err
+		}
+		if err := tmp.Sync(); err != nil {
+			tmp.Close()
+			return err
+		}
+		if err := tmp.Close(); err != nil {
+			return err
+		}
+		if err := os.Chmod(tmp.Name(), perm); err != nil {
+			return err
+		}
+	}
+	for filename, tmp := range tmps {
+		if err := os.Rename(tmp, filename); err != nil {
+			return err
+		}
+		delete(tmps, filename)
+	}
+	return nil
+}
+
 func PathExists(path string) (bool, error) {
 	_, err := os.Stat(path)
 	if err == nil {
@@ -0,0 +1,228 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package keystore
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/xuperchain/xuper-front/config"
+	util_file "github.com/xuperchain/xuper-front/util/file"
+)
+
+const (
+	// keyFilePerm 私钥文件的权限
+	keyFilePerm = 0600
+
+	defaultPassphraseEnv = "XFRONT_KEY_PASSPHRASE"
+
+	// scrypt参数
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+	saltLen      = 16
+)
+
+var ErrEmptyPassphrase = errors.New("keystore passphrase is empty")
+
+////////////// fileKeystore ///////////////
+
+// fileKeystore 明文文件, 写入时权限为0600, 读取时拒绝权限过宽的文件
+type fileKeystore struct{}
+
+func (k *fileKeystore) Read(name string) ([]byte, error) {
+	path, err := keyPath(name)
+	if err != nil {
+		return nil, err
+	}
+	return readKeyFile(path)
+}
+
+func (k *fileKeystore) Write(name string, data []byte) error {
+	path, err := keyPath(name)
+	if err != nil {
+		return err
+	}
+	return util_file.WriteFilesAtomic(map[string][]byte{path: data}, keyFilePerm)
+}
+
+func (k *fileKeystore) Signer(name string) (crypto.Signer, error) {
+	data, err := k.Read(name)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePrivateKey(data)
+}
+
+// readKeyFile 读取私钥文件, 组和其他用户可访问的文件可能已经泄露, 不自动修改权限而是报错
+func readKeyFile(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if perm := info.Mode().Perm(); perm&^keyFilePerm != 0 {
+		return nil, fmt.Errorf("key file %s has insecure permissions %04o, run `chmod 600 %s` and rotate the key if it may have been exposed", path, perm, path)
+	}
+	return ioutil.ReadFile(path)
+}
+
+////////////// encryptedKeystore ///////////////
+
+// encryptedKey 加密私钥文件的格式, 使用scrypt从口令派生密钥, aes-256-gcm加密
+type encryptedKey struct {
+	Version    int    `json:"version"`
+	Kdf        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// encryptedKeystore 口令加密的文件, 读到明文私钥时自动加密后写回
+type encryptedKeystore struct {
+	passphrase []byte
+}
+
+func newEncryptedKeystore(cfg config.Keystore) (*encryptedKeystore, error) {
+	passphrase, err := readPassphrase(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedKeystore{
+		passphrase: passphrase,
+	}, nil
+}
+
+// readPassphrase 从文件描述符或环境变量读取口令, 文件描述符只能读取一次, 读取后缓存在keystore中
+func readPassphrase(cfg config.Keystore) ([]byte, error) {
+	var passphrase string
+	if cfg.PassphraseFd > 0 {
+		f := os.NewFile(uintptr(cfg.PassphraseFd), "passphrase")
+		data, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		passphrase = string(data)
+	} else {
+		env := cfg.PassphraseEnv
+		if env == "" {
+			env = defaultPassphraseEnv
+		}
+		passphrase = os.Getenv(env)
+	}
+	passphrase = strings.TrimRight(passphrase, "\r\n")
+	if passphrase == "" {
+		return nil, ErrEmptyPassphrase
+	}
+	return []byte(passphrase), nil
+}
+
+func (k *encryptedKeystore) Read(name string) ([]byte, error) {
+	path, err := keyPath(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := readKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var key encryptedKey
+	if err := json.Unmarshal(data, &key); err != nil || key.Kdf == "" {
+		// 明文私钥, 加密后写回
+		if err := k.Write(name, data); err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+	return decryptKey(&key, k.passphrase)
+}
+
+func (k *encryptedKeystore) Write(name string, data []byte) error {
+	path, err := keyPath(name)
+	if err != nil {
+		return err
+	}
+	key, err := encryptKey(data, k.passphrase)
+	if err != nil {
+		return err
+	}
+	encrypted, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	return util_file.WriteFilesAtomic(map[string][]byte{path: encrypted}, keyFilePerm)
+}
+
+func (k *encryptedKeystore) Signer(name string) (crypto.Signer, error) {
+	data, err := k.Read(name)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePrivateKey(data)
+}
+
+func encryptKey(data []byte, passphrase []byte) (*encryptedKey, error) {
+	key := &encryptedKey{
+		Version: 1,
+		Kdf:     "scrypt",
+		N:       scryptN,
+		R:       scryptR,
+		P:       scryptP,
+		Salt:    make([]byte, saltLen),
+	}
+	if _, err := rand.Read(key.Salt); err != nil {
+		return nil, err
+	}
+	aead, err := newAead(key, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	key.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(key.Nonce); err != nil {
+		return nil, err
+	}
+	key.Ciphertext = aead.Seal(nil, key.Nonce, data, nil)
+	return key, nil
+}
+
+func decryptKey(key *encryptedKey, passphrase []byte) ([]byte, error) {
+	aead, err := newAead(key, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	data, err := aead.Open(nil, key.Nonce, key.Ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("decrypt key failed, wrong passphrase or corrupted key file")
+	}
+	return data, nil
+}
+
+func newAead(key *encryptedKey, passphrase []byte) (cipher.AEAD, error) {
+	if key.Kdf != "scrypt" {
+		return nil, errors.New("unsupported kdf " + key.Kdf)
+	}
+	derived, err := scrypt.Key(passphrase, key.Salt, key.N, key.R, key.P, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,210 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package keystore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	xcrypto "github.com/xuperchain/xuper-front/crypto"
+
+	"github.com/xuperchain/xuper-front/config"
+)
+
+// 私钥名称, file/encrypted按名称映射到文件, socket直接使用名称访问签名服务
+const (
+	// KeyAccount 账户私钥, 用于访问ca时签名, 存放在keys目录下
+	KeyAccount = "account"
+	// KeyTls tls私钥, 存放在tlsPath下
+	KeyTls = "tls"
+	// KeyHd 节点一级子私钥, 存放在tlsPath下
+	KeyHd = "hd"
+	// KeyLocalCa 本地ca模式下的根私钥, 存放在localCaPath下, 为网络和节点生成的私钥见LocalCaKey
+	KeyLocalCa = "localca"
+)
+
+// netKeySep 分隔私钥名称和网络名称, 如"tls@net1"
+const netKeySep = "@"
+
+// localCaKeySep 分隔本地ca生成的私钥名称的各部分, 如"localca:node:net1:address"
+const localCaKeySep = ":"
+
+// BackupSuffix 私钥备份的名称后缀, 如"tls@net1.bak", file/encrypted存放在原私钥文件名加.bak的文件中
+const BackupSuffix = ".bak"
+
+const (
+	TypeFile      = "file"
+	TypeEncrypted = "encrypted"
+	TypeSocket    = "socket"
+)
+
+var (
+	ErrUnknownKey     = errors.New("unknown key name")
+	ErrNotExportable  = errors.New("key is not exportable from the keystore")
+	ErrUnsupportedKey = errors.New("unsupported private key format")
+
+	store     Keystore
+	storeErr  error
+	storeOnce sync.Once
+)
+
+// Keystore 私钥存储, 所有读写私钥和使用私钥签名的地方都通过keystore
+type Keystore interface {
+	// Read 读取私钥原文, socket不支持
+	Read(name string) ([]byte, error)
+	// Write 保存私钥
+	Write(name string, data []byte) error
+	// Signer 返回私钥对应的签名器
+	Signer(name string) (crypto.Signer, error)
+}
+
+// Get 根据配置返回keystore
+func Get() (Keystore, error) {
+	storeOnce.Do(func() {
+		store, storeErr = New(config.GetKeystore())
+	})
+	return store, storeErr
+}
+
+// New 根据配置创建keystore
+func New(cfg config.Keystore) (Keystore, error) {
+	switch cfg.Type {
+	case "", TypeFile:
+		return &fileKeystore{}, nil
+	case TypeEncrypted:
+		return newEncryptedKeystore(cfg)
+	case TypeSocket:
+		return newSocketKeystore(cfg)
+	}
+	return nil, fmt.Errorf("unknown keystore type %s", cfg.Type)
+}
+
+// Signer 使用配置的keystore获取签名器
+func Signer(name string) (crypto.Signer, error) {
+	ks, err := Get()
+	if err != nil {
+		return nil, err
+	}
+	return ks.Signer(name)
+}
+
+// Read 使用配置的keystore读取私钥原文
+func Read(name string) ([]byte, error) {
+	ks, err := Get()
+	if err != nil {
+		return nil, err
+	}
+	return ks.Read(name)
+}
+
+// Write 使用配置的keystore保存私钥
+func Write(name string, data []byte) error {
+	ks, err := Get()
+	if err != nil {
+		return err
+	}
+	return ks.Write(name, data)
+}
+
+// Remove 删除file/encrypted中的私钥文件, 不存在时忽略, socket不支持
+func Remove(name string) error {
+	if config.GetKeystore().Type == TypeSocket {
+		return ErrNotExportable
+	}
+	path, err := keyPath(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// LocalCaKey 本地ca为网络和节点生成的私钥名称, 如LocalCaKey("node", net, address), 存放在localCaPath/keys/下
+func LocalCaKey(parts ...string) string {
+	return KeyLocalCa + localCaKeySep + strings.Join(parts, localCaKeySep)
+}
+
+// NetKey 某个网络的私钥名称, 未配置nets时与name相同, 保持单网络下私钥名称不变
+func NetKey(name string, net string) string {
+	if !config.IsMultiNet() || net == "" {
+		return name
+	}
+	return name + netKeySep + net
+}
+
+// keyPath 私钥名称对应的文件, 带网络名称的私钥存放在该网络的keys/tlsPath下
+func keyPath(name string) (string, error) {
+	if strings.HasSuffix(name, BackupSuffix) {
+		path, err := keyPath(strings.TrimSuffix(name, BackupSuffix))
+		return path + BackupSuffix, err
+	}
+	if strings.HasPrefix(name, KeyLocalCa+localCaKeySep) {
+		file := strings.Replace(strings.TrimPrefix(name, KeyLocalCa+localCaKeySep), localCaKeySep, "_", -1)
+		// 名称中包含网络名和节点地址, 不允许访问keys目录之外的文件
+		if file == "" || strings.ContainsAny(file, `/\`) || strings.HasPrefix(file, ".") {
+			return "", ErrUnknownKey
+		}
+		return config.GetLocalCaPath() + "keys/" + file + ".key", nil
+	}
+	keys, tlsPath := config.GetKeys(), config.GetTlsPath()
+	if i := strings.Index(name, netKeySep); i >= 0 {
+		n := config.GetNetConfig(name[i+len(netKeySep):])
+		name, keys, tlsPath = name[:i], n.Keys, n.TlsPath
+	}
+	switch name {
+	case KeyAccount:
+		return keys + "private.key", nil
+	case KeyTls:
+		return tlsPath + "private.key", nil
+	case KeyHd:
+		return tlsPath + "hd_private.key", nil
+	case KeyLocalCa:
+		return config.GetLocalCaPath() + "ca.key", nil
+	}
+	return "", ErrUnknownKey
+}
+
+// ParsePrivateKey 解析PEM(PKCS8/EC/PKCS1)或xuper json格式的私钥, 国密时还支持PKCS8格式的SM2私钥
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		// 账户私钥为json格式
+		key, err := xcrypto.GetCryptoClient().GetEcdsaPrivateKeyFromJsonStr(string(data))
+		if err != nil {
+			return nil, err
+		}
+		return xcrypto.NewSigner(key), nil
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		switch key := key.(type) {
+		case *ecdsa.PrivateKey:
+			return key, nil
+		case *rsa.PrivateKey:
+			return key, nil
+		}
+		return nil, ErrUnsupportedKey
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if xcrypto.IsGm() {
+		if key, err := xcrypto.ParseSm2PrivateKey(block.Bytes); err == nil {
+			return xcrypto.NewSigner(key), nil
+		}
+	}
+	return nil, ErrUnsupportedKey
+}
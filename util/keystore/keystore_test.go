@@ -0,0 +1,237 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package keystore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	xcrypto "github.com/xuperchain/xuper-front/crypto"
+
+	"github.com/xuperchain/xuper-front/config"
+)
+
+func newAccountKey(t *testing.T) []byte {
+	key, err := xcrypto.GetCryptoClient().CreateNewAccountWithMnemonic(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []byte(key.JsonPrivateKey)
+}
+
+func TestEncryptedKeystore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := config.InstallFrontConfig("../../conf/front.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	config.SetKeys(dir + "/")
+	path := filepath.Join(dir, "private.key")
+
+	plain := newAccountKey(t)
+	if err := ioutil.WriteFile(path, plain, 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("XFRONT_TEST_PASSPHRASE", "secret\n")
+	defer os.Unsetenv("XFRONT_TEST_PASSPHRASE")
+	ks, err := New(config.Keystore{Type: TypeEncrypted, PassphraseEnv: "XFRONT_TEST_PASSPHRASE"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 权限过宽的私钥文件拒绝读取, 不自动修改权限
+	if _, err := ks.Read(KeyAccount); err == nil {
+		t.Fatal("key file with loose permissions should be refused")
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0644 {
+		t.Fatalf("permissions should not be changed, got %v", info.Mode().Perm())
+	}
+	if err := os.Chmod(path, keyFilePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	// 明文私钥读取后被加密写回
+	data, err := ks.Read(KeyAccount)
+	if err != nil || string(data) != string(plain) {
+		t.Fatalf("read plaintext key failed: %v", err)
+	}
+	onDisk, _ := ioutil.ReadFile(path)
+	var key encryptedKey
+	if err := json.Unmarshal(onDisk, &key); err != nil || key.Kdf != "scrypt" {
+		t.Fatal("key should be encrypted on disk")
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != keyFilePerm {
+		t.Fatalf("unexpected perm %v", info.Mode().Perm())
+	}
+	data, err = ks.Read(KeyAccount)
+	if err != nil || string(data) != string(plain) {
+		t.Fatalf("read encrypted key failed: %v", err)
+	}
+
+	wrong := &encryptedKeystore{passphrase: []byte("wrong")}
+	if _, err := wrong.Read(KeyAccount); err == nil {
+		t.Fatal("wrong passphrase should fail")
+	}
+
+	// 签名与xuper crypto的验签兼容
+	signer, err := ks.Signer(KeyAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("0123456789abcdef0123456789abcdef0123456789abcdefnet1600000000")
+	sig, err := signer.Sign(rand.Reader, msg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := xcrypto.GetCryptoClient().VerifyECDSA(signer.Public().(*ecdsa.PublicKey), sig, msg)
+	if err != nil || !ok {
+		t.Fatalf("verify signature failed: %v", err)
+	}
+}
+
+func TestEmptyPassphrase(t *testing.T) {
+	os.Unsetenv("XFRONT_TEST_EMPTY")
+	if _, err := New(config.Keystore{Type: TypeEncrypted, PassphraseEnv: "XFRONT_TEST_EMPTY"}); err != ErrEmptyPassphrase {
+		t.Fatalf("expect ErrEmptyPassphrase, got %v", err)
+	}
+}
+
+func TestLocalCaKeyPath(t *testing.T) {
+	if err := config.InstallFrontConfig("../../conf/front.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	path, err := keyPath(LocalCaKey("node", "net1", "address"))
+	if err != nil || path != config.GetLocalCaPath()+"keys/node_net1_address.key" {
+		t.Fatalf("unexpected path %s: %v", path, err)
+	}
+	// 网络名和地址来自请求, 不能访问keys目录之外的文件
+	for _, name := range []string{LocalCaKey("node", "../net1", "address"), LocalCaKey("node", "net1/..", "address"), LocalCaKey("")} {
+		if _, err := keyPath(name); err != ErrUnknownKey {
+			t.Fatalf("key %s should be rejected, got %v", name, err)
+		}
+	}
+}
+
+func TestGmAccountKey(t *testing.T) {
+	if err := config.InstallFrontConfig("../../conf/front.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	config.SetCryptoType(xcrypto.CryptoTypeGm)
+	defer config.SetCryptoType("")
+
+	account, err := xcrypto.GetCryptoClient().CreateNewAccountWithMnemonic(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ParsePrivateKey([]byte(account.JsonPrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := signer.Public().(*ecdsa.PublicKey)
+	if !xcrypto.IsSm2Key(pub) {
+		t.Fatalf("expect sm2 key, got curve %s", pub.Params().Name)
+	}
+
+	// SM2签名与国密客户端的验签兼容, 地址与账户一致
+	msg := []byte("0123456789abcdef0123456789abcdefnet1600000000")
+	sig, err := signer.Sign(rand.Reader, msg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := xcrypto.GetCryptoClient().VerifyECDSA(pub, sig, msg)
+	if err != nil || !ok {
+		t.Fatalf("verify sm2 signature failed: %v", err)
+	}
+	address, err := xcrypto.GetCryptoClient().GetAddressFromPublicKey(pub)
+	if err != nil || address != account.Address {
+		t.Fatalf("unexpected address %s, expect %s: %v", address, account.Address, err)
+	}
+}
+
+// fakeSignService 签名服务, 记录收到的签名请求
+func fakeSignService(t *testing.T, pub interface{}, requests chan socketSignRequest) (string, func()) {
+	dir, err := ioutil.TempDir("", "socket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "signer.sock")
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/keys/"+KeyAccount+"/public", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&socketPublicKey{PublicKey: der})
+	})
+	mux.HandleFunc("/v1/keys/"+KeyAccount+"/sign", func(w http.ResponseWriter, r *http.Request) {
+		var req socketSignRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		requests <- req
+		json.NewEncoder(w).Encode(&socketSignResponse{Signature: []byte("sig")})
+	})
+	server := &http.Server{Handler: mux}
+	go server.Serve(lis)
+	return path, func() {
+		server.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSocketSigner(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := make(chan socketSignRequest, 1)
+	path, stop := fakeSignService(t, &ecKey.PublicKey, requests)
+	defer stop()
+	ks, err := New(config.Keystore{Type: TypeSocket, Socket: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ks.Signer(KeyAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 摘要算法随请求发给签名服务
+	if _, err := signer.Sign(rand.Reader, make([]byte, 32), crypto.SHA256); err != nil {
+		t.Fatal(err)
+	}
+	if req := <-requests; req.Hash != crypto.SHA256.String() || len(req.Digest) != 32 {
+		t.Fatalf("unexpected sign request %+v", req)
+	}
+
+	// 非ECDSA私钥拒绝使用
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPath, rsaStop := fakeSignService(t, &rsaKey.PublicKey, requests)
+	defer rsaStop()
+	ks, err = New(config.Keystore{Type: TypeSocket, Socket: rsaPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Signer(KeyAccount); err == nil {
+		t.Fatal("rsa key should be rejected")
+	}
+}
@@ -0,0 +1,164 @@
+/*
+ * Copyright (c) 2019. Baidu Inc. All Rights Reserved.
+ */
+package keystore
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	xcrypto "github.com/xuperchain/xuper-front/crypto"
+
+	"github.com/xuperchain/xuper-front/config"
+)
+
+// socketTimeout 访问签名服务的超时时间
+const socketTimeout = 5 * time.Second
+
+// socketKeystore 通过unix socket访问外部签名服务, 私钥不离开签名服务
+// 签名服务需提供以下http接口, 请求和返回均为json, 二进制字段使用base64:
+//
+//	GET  /v1/keys/{name}/public  返回 {"publicKey": PKIX DER}
+//	POST /v1/keys/{name}/sign    请求 {"digest": 待签名数据, "hash": 摘要算法, 未做摘要时为空}, 返回 {"signature": ASN.1签名}
+//	PUT  /v1/keys/{name}         请求 {"privateKey": 私钥原文}, 导入ca签发的私钥
+//
+// 只支持ECDSA(含SM2)私钥, 其他类型的私钥需要PSS等签名参数, 签名服务无法得知, 取公钥时直接拒绝
+type socketKeystore struct {
+	client *http.Client
+}
+
+type socketPublicKey struct {
+	PublicKey []byte `json:"publicKey"`
+}
+
+type socketSignRequest struct {
+	Digest []byte `json:"digest"`
+	Hash   string `json:"hash,omitempty"`
+}
+
+type socketSignResponse struct {
+	Signature []byte `json:"signature"`
+}
+
+type socketImportRequest struct {
+	PrivateKey []byte `json:"privateKey"`
+}
+
+func newSocketKeystore(cfg config.Keystore) (*socketKeystore, error) {
+	if cfg.Socket == "" {
+		return nil, errors.New("keystore socket is not configured")
+	}
+	dialer := &net.Dialer{
+		Timeout: socketTimeout,
+	}
+	return &socketKeystore{
+		client: &http.Client{
+			Timeout: socketTimeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, "unix", cfg.Socket)
+				},
+			},
+		},
+	}, nil
+}
+
+func (k *socketKeystore) Read(name string) ([]byte, error) {
+	return nil, ErrNotExportable
+}
+
+func (k *socketKeystore) Write(name string, data []byte) error {
+	return k.call(http.MethodPut, "/v1/keys/"+name, &socketImportRequest{PrivateKey: data}, nil)
+}
+
+func (k *socketKeystore) Signer(name string) (crypto.Signer, error) {
+	var resp socketPublicKey
+	if err := k.call(http.MethodGet, "/v1/keys/"+name+"/public", nil, &resp); err != nil {
+		return nil, err
+	}
+	pub, err := parsePublicKey(resp.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := pub.(*ecdsa.PublicKey); !ok {
+		return nil, fmt.Errorf("keystore key %s: unsupported public key type %T, only ecdsa keys are supported", name, pub)
+	}
+	return &socketSigner{
+		keystore: k,
+		name:     name,
+		pub:      pub,
+	}, nil
+}
+
+// parsePublicKey 解析签名服务返回的公钥, 国密时标准库无法解析的SM2公钥使用国密解析
+func parsePublicKey(der []byte) (crypto.PublicKey, error) {
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil && xcrypto.IsGm() {
+		if sm2Pub, sm2Err := xcrypto.ParseSm2PublicKey(der); sm2Err == nil {
+			return sm2Pub, nil
+		}
+	}
+	return pub, err
+}
+
+func (k *socketKeystore) call(method string, path string, req interface{}, resp interface{}) error {
+	var body io.Reader
+	if req != nil {
+		data, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	httpReq, err := http.NewRequest(method, "http://keystore"+path, body)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpResp, err := k.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode/100 != 2 {
+		return fmt.Errorf("keystore %s %s: unexpected status %s", method, path, httpResp.Status)
+	}
+	if resp == nil {
+		return nil
+	}
+	return json.NewDecoder(httpResp.Body).Decode(resp)
+}
+
+// socketSigner 由签名服务完成签名的crypto.Signer
+type socketSigner struct {
+	keystore *socketKeystore
+	name     string
+	pub      crypto.PublicKey
+}
+
+func (s *socketSigner) Public() crypto.PublicKey {
+	return s.pub
+}
+
+// Sign 将摘要算法一并发给签名服务, ECDSA签名不需要其他参数
+func (s *socketSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	req := &socketSignRequest{Digest: digest}
+	if opts != nil && opts.HashFunc() != 0 {
+		req.Hash = opts.HashFunc().String()
+	}
+	var resp socketSignResponse
+	if err := s.keystore.call(http.MethodPost, "/v1/keys/"+s.name+"/sign", req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Signature, nil
+}